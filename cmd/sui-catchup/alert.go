@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	alert_lag      = runFlags.Int("alert-lag", 0, "Alert when the node is more than this many checkpoints behind (0 disables)")
+	alert_min_rate = runFlags.Float64("alert-min-rate", 0, "Alert when the node catches up slower than this many checkpoints per second (0 disables)")
+	alert_for      = runFlags.Duration("alert-for", time.Minute, "How long a threshold must be crossed before alerting")
+	alert_stall    = runFlags.Duration("alert-stall", 0, "Alert when the synced checkpoint has not advanced for this long (0 disables)")
+
+	alert_lag_clear      = runFlags.Int("alert-lag-clear", 0, "Resolve an alert only once the node is at most this many checkpoints behind (default: -alert-lag)")
+	alert_min_rate_clear = runFlags.Float64("alert-min-rate-clear", 0, "Resolve an alert only once the node catches up at least this many checkpoints per second (default: -alert-min-rate)")
+	alert_clear_for      = runFlags.Duration("alert-clear-for", time.Minute, "How long the node must be clear of the thresholds before an alert resolves")
+)
+
+// alertReason describes which alert threshold p crosses, if any. The rate
+// only matters while catching up.
+func alertReason(p catchup.Progress) string {
+	switch {
+	case p.Err != nil:
+		return ""
+	case *alert_lag > 0 && p.Lag > float64(*alert_lag):
+		return fmt.Sprintf("%d checkpoints behind, more than %d", int64(p.Lag), *alert_lag)
+	case *alert_min_rate > 0 && !p.CaughtUp && p.Rate < *alert_min_rate:
+		return fmt.Sprintf("catching up at %.1f/s, slower than %g/s", p.Rate, *alert_min_rate)
+	default:
+		return ""
+	}
+}
+
+// Alert states of a node. An incident enters lagging or stalled, escalating
+// from lagging to stalled, and ends in recovered, which settles to ok once
+// the node stays clear for -alert-clear-for again.
+const (
+	alertOK        = "ok"
+	alertLagging   = "lagging"
+	alertStalled   = "stalled"
+	alertRecovered = "recovered"
+)
+
+// checkAlertFlags validates the exit thresholds against the enter thresholds.
+func checkAlertFlags() error {
+	if *alert_lag_clear > *alert_lag {
+		return fmt.Errorf("-alert-lag-clear %d must be at most -alert-lag %d", *alert_lag_clear, *alert_lag)
+	}
+	if *alert_min_rate_clear > 0 && *alert_min_rate_clear < *alert_min_rate {
+		return fmt.Errorf("-alert-min-rate-clear %g must be at least -alert-min-rate %g", *alert_min_rate_clear, *alert_min_rate)
+	}
+	return nil
+}
+
+// alertStalledFor reports whether p has not advanced for -alert-stall.
+func alertStalledFor(p catchup.Progress) bool {
+	return *alert_stall > 0 && !p.CaughtUp && p.StalledFor >= *alert_stall
+}
+
+// alertCleared reports whether p is clear of the exit thresholds, which lie
+// below the enter thresholds so that a node hovering around them does not
+// flap.
+func alertCleared(p catchup.Progress) bool {
+	lag, rate := *alert_lag, *alert_min_rate
+	if *alert_lag_clear > 0 {
+		lag = *alert_lag_clear
+	}
+	if *alert_min_rate_clear > 0 {
+		rate = *alert_min_rate_clear
+	}
+	switch {
+	case alertStalledFor(p):
+		return false
+	case lag > 0 && p.Lag > float64(lag):
+		return false
+	case rate > 0 && !p.CaughtUp && p.Rate < rate:
+		return false
+	default:
+		return true
+	}
+}
+
+// alerter is the alert state machine, sending one alert when a node enters
+// an incident and one resolution when it leaves it, however noisy the
+// samples in between.
+type alerter struct {
+	state string
+	// since is when the current crossing of the enter or, during an
+	// incident, exit thresholds started, zero if none.
+	since time.Time
+	// changed is when the state last changed.
+	changed time.Time
+}
+
+// update advances the state by p and returns the kind of event to send for
+// it, if any, and the reason. Failed scrapes neither raise nor resolve an
+// alert.
+func (a *alerter) update(p catchup.Progress) (kind, reason string) {
+	if p.Err != nil {
+		return "", ""
+	}
+	if a.state == "" {
+		a.state = alertOK
+	}
+	stalled := alertStalledFor(p)
+	switch a.state {
+	case alertOK, alertRecovered:
+		reason = alertReason(p)
+		if stalled {
+			// A stall has lasted -alert-stall already.
+			a.enter(alertStalled, p.Time)
+			return eventAlert, fmt.Sprintf("stalled at checkpoint %d for %s", int64(p.Synced), formatETA(p.StalledFor))
+		}
+		if reason == "" {
+			a.since = time.Time{}
+			if a.state == alertRecovered && p.Time.Sub(a.changed) >= *alert_clear_for {
+				a.enter(alertOK, p.Time)
+			}
+			return "", ""
+		}
+		if a.since.IsZero() {
+			a.since = p.Time
+		}
+		if p.Time.Sub(a.since) >= *alert_for {
+			a.enter(alertLagging, p.Time)
+			return eventAlert, fmt.Sprintf("%s for %s", reason, formatETA(*alert_for))
+		}
+	case alertLagging, alertStalled:
+		// Escalating or easing within an incident sends nothing.
+		if stalled {
+			a.state = alertStalled
+		} else if a.state == alertStalled && alertReason(p) != "" {
+			a.state = alertLagging
+		}
+		if !alertCleared(p) {
+			a.since = time.Time{}
+			return "", ""
+		}
+		if a.since.IsZero() {
+			a.since = p.Time
+		}
+		if p.Time.Sub(a.since) >= *alert_clear_for {
+			a.enter(alertRecovered, p.Time)
+			return eventAlertResolved, ""
+		}
+	}
+	return "", ""
+}
+
+// enter moves to state at.
+func (a *alerter) enter(state string, at time.Time) {
+	a.state, a.since, a.changed = state, time.Time{}, at
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	alertmanager_url         = runFlags.String("alertmanager-url", "", "Comma-separated Alertmanager cluster members, e.g. http://am-0:9093,http://am-1:9093, to post alerts to through the v2 API when the node stalls, falls behind or crosses an alert threshold")
+	alertmanager_labels      = runFlags.String("alertmanager-labels", "", "Comma-separated name=value labels added to the alerts for routing, e.g. severity=critical,team=infra")
+	alertmanager_annotations = runFlags.String("alertmanager-annotations", "", "Comma-separated name=value annotations added to the alerts, e.g. runbook_url=https://wiki/catchup")
+	alertmanager_resend      = runFlags.Duration("alertmanager-resend", time.Minute, "How often firing alerts are sent to Alertmanager again, which resolves them 4 intervals after they were last sent")
+)
+
+// Alert names of the alerts posted to Alertmanager, one for the node being
+// behind and one for the alert thresholds, like the dedup keys of
+// pagerDutyNotifier.
+const (
+	alertNameBehind    = "SuiNodeBehind"
+	alertNameThreshold = "SuiNodeCatchupAlert"
+)
+
+// alertmanagerAlert is an alert of the Alertmanager v2 API.
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// alertmanagerNotifier posts alerts to every member of an Alertmanager
+// cluster, which deduplicates them, and keeps sending the firing ones as
+// Prometheus does so that Alertmanager does not resolve them by timeout.
+type alertmanagerNotifier struct {
+	urls        []string
+	labels      map[string]string
+	annotations map[string]string
+
+	mu sync.Mutex
+	// firing are the alerts firing by alert name.
+	firing map[string]alertmanagerAlert
+}
+
+func newAlertmanagerNotifier() (*alertmanagerNotifier, error) {
+	n := &alertmanagerNotifier{labels: map[string]string{}, annotations: map[string]string{}, firing: map[string]alertmanagerAlert{}}
+	for _, member := range splitList(*alertmanager_url) {
+		u, err := url.Parse(member)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid -alertmanager-url %q", member)
+		}
+		n.urls = append(n.urls, strings.TrimSuffix(u.String(), "/")+"/api/v2/alerts")
+	}
+	if len(n.urls) == 0 {
+		return nil, fmt.Errorf("invalid -alertmanager-url %q", *alertmanager_url)
+	}
+	if err := parseLabels(n.labels, "-alertmanager-labels", *alertmanager_labels); err != nil {
+		return nil, err
+	}
+	if err := parseLabels(n.annotations, "-alertmanager-annotations", *alertmanager_annotations); err != nil {
+		return nil, err
+	}
+	if *alertmanager_resend <= 0 {
+		return nil, fmt.Errorf("invalid -alertmanager-resend %v, must be positive", *alertmanager_resend)
+	}
+	go n.resend()
+	return n, nil
+}
+
+// parseLabels adds the comma-separated name=value pairs of s to m, naming
+// option in errors.
+func parseLabels(m map[string]string, option, s string) error {
+	for _, l := range splitList(s) {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return fmt.Errorf("invalid %s entry %q, must be name=value", option, l)
+		}
+		m[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return nil
+}
+
+func (n *alertmanagerNotifier) notify(ctx context.Context, ev event) error {
+	var name string
+	var firing bool
+	switch ev.Kind {
+	case eventFellBehind, eventStalled:
+		name, firing = alertNameBehind, true
+	case eventRecovered:
+		name = alertNameBehind
+	case eventAlert:
+		name, firing = alertNameThreshold, true
+	case eventAlertResolved:
+		name = alertNameThreshold
+	default:
+		return nil
+	}
+	n.mu.Lock()
+	alert, ok := n.firing[name]
+	if firing {
+		labels := map[string]string{"alertname": name, "job": "sui_catchup", "node": ev.Node}
+		for k, v := range ev.Tags {
+			labels[k] = v
+		}
+		for k, v := range n.labels {
+			labels[k] = v
+		}
+		annotations := map[string]string{"summary": ev.String()}
+		if ev.Reason != "" {
+			annotations["reason"] = ev.Reason
+		}
+		for k, v := range n.annotations {
+			annotations[k] = v
+		}
+		// A firing alert has to keep its start to stay the same alert.
+		start := ev.Time
+		if ok {
+			start = alert.StartsAt
+		}
+		alert = alertmanagerAlert{Labels: labels, Annotations: annotations, StartsAt: start}
+		n.firing[name] = alert
+	} else {
+		delete(n.firing, name)
+	}
+	n.mu.Unlock()
+	if !firing && !ok {
+		// Nothing was posted to resolve.
+		return nil
+	}
+	alert.EndsAt = ev.Time
+	if firing {
+		alert.EndsAt = time.Now().Add(4 * *alertmanager_resend)
+	}
+	return n.post(ctx, []alertmanagerAlert{alert})
+}
+
+// resend posts the firing alerts every -alertmanager-resend.
+func (n *alertmanagerNotifier) resend() {
+	for range time.Tick(*alertmanager_resend) {
+		n.mu.Lock()
+		var alerts []alertmanagerAlert
+		for _, alert := range n.firing {
+			alert.EndsAt = time.Now().Add(4 * *alertmanager_resend)
+			alerts = append(alerts, alert)
+		}
+		n.mu.Unlock()
+		if len(alerts) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := n.post(ctx, alerts); err != nil {
+			slog.Warn("Sending notification failed", "err", err)
+		}
+		cancel()
+	}
+}
+
+// post posts alerts to every member of the cluster, failing only if none
+// accepted them.
+func (n *alertmanagerNotifier) post(ctx context.Context, alerts []alertmanagerAlert) error {
+	errs := make([]error, len(n.urls))
+	var wg sync.WaitGroup
+	for i, u := range n.urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			if err := postJSON(ctx, u, nil, alerts); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", u, err)
+			}
+		}(i, u)
+	}
+	wg.Wait()
+	var msgs []string
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("alertmanager: %s", strings.Join(msgs, "; "))
+}
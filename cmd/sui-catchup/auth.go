@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+var (
+	basic_auth    = transportFlags.String("basic-auth", "", "Credentials for the metrics endpoint as user:password")
+	bearer_token  = transportFlags.String("bearer-token", "", "Bearer token for the metrics endpoint")
+	extra_headers headerFlag
+)
+
+func init() {
+	transportFlags.Var(&extra_headers, "header", "Extra header for requests to the metrics endpoint as 'Name: value' (repeatable)")
+}
+
+// headerFlag collects repeated -header flags.
+type headerFlag []string
+
+func (h *headerFlag) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlag) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q is not of the form 'Name: value'", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// authTransport adds credentials and extra headers to requests for the
+// node's hosts, so that they never leak to the other endpoints sharing the
+// transport, such as a public RPC tip.
+type authTransport struct {
+	base   http.RoundTripper
+	hosts  map[string]bool
+	header http.Header
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the caller's request.
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *authTransport) CloseIdleConnections() {
+	(&http.Client{Transport: t.base}).CloseIdleConnections()
+}
+
+// authHeader returns the credentials and extra headers for requests to the
+// node, from -header, -bearer-token and -basic-auth.
+func authHeader() (http.Header, error) {
+	header := http.Header{}
+	for _, h := range extra_headers {
+		i := strings.Index(h, ":")
+		header.Add(textproto.TrimString(h[:i]), textproto.TrimString(h[i+1:]))
+	}
+
+	token := *bearer_token
+	if token != "" && *basic_auth != "" {
+		return nil, errors.New("only one of -basic-auth and a bearer token may be specified")
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	if *basic_auth != "" {
+		i := strings.Index(*basic_auth, ":")
+		if i < 0 {
+			return nil, errors.New("-basic-auth must be of the form user:password")
+		}
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth((*basic_auth)[:i], (*basic_auth)[i+1:])
+		header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	return header, nil
+}
+
+// withAuth wraps base to add header to requests to hosts, those of the
+// metrics address and its fallbacks, or returns base unchanged if header is
+// empty.
+func withAuth(base http.RoundTripper, hosts map[string]bool, header http.Header) http.RoundTripper {
+	if len(header) == 0 {
+		return base
+	}
+	return &authTransport{base: base, hosts: hosts, header: header}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestAuthOnlyForNode(t *testing.T) {
+	var got string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	})
+	node := httptest.NewServer(handler)
+	defer node.Close()
+	fallback := httptest.NewServer(handler)
+	defer fallback.Close()
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	*bearer_token = "secret"
+	defer func() { *bearer_token = "" }()
+	transport, _, addr, err := newTransport(node.URL+"/metrics", []string{fallback.URL + "/metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := http.Client{Transport: transport}
+	tests := []struct {
+		url, want string
+	}{
+		{addr, "Bearer secret"},
+		{fallback.URL + "/metrics", "Bearer secret"},
+		{other.URL, ""},
+	}
+	for _, tt := range tests {
+		got = ""
+		resp, err := client.Get(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got != tt.want {
+			t.Errorf("GET %s: got Authorization %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestAuthForGRPC(t *testing.T) {
+	var got string
+	node := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		// GetServiceInfo answering checkpoint 1000.
+		msg := protowire.AppendVarint(protowire.AppendTag(nil, 4, protowire.VarintType), 1000)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...))
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer node.Close()
+	tip := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"1000"}`)
+	}))
+	defer tip.Close()
+
+	*bearer_token = "secret"
+	defer func() { *bearer_token = "" }()
+	transport, dialer, addr, err := newTransport(node.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := catchup.New(catchup.Options{
+		Addr:              addr,
+		Source:            catchup.SourceGRPC,
+		TipURL:            tip.URL,
+		Transport:         transport,
+		GRPCDialContext:   dialer.dial,
+		GRPCTLSConfig:     dialer.tlsConfig,
+		WrapGRPCTransport: dialer.wrap,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got != "Bearer secret" {
+		t.Errorf("got Authorization %q, want %q", got, "Bearer secret")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/mattn/go-isatty"
+)
+
+var bell = runFlags.Bool("bell", false, "Ring the terminal bell when the node catches up, stalls or falls behind")
+
+// bellNotifier rings the terminal bell on events worth looking up for.
+type bellNotifier struct {
+	w io.Writer
+}
+
+// newBellNotifier returns a notifier ringing the bell of the terminal on
+// standard output or error, or false if neither is one.
+func newBellNotifier() (bellNotifier, bool) {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()) {
+			return bellNotifier{w: f}, true
+		}
+	}
+	return bellNotifier{}, false
+}
+
+func (b bellNotifier) notify(ctx context.Context, ev event) error {
+	switch ev.Kind {
+	case eventCaughtUp, eventStalled, eventFellBehind:
+		_, err := io.WriteString(b.w, "\a")
+		return err
+	}
+	return nil
+}
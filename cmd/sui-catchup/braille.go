@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	chart_span   = runFlags.Duration("chart", 0, "Show a chart of the lag over this recent span, e.g. 10m, below the status on a terminal (0 disables)")
+	chart_width  = runFlags.Int("chart-width", 60, "Width of the -chart chart in columns")
+	chart_height = runFlags.Int("chart-height", 5, "Height of the -chart chart in rows")
+)
+
+// brailleDots are the bits of the dots of a braille character by column and
+// row, from the top left.
+var brailleDots = [2][4]rune{{0x01, 0x02, 0x04, 0x40}, {0x08, 0x10, 0x20, 0x80}}
+
+// chartSample is a value of a brailleChart at a time.
+type chartSample struct {
+	t time.Time
+	v float64
+}
+
+// brailleChart keeps the values of a recent span and renders them as a line
+// of braille dots, each character holding 2 by 4 of them, scaled between
+// their minimum, or zero if lower, and their maximum.
+type brailleChart struct {
+	span          time.Duration
+	width, height int
+	samples       []chartSample
+}
+
+func newBrailleChart(span time.Duration, width, height int) *brailleChart {
+	return &brailleChart{span: span, width: width, height: height}
+}
+
+// add appends v at t, dropping the values that have left the span.
+func (c *brailleChart) add(t time.Time, v float64) {
+	c.samples = append(c.samples, chartSample{t, v})
+	i := 0
+	for i < len(c.samples)-1 && t.Sub(c.samples[i].t) > c.span {
+		i++
+	}
+	c.samples = c.samples[i:]
+}
+
+// lines renders the chart as of now, labeled with its maximum and minimum
+// on the left and the span below.
+func (c *brailleChart) lines(now time.Time) []string {
+	if len(c.samples) < 2 || c.width <= 0 || c.height <= 0 {
+		return nil
+	}
+	min, max := 0.0, c.samples[0].v
+	for _, s := range c.samples {
+		if s.v < min {
+			min = s.v
+		}
+		if s.v > max {
+			max = s.v
+		}
+	}
+	cols, rows := 2*c.width, 4*c.height
+	// level is the dot row of a value, 0 at the top.
+	level := func(v float64) int {
+		if max <= min {
+			return rows - 1
+		}
+		return rows - 1 - int((v-min)/(max-min)*float64(rows-1)+0.5)
+	}
+	// The samples are joined by straight lines, from one dot column to the
+	// next, so that steep changes read as a line rather than scattered
+	// dots.
+	grid := make([][]rune, c.height)
+	for i := range grid {
+		grid[i] = make([]rune, c.width)
+	}
+	set := func(col, row int) {
+		if col >= 0 && col < cols {
+			grid[row/4][col/2] |= brailleDots[col%2][row%4]
+		}
+	}
+	start := now.Add(-c.span)
+	column := func(t time.Time) int {
+		col := int(float64(t.Sub(start)) / float64(c.span) * float64(cols))
+		if col >= cols {
+			return cols - 1
+		}
+		return col
+	}
+	prevCol, prevLevel := column(c.samples[0].t), level(c.samples[0].v)
+	set(prevCol, prevLevel)
+	for _, s := range c.samples[1:] {
+		col, l := column(s.t), level(s.v)
+		for x := prevCol + 1; x <= col; x++ {
+			// The levels of this column run from where the previous one
+			// ended to the interpolated one.
+			y := prevLevel + (l-prevLevel)*(x-prevCol)/(col-prevCol)
+			from, to := prevLevel+(l-prevLevel)*(x-1-prevCol)/(col-prevCol), y
+			if from > to {
+				from, to = to, from
+			}
+			for row := from; row <= to; row++ {
+				set(x, row)
+			}
+		}
+		prevCol, prevLevel = col, l
+	}
+	labels := make([]string, c.height)
+	labels[0], labels[c.height-1] = formatCount(max), formatCount(min)
+	width := len(labels[0])
+	if n := len(labels[c.height-1]); n > width {
+		width = n
+	}
+	lines := make([]string, 0, c.height+1)
+	for i, row := range grid {
+		for j, r := range row {
+			row[j] = 0x2800 + r
+		}
+		lines = append(lines, fmt.Sprintf("%*s ┤%s", width, labels[i], string(row)))
+	}
+	span := "-" + formatETA(c.span)
+	gap := c.width - len(span) - len("now")
+	if gap < 1 {
+		gap = 1
+	}
+	lines = append(lines, fmt.Sprintf("%*s  %s%s%s", width, "", span, strings.Repeat(" ", gap), "now"))
+	return lines
+}
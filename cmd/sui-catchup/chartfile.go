@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var chart_file = runFlags.String("chart-file", "", "On exit, render the session's lag and rate over time to this .svg or .png file, e.g. to attach to a ticket")
+
+// maxChartPoints bounds the samples kept for -chart-file, which are thinned
+// out to every other one whenever there are more, far more than a chart
+// can show apart.
+const maxChartPoints = 4000
+
+// Size of the -chart-file image and the margins around its two panels.
+const (
+	chartWidth  = 960
+	chartHeight = 540
+	chartMargin = 40
+)
+
+// chartPoint is a sample of the -chart-file series.
+type chartPoint struct {
+	t         time.Time
+	lag, rate float64
+}
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *chart_file == "" {
+			return nil, nil
+		}
+		return newChartRecorder(*chart_file)
+	})
+}
+
+// chartRecorder records the series rendered to -chart-file once closed.
+type chartRecorder struct {
+	path   string
+	render func(io.Writer, chartDrawing) error
+	points []chartPoint
+	// every is how many successful scrapes a point stands for, doubled
+	// whenever the points are thinned out, and skipped those skipped since
+	// the last point.
+	every, skipped int
+}
+
+// newChartRecorder returns a recorder rendering to path, as SVG or PNG by its
+// extension.
+func newChartRecorder(path string) (*chartRecorder, error) {
+	c := &chartRecorder{path: path, every: 1}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg":
+		c.render = renderSVG
+	case ".png":
+		c.render = renderPNG
+	default:
+		return nil, fmt.Errorf("unknown format of -chart-file %q, must end with .svg or .png", path)
+	}
+	return c, nil
+}
+
+func (c *chartRecorder) update(p catchup.Progress) error {
+	if p.Err != nil {
+		return nil
+	}
+	if c.skipped++; c.skipped < c.every {
+		return nil
+	}
+	c.skipped = 0
+	c.points = append(c.points, chartPoint{p.Time, p.Lag, p.Rate})
+	if len(c.points) > maxChartPoints {
+		thinned := c.points[:0]
+		for i := 0; i < len(c.points); i += 2 {
+			thinned = append(thinned, c.points[i])
+		}
+		c.points = thinned
+		c.every *= 2
+	}
+	return nil
+}
+
+// chartText is a label of the chart at a baseline's left end.
+type chartText struct {
+	x, y int
+	text string
+}
+
+// chartDrawing is the chart laid out in pixels, drawn by both renderers:
+// the frames of the panels, their series as polylines and the labels.
+type chartDrawing struct {
+	frames []image.Rectangle
+	lines  [][]image.Point
+	texts  []chartText
+}
+
+// layout lays the series out in two panels, the lag above the rate, each
+// scaled from zero to its maximum over the session's time span.
+func (c *chartRecorder) layout(title string) chartDrawing {
+	var d chartDrawing
+	d.texts = append(d.texts, chartText{chartMargin, chartMargin/2 - 4, title})
+	if len(c.points) == 0 {
+		return d
+	}
+	start, end := c.points[0].t, c.points[len(c.points)-1].t
+	span := end.Sub(start).Seconds()
+	panelHeight := (chartHeight - 3*chartMargin) / 2
+	series := []struct {
+		label string
+		value func(chartPoint) float64
+		unit  string
+	}{
+		{"lag", func(p chartPoint) float64 { return p.lag }, ""},
+		{"rate", func(p chartPoint) float64 { return p.rate }, "/s"},
+	}
+	for i, s := range series {
+		top := chartMargin + i*(panelHeight+chartMargin)
+		frame := image.Rect(chartMargin, top, chartWidth-chartMargin, top+panelHeight)
+		max := 0.0
+		for _, p := range c.points {
+			if v := s.value(p); v > max {
+				max = v
+			}
+		}
+		var line []image.Point
+		for _, p := range c.points {
+			x := frame.Min.X
+			if span > 0 {
+				x += int(p.t.Sub(start).Seconds() / span * float64(frame.Dx()))
+			}
+			y := frame.Max.Y
+			if v := s.value(p); max > 0 && v > 0 {
+				y -= int(v / max * float64(frame.Dy()))
+			}
+			line = append(line, image.Pt(x, y))
+		}
+		d.frames = append(d.frames, frame)
+		d.lines = append(d.lines, line)
+		d.texts = append(d.texts,
+			chartText{frame.Min.X, frame.Min.Y - 6, fmt.Sprintf("%s, max %s%s", s.label, formatCount(max), s.unit)},
+			chartText{frame.Min.X, frame.Max.Y + 16, start.Format("15:04")},
+			chartText{frame.Max.X - 60, frame.Max.Y + 16, "+" + formatETA(end.Sub(start))},
+		)
+	}
+	return d
+}
+
+// Close renders the chart to its path.
+func (c *chartRecorder) Close() error {
+	title := fmt.Sprintf("%s catch-up", nodeName())
+	if len(c.points) > 0 {
+		title += " from " + c.points[0].t.Format("2006-01-02 15:04")
+	}
+	f, err := os.Create(c.path)
+	if err != nil {
+		return fmt.Errorf("writing -chart-file failed: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	err = c.render(w, c.layout(title))
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing -chart-file failed: %v", err)
+	}
+	return nil
+}
+
+// renderSVG writes d as an SVG document.
+func renderSVG(w io.Writer, d chartDrawing) error {
+	_, _ = fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="13">`+"\n",
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	_, _ = fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	for _, r := range d.frames {
+		_, _ = fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#bbb"/>`+"\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+	}
+	colors := []string{"#d62728", "#1f77b4"}
+	for i, line := range d.lines {
+		points := make([]string, len(line))
+		for j, p := range line {
+			points[j] = fmt.Sprintf("%d,%d", p.X, p.Y)
+		}
+		_, _ = fmt.Fprintf(w, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`+"\n", strings.Join(points, " "), colors[i%len(colors)])
+	}
+	for _, t := range d.texts {
+		_, _ = fmt.Fprintf(w, `<text x="%d" y="%d" fill="#333">%s</text>`+"\n", t.x, t.y, html.EscapeString(t.text))
+	}
+	_, err := fmt.Fprintf(w, "</svg>\n")
+	return err
+}
+
+// renderPNG writes d as a PNG image, its labels in a built-in pixel font.
+func renderPNG(w io.Writer, d chartDrawing) error {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	gray := color.RGBA{0xbb, 0xbb, 0xbb, 0xff}
+	for _, r := range d.frames {
+		drawLine(img, r.Min, image.Pt(r.Max.X, r.Min.Y), gray)
+		drawLine(img, image.Pt(r.Max.X, r.Min.Y), r.Max, gray)
+		drawLine(img, r.Max, image.Pt(r.Min.X, r.Max.Y), gray)
+		drawLine(img, image.Pt(r.Min.X, r.Max.Y), r.Min, gray)
+	}
+	colors := []color.RGBA{{0xd6, 0x27, 0x28, 0xff}, {0x1f, 0x77, 0xb4, 0xff}}
+	for i, line := range d.lines {
+		for j := 1; j < len(line); j++ {
+			drawLine(img, line[j-1], line[j], colors[i%len(colors)])
+		}
+		if len(line) == 1 {
+			img.Set(line[0].X, line[0].Y, colors[i%len(colors)])
+		}
+	}
+	for _, t := range d.texts {
+		drawText(img, t.x, t.y, t.text, color.RGBA{0x33, 0x33, 0x33, 0xff})
+	}
+	return png.Encode(w, img)
+}
+
+// drawLine draws a line from a to b with Bresenham's algorithm.
+func drawLine(img *image.RGBA, a, b image.Point, c color.RGBA) {
+	dx, dy := abs(b.X-a.X), -abs(b.Y-a.Y)
+	sx, sy := 1, 1
+	if a.X > b.X {
+		sx = -1
+	}
+	if a.Y > b.Y {
+		sy = -1
+	}
+	for e := dx + dy; ; {
+		img.SetRGBA(a.X, a.Y, c)
+		if a == b {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			a.X += sx
+		}
+		if e2 <= dx {
+			e += dx
+			a.Y += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// chartFont has glyphs of 3x5 pixels, as rows from the top, for digits,
+// letters, drawn in upper case, and the punctuation of the labels. Other
+// characters are left blank.
+var chartFont = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'/': {"..#", "..#", ".#.", "#..", "#.."},
+	'.': {"...", "...", "...", "...", ".#."},
+	',': {"...", "...", "...", ".#.", "#.."},
+	'-': {"...", "...", "###", "...", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	'A': {".#.", "#.#", "###", "#.#", "#.#"},
+	'C': {"###", "#..", "#..", "#..", "###"},
+	'D': {"##.", "#.#", "#.#", "#.#", "##."},
+	'E': {"###", "#..", "##.", "#..", "###"},
+	'F': {"###", "#..", "##.", "#..", "#.."},
+	'G': {"###", "#..", "#.#", "#.#", "###"},
+	'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'K': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'L': {"#..", "#..", "#..", "#..", "###"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'N': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'O': {"###", "#.#", "#.#", "#.#", "###"},
+	'P': {"###", "#.#", "###", "#..", "#.."},
+	'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+	'S': {".##", "#..", ".#.", "..#", "##."},
+	'T': {"###", ".#.", ".#.", ".#.", ".#."},
+	'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+	'B': {"##.", "#.#", "##.", "#.#", "##."},
+	'I': {"###", ".#.", ".#.", ".#.", "###"},
+	'J': {"..#", "..#", "..#", "#.#", "###"},
+	'Q': {"###", "#.#", "#.#", "###", "..#"},
+	'V': {"#.#", "#.#", "#.#", "#.#", ".#."},
+	'W': {"#.#", "#.#", "###", "###", "#.#"},
+	'Y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+	'Z': {"###", "..#", ".#.", "#..", "###"},
+	'_': {"...", "...", "...", "...", "###"},
+}
+
+// drawText draws s with its baseline at y, its pixels doubled.
+func drawText(img *image.RGBA, x, y int, s string, c color.RGBA) {
+	const scale = 2
+	for _, r := range strings.ToUpper(s) {
+		for row, bits := range chartFont[r] {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				for i := 0; i < scale*scale; i++ {
+					img.SetRGBA(x+col*scale+i%scale, y-(5-row)*scale+i/scale, c)
+				}
+			}
+		}
+		x += 4 * scale
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	telegram_token  = runFlags.String("telegram-bot-token", "", "Send notifications through this Telegram bot")
+	telegram_chat   = runFlags.String("telegram-chat-id", "", "Telegram chat to send notifications to")
+	discord_token   = runFlags.String("discord-bot-token", "", "Send notifications through this Discord bot")
+	discord_channel = runFlags.String("discord-channel-id", "", "Discord channel to send notifications to")
+)
+
+// telegramNotifier sends events as messages from a Telegram bot.
+type telegramNotifier struct {
+	token string
+	chat  string
+}
+
+func (t telegramNotifier) notify(ctx context.Context, ev event) error {
+	u := "https://api.telegram.org/bot" + url.PathEscape(t.token) + "/sendMessage"
+	err := postJSON(ctx, u, nil, map[string]string{"chat_id": t.chat, "text": ev.String()})
+	if err != nil {
+		// The token is part of the URL, which errors may quote.
+		return fmt.Errorf("telegram: %s", strings.ReplaceAll(err.Error(), t.token, "<token>"))
+	}
+	return nil
+}
+
+// discordNotifier sends events as messages from a Discord bot.
+type discordNotifier struct {
+	token   string
+	channel string
+}
+
+func (d discordNotifier) notify(ctx context.Context, ev event) error {
+	u := "https://discord.com/api/v10/channels/" + url.PathEscape(d.channel) + "/messages"
+	header := http.Header{"Authorization": {"Bot " + d.token}}
+	if err := postJSON(ctx, u, header, map[string]string{"content": ev.String()}); err != nil {
+		return fmt.Errorf("discord: %v", err)
+	}
+	return nil
+}
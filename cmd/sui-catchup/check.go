@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	check_warning  = checkFlags.String("w", "", "Warning thresholds of the check command as Nagios ranges of the lag and, after a comma, the rate, e.g. 100 or 100,5:")
+	check_critical = checkFlags.String("c", "", "Critical thresholds of the check command as Nagios ranges of the lag and, after a comma, the rate, e.g. 1000 or 1000,1:")
+	check_sample   = checkFlags.Duration("check-sample", 0, "How long the check command waits for a second scrape to measure the rate (default: -interval if a rate threshold is given, else no second scrape)")
+)
+
+// Exit codes of the check command, as Nagios and Icinga expect of plugins.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosRange is a threshold in the Nagios plugin range format: a value
+// alerts when outside start:end, or inside it with a leading @. An end alone
+// means 0:end, a start alone start:, and ~ an unbounded start.
+type nagiosRange struct {
+	text       string
+	start, end float64
+	inside     bool
+}
+
+func parseNagiosRange(s string) (*nagiosRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	r := &nagiosRange{text: s, end: math.Inf(1)}
+	if strings.HasPrefix(s, "@") {
+		r.inside, s = true, s[1:]
+	}
+	start, end, ok := strings.Cut(s, ":")
+	if !ok {
+		start, end = "", s
+	}
+	var err error
+	switch start {
+	case "":
+	case "~":
+		r.start = math.Inf(-1)
+	default:
+		if r.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, fmt.Errorf("invalid range %q", r.text)
+		}
+	}
+	if end != "" {
+		if r.end, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, fmt.Errorf("invalid range %q", r.text)
+		}
+	}
+	if r.start > r.end {
+		return nil, fmt.Errorf("invalid range %q, its start is above its end", r.text)
+	}
+	return r, nil
+}
+
+// alerts reports whether v crosses the threshold. An unset threshold never
+// does.
+func (r *nagiosRange) alerts(v float64) bool {
+	if r == nil {
+		return false
+	}
+	outside := v < r.start || v > r.end
+	return outside != r.inside
+}
+
+func (r *nagiosRange) String() string {
+	if r == nil {
+		return ""
+	}
+	return r.text
+}
+
+// checkThresholds are the lag and rate ranges of -w or -c.
+type checkThresholds struct {
+	lag, rate *nagiosRange
+}
+
+func parseCheckThresholds(option, s string) (checkThresholds, error) {
+	var t checkThresholds
+	lag, rate, _ := strings.Cut(s, ",")
+	var err error
+	if t.lag, err = parseNagiosRange(strings.TrimSpace(lag)); err != nil {
+		return t, fmt.Errorf("invalid %s lag threshold: %v", option, err)
+	}
+	if t.rate, err = parseNagiosRange(strings.TrimSpace(rate)); err != nil {
+		return t, fmt.Errorf("invalid %s rate threshold: %v", option, err)
+	}
+	return t, nil
+}
+
+// crossed describes the thresholds of t that p crosses, if any. The rate
+// only matters while catching up, and only if measured.
+func (t checkThresholds) crossed(p catchup.Progress, sampled bool) []string {
+	var reasons []string
+	if t.lag.alerts(p.Lag) {
+		reasons = append(reasons, fmt.Sprintf("lag %d outside %s", int64(p.Lag), t.lag))
+	}
+	if sampled && !p.CaughtUp && t.rate.alerts(p.Rate) {
+		reasons = append(reasons, fmt.Sprintf("rate %.1f/s outside %s", p.Rate, t.rate))
+	}
+	return reasons
+}
+
+// runCheck implements the check command, a Nagios and Icinga plugin printing
+// the state with perfdata of the lag and, if measured, the rate.
+func runCheck() int {
+	code, line := check()
+	fmt.Printf("SUI-CATCHUP %s - %s\n", nagiosStates[code], redact(line))
+	return code
+}
+
+func check() (int, string) {
+	warning, err := parseCheckThresholds("-w", *check_warning)
+	if err != nil {
+		return nagiosUnknown, err.Error()
+	}
+	critical, err := parseCheckThresholds("-c", *check_critical)
+	if err != nil {
+		return nagiosUnknown, err.Error()
+	}
+	sample := *check_sample
+	if sample == 0 && (warning.rate != nil || critical.rate != nil) {
+		sample = *update_interval
+	}
+
+	forward, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, err := watchAddr(forward)
+	if err != nil {
+		return nagiosUnknown, err.Error()
+	}
+	watcher, err := newWatcher(addr, 0)
+	if err != nil {
+		return nagiosUnknown, err.Error()
+	}
+	p, err := watcher.Check(forward)
+	sampled := err == nil && sample > 0 && !p.CaughtUp
+	if sampled {
+		time.Sleep(sample)
+		p, err = watcher.Check(forward)
+	}
+	if err != nil {
+		return nagiosUnknown, fmt.Sprintf("fetching metrics failed: %v", err)
+	}
+	result.observe(p)
+
+	code := nagiosOK
+	status := fmt.Sprintf("%s %d checkpoints behind", nodeName(), int64(p.Lag))
+	if p.CaughtUp {
+		status = fmt.Sprintf("%s caught up, %d checkpoints behind", nodeName(), int64(p.Lag))
+	} else if sampled {
+		status += fmt.Sprintf(", %s", formatRate(p.Rate, 0)+formatCompletion(p.ETA))
+	}
+	reasons := critical.crossed(p, sampled)
+	if len(reasons) > 0 {
+		code = nagiosCritical
+	} else if reasons = warning.crossed(p, sampled); len(reasons) > 0 {
+		code = nagiosWarning
+	}
+	if len(reasons) > 0 {
+		status += " (" + strings.Join(reasons, ", ") + ")"
+	}
+	perfdata := fmt.Sprintf("lag=%d;%s;%s;0", int64(p.Lag), warning.lag, critical.lag)
+	if sampled {
+		perfdata += fmt.Sprintf(" rate=%.2f;%s;%s", p.Rate, warning.rate, critical.rate)
+	}
+	return code, status + " | " + perfdata
+}
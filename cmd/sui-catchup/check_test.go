@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+func TestNagiosRange(t *testing.T) {
+	tests := []struct {
+		r          string
+		alerts, ok []float64
+	}{
+		{"", nil, []float64{-1, 0, 1e9}},
+		{"10", []float64{-1, 11}, []float64{0, 5, 10}},
+		{"10:", []float64{9}, []float64{10, 1e9}},
+		{"~:10", []float64{11}, []float64{-1e9, 10}},
+		{"10:20", []float64{9, 21}, []float64{10, 20}},
+		{"@10:20", []float64{10, 15, 20}, []float64{9, 21}},
+	}
+	for _, tt := range tests {
+		r, err := parseNagiosRange(tt.r)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.r, err)
+		}
+		for _, v := range tt.alerts {
+			if !r.alerts(v) {
+				t.Errorf("%q: %v does not alert", tt.r, v)
+			}
+		}
+		for _, v := range tt.ok {
+			if r.alerts(v) {
+				t.Errorf("%q: %v alerts", tt.r, v)
+			}
+		}
+	}
+}
+
+func TestNagiosRangeErrors(t *testing.T) {
+	for _, s := range []string{"ten", "1:x", "20:10"} {
+		if _, err := parseNagiosRange(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
+
+func TestCheckThresholdsCrossed(t *testing.T) {
+	warning, err := parseCheckThresholds("-w", "100, 5:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		p       catchup.Progress
+		sampled bool
+		want    []string
+	}{
+		{"within", catchup.Progress{Lag: 50, Rate: 10}, true, nil},
+		{"lag", catchup.Progress{Lag: 150, Rate: 10}, true, []string{"lag 150 outside 100"}},
+		{"lag and rate", catchup.Progress{Lag: 150, Rate: 2}, true, []string{"lag 150 outside 100", "rate 2.0/s outside 5:"}},
+		{"rate not sampled", catchup.Progress{Lag: 50, Rate: 2}, false, nil},
+		{"rate once caught up", catchup.Progress{Lag: 50, Rate: 2, CaughtUp: true}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := warning.crossed(tt.p, tt.sampled); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseCheckThresholds("-c", "100,x"); err == nil || !strings.Contains(err.Error(), "-c rate threshold") {
+		t.Errorf("got error %v, want an invalid -c rate threshold", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	no_color  = waitFlags.Bool("no-color", false, "Do not color the status line (also disabled by setting NO_COLOR)")
+	slow_rate = waitFlags.Float64("slow-rate", 1, "Catch-up rate in checkpoints per second below which the status line is shown in yellow")
+)
+
+// ANSI escape sequences for the status line colors.
+const (
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
+// useColor reports whether the status line of an output updated in place
+// should be colored, honoring https://no-color.org.
+func useColor() bool {
+	return !*no_color && os.Getenv("NO_COLOR") == ""
+}
+
+// statusColor returns the color for p by severity: red when scraping fails
+// or the node is falling behind, not advancing or without peers, yellow when
+// it catches up slowly or is close to its memory limit and green otherwise.
+func statusColor(p catchup.Progress, in_sync bool) string {
+	switch {
+	case p.Err != nil, p.FellBehind, p.Rate < 0, p.StalledFor >= 10**update_interval, noPeers(p), protocolTooOld(p), forked(p), p.AtEpochEnd, neverCatchesUp(p):
+		return colorRed
+	case memoryPressure(p):
+		return colorYellow
+	case p.CaughtUp, in_sync, p.Snapshot != nil && !p.Snapshot.Done:
+		return colorGreen
+	case p.Rate < *slow_rate:
+		return colorYellow
+	default:
+		return colorGreen
+	}
+}
+
+// colorize wraps every line of b in the given color.
+func colorize(b []byte, color string) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		text := bytes.TrimSuffix(line, []byte("\n"))
+		out.WriteString(color)
+		out.Write(text)
+		out.WriteString(colorReset)
+		out.Write(line[len(text):])
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// runCompare implements the compare command, watching two nodes side by side
+// to show which is ahead, by how much, and how fast each syncs, e.g. to
+// validate that a node on new hardware syncs faster than the one it
+// replaces. It runs until both have caught up, or with -follow until
+// interrupted, and exits like a fleet of the two.
+func runCompare(args []string) int {
+	if len(args) != 2 {
+		slog.Error("Please specify the two nodes to compare, e.g. compare old=node-1:9184 new=node-2:9184")
+		return exitError
+	}
+	targets, err := parseFleetTargets(args)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+	var watchers [2]*catchup.Watcher
+	for i, t := range targets {
+		if watchers[i], err = newWatcher(t.addr, *stall_timeout); err != nil {
+			slog.Error(err.Error(), "node", t.name)
+			return exitError
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *max_wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *max_wait)
+		defer cancel()
+	}
+
+	type update struct {
+		i int
+		p catchup.Progress
+	}
+	type result struct {
+		i   int
+		err error
+	}
+	updates := make(chan update)
+	results := make(chan result, len(watchers))
+	for i, w := range watchers {
+		go func(i int, w *catchup.Watcher) {
+			done := make(chan error, 1)
+			go func() {
+				if *follow {
+					done <- w.Follow(ctx)
+				} else {
+					done <- w.Wait(ctx)
+				}
+			}()
+			for p := range w.Events() {
+				updates <- update{i, p}
+			}
+			results <- result{i, <-done}
+		}(i, w)
+	}
+
+	out := newOutput()
+	var last [2]catchup.Progress
+	var errs [2]error
+	for running := len(watchers); running > 0; {
+		select {
+		case u := <-updates:
+			last[u.i] = u.p
+		case r := <-results:
+			errs[r.i] = r.err
+			running--
+		}
+		var b bytes.Buffer
+		if out.interactive {
+			printComparison(&b, targets, last, errs)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%s: %s; %s: %s; %s\n", targets[0].name, fleetStatus(last[0], errs[0]),
+				targets[1].name, fleetStatus(last[1], errs[1]), compareSummary(targets, last))
+		}
+		_, _ = out.status.Write(b.Bytes())
+	}
+	out.stop()
+
+	for i, err := range errs {
+		if code := fleetExitCode(err, last[i]); code != exitCaughtUp {
+			return code
+		}
+	}
+	return exitCaughtUp
+}
+
+// printComparison renders the two nodes as the columns of a table, followed
+// by which is ahead.
+func printComparison(w io.Writer, targets []target, last [2]catchup.Progress, errs [2]error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "\t%s\t%s\n", targets[0].name, targets[1].name)
+	row := func(name string, value func(p catchup.Progress) string) {
+		cells := [2]string{"-", "-"}
+		for i, p := range last {
+			if !p.Time.IsZero() && p.Err == nil {
+				cells[i] = value(p)
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", name, cells[0], cells[1])
+	}
+	row("Version", func(p catchup.Progress) string {
+		if p.Version == "" {
+			return "-"
+		}
+		return p.Version
+	})
+	row("Synced", func(p catchup.Progress) string { return fmt.Sprintf("%d", int64(p.Synced)) })
+	row("Lag", func(p catchup.Progress) string { return formatCount(p.Lag) })
+	row("Sync rate", func(p catchup.Progress) string { return formatPerSecond(p.SyncRate) + "/s" })
+	row("Catch-up rate", func(p catchup.Progress) string { return formatPerSecond(p.Rate) + "/s" })
+	row("ETA", func(p catchup.Progress) string {
+		if p.ETA <= 0 {
+			return "-"
+		}
+		return formatETA(p.ETA)
+	})
+	_, _ = fmt.Fprintf(tw, "Status\t%s\t%s\n", fleetState(last[0], errs[0]), fleetState(last[1], errs[1]))
+	_ = tw.Flush()
+	_, _ = fmt.Fprintln(w, compareSummary(targets, last))
+}
+
+// compareSummary describes which node is ahead, by how much, and by how much
+// faster it syncs once the rates are known.
+func compareSummary(targets []target, last [2]catchup.Progress) string {
+	a, b := last[0], last[1]
+	if a.Time.IsZero() || b.Time.IsZero() {
+		return "waiting for both nodes to be scraped"
+	}
+	ahead, behind := 0, 1
+	if a.Synced < b.Synced {
+		ahead, behind = 1, 0
+	}
+	var str string
+	if gap := math.Abs(a.Synced - b.Synced); gap == 0 {
+		str = "both nodes are at the same checkpoint"
+	} else {
+		str = fmt.Sprintf("%s is %s checkpoints ahead of %s", targets[ahead].name, formatCount(gap), targets[behind].name)
+	}
+	if a.SyncRate <= 0 || b.SyncRate <= 0 || a.CaughtUp || b.CaughtUp {
+		return str
+	}
+	faster, slower := 0, 1
+	if a.SyncRate < b.SyncRate {
+		faster, slower = 1, 0
+	}
+	diff := last[faster].SyncRate - last[slower].SyncRate
+	return str + fmt.Sprintf(", %s syncs %s/s (%.0f%%) faster than %s", targets[faster].name, formatPerSecond(diff),
+		100*diff/last[slower].SyncRate, targets[slower].name)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	config_file    = commonFlags.String("config", "", "YAML or TOML file with option values; flags given on the command line take precedence")
+	config_profile = commonFlags.String("profile", "", "Name of a profile in the -config file's profiles whose options are used, over those outside any profile")
+)
+
+// loadConfig sets every flag of the command not given on the command line
+// from the config file at path. The file maps flag names, with dashes or
+// underscores, to values; lists set repeatable flags such as header once per
+// element. Named profiles under profiles bundle the options of a node,
+// selected by -profile and taking precedence over the options outside them.
+//
+//	addr: https://node.example.com:9184/metrics
+//	max-wait: 30m
+//	header:
+//	  - "X-Team: infra"
+//	profiles:
+//	  mainnet-val-1:
+//	    addr: https://val-1.example.com:9184/metrics
+func loadConfig(path string) error {
+	if path == "" {
+		if *config_profile != "" {
+			return fmt.Errorf("-profile %q requires -config", *config_profile)
+		}
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file failed: %v", err)
+	}
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	default:
+		return fmt.Errorf("config file %q must have a .yaml, .yml or .toml extension", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %q failed: %v", path, err)
+	}
+
+	profiles := map[string]interface{}{}
+	if v, ok := values["profiles"]; ok {
+		if profiles, ok = v.(map[string]interface{}); !ok {
+			return fmt.Errorf("profiles in config file %q must map names to options", path)
+		}
+		delete(values, "profiles")
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if name := *config_profile; name != "" {
+		v, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("no profile %q in config file %q, it has %s", name, path, profileNames(profiles))
+		}
+		profile, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("profile %q in config file %q must map flag names to values", name, path)
+		}
+		if err := applyConfig(profile, set, fmt.Sprintf("profile %q of config file %q", name, path)); err != nil {
+			return err
+		}
+	}
+	return applyConfig(values, set, fmt.Sprintf("config file %q", path))
+}
+
+// applyConfig sets the flags of values not in set, adding them to it, from
+// the given part of a config file.
+func applyConfig(values map[string]interface{}, set map[string]bool, where string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		name := strings.ReplaceAll(key, "_", "-")
+		f := flag.Lookup(name)
+		if lookupFlag(name) == nil || name == "config" || name == "profile" {
+			return fmt.Errorf("unknown option %q in %s", key, where)
+		}
+		// A file shared by several commands may hold the options of
+		// others.
+		if f == nil || set[name] {
+			continue
+		}
+		set[name] = true
+		strs, err := configStrings(values[key])
+		if err != nil {
+			return fmt.Errorf("option %q in %s: %v", key, where, err)
+		}
+		for _, s := range strs {
+			if err := f.Value.Set(s); err != nil {
+				return fmt.Errorf("option %q in %s: %v", key, where, err)
+			}
+		}
+	}
+	return nil
+}
+
+// profileNames lists the names of profiles for an error message.
+func profileNames(profiles map[string]interface{}) string {
+	if len(profiles) == 0 {
+		return "no profiles"
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "profiles " + strings.Join(names, ", ")
+}
+
+// configStrings converts a decoded config value into the flag values it
+// stands for.
+func configStrings(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		var strs []string
+		for _, e := range v {
+			s, err := configStrings(e)
+			if err != nil {
+				return nil, err
+			}
+			strs = append(strs, s...)
+		}
+		return strs, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("must be a scalar or a list")
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	consul_addr            = serveFlags.String("consul-addr", "", "In serve mode, register the node as a service with this Consul agent, e.g. http://127.0.0.1:8500, with a health check passing only while the node is ready")
+	consul_token           = serveFlags.String("consul-token", "", "ACL token for -consul-addr")
+	consul_service         = serveFlags.String("consul-service", "sui-node", "Name of the Consul service the node is registered as")
+	consul_service_address = serveFlags.String("consul-service-address", "", "Address of the registered service (default: the Consul agent's)")
+	consul_service_port    = serveFlags.Int("consul-service-port", 9000, "Port of the registered service, e.g. the node's JSON-RPC port")
+	consul_tags            = serveFlags.String("consul-tags", "", "Comma-separated tags of the registered service, e.g. mainnet,rpc")
+)
+
+// consulTimeout bounds a request to the Consul agent.
+const consulTimeout = 5 * time.Second
+
+// consulService registers the node with the local Consul agent, with a TTL
+// check that sui-catchup passes while the node is ready and fails while it is
+// not, so that Consul only routes to nodes that have caught up. Should
+// sui-catchup go away, the check turns critical once its TTL expires.
+type consulService struct {
+	agent      string
+	token      string
+	id         string
+	register   map[string]interface{}
+	registered bool
+	lastErr    string
+}
+
+func newConsulService(node string) (*consulService, error) {
+	u, err := url.Parse(*consul_addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid -consul-addr %q", *consul_addr)
+	}
+	id := *consul_service + "-" + node
+	// The check is updated every scrape, so it expires only after several
+	// are missed.
+	ttl := 3 * *update_interval
+	if ttl < 10*time.Second {
+		ttl = 10 * time.Second
+	}
+	return &consulService{
+		agent: strings.TrimSuffix(u.String(), "/"),
+		token: *consul_token,
+		id:    id,
+		register: map[string]interface{}{
+			"ID":      id,
+			"Name":    *consul_service,
+			"Address": *consul_service_address,
+			"Port":    *consul_service_port,
+			"Tags":    splitList(*consul_tags),
+			"Meta":    map[string]string{"node": node},
+			"Check": map[string]interface{}{
+				"CheckID": id + "-caught-up",
+				"Name":    "Caught up within -lag-threshold",
+				"TTL":     ttl.String(),
+				"Status":  "critical",
+				"Notes":   "Updated by sui-catchup on every scrape of the node",
+			},
+		},
+	}, nil
+}
+
+// update sets the check's status by whether the node is ready, registering
+// the service first if it is not yet, e.g. after the agent restarted. Like
+// pusher.push, it returns an error only when it differs from the previous
+// update's.
+func (c *consulService) update(ready bool, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), consulTimeout)
+	defer cancel()
+	err := c.set(ctx, ready, reason)
+	if err == nil {
+		c.lastErr = ""
+		return nil
+	}
+	if err.Error() == c.lastErr {
+		return nil
+	}
+	c.lastErr = err.Error()
+	return fmt.Errorf("updating the Consul check failed: %v", err)
+}
+
+func (c *consulService) set(ctx context.Context, ready bool, reason string) error {
+	if !c.registered {
+		if _, err := c.request(ctx, "/v1/agent/service/register", c.register); err != nil {
+			return fmt.Errorf("registering service %q failed: %v", c.id, err)
+		}
+		c.registered = true
+	}
+	status := "critical"
+	if ready {
+		status = "passing"
+	}
+	code, err := c.request(ctx, "/v1/agent/check/update/"+url.PathEscape(c.id+"-caught-up"), map[string]string{"Status": status, "Output": reason})
+	if code == http.StatusNotFound {
+		// Registered again with the next update.
+		c.registered = false
+	}
+	return err
+}
+
+// deregister removes the service from the agent.
+func (c *consulService) deregister() error {
+	ctx, cancel := context.WithTimeout(context.Background(), consulTimeout)
+	defer cancel()
+	if _, err := c.request(ctx, "/v1/agent/service/deregister/"+url.PathEscape(c.id), nil); err != nil {
+		return fmt.Errorf("deregistering service %q from Consul failed: %v", c.id, err)
+	}
+	return nil
+}
+
+// request sends v as JSON to path on the agent with PUT, as its endpoints
+// expect, failing unless the response is a 2xx. It returns the status code
+// of the response, if any.
+func (c *consulService) request(ctx context.Context, path string, v interface{}) (int, error) {
+	var body io.Reader
+	if v != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.agent+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("PUT request returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp.StatusCode, nil
+}
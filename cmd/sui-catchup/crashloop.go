@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	crash_loop_restarts = runFlags.Int("crash-loop-restarts", 3, "Restarts of the node within -crash-loop-window from which it is reported as crash-looping (0 disables)")
+	crash_loop_window   = runFlags.Duration("crash-loop-window", 15*time.Minute, "Window over which -crash-loop-restarts are counted")
+)
+
+// crashLoop tells a node crash-looping from one restarted now and then, by
+// the restarts seen within -crash-loop-window.
+type crashLoop struct {
+	restarts []time.Time
+	looping  bool
+}
+
+// update records the restart p reports, if any, and reports whether the
+// node started crash-looping with it. Once reported, the node is not again
+// until its restarts have dropped below -crash-loop-restarts.
+func (c *crashLoop) update(p catchup.Progress) bool {
+	if *crash_loop_restarts <= 0 || p.Err != nil {
+		return false
+	}
+	if p.Restarted {
+		c.restarts = append(c.restarts, p.Time)
+	}
+	for len(c.restarts) > 0 && p.Time.Sub(c.restarts[0]) > *crash_loop_window {
+		c.restarts = c.restarts[1:]
+	}
+	if len(c.restarts) < *crash_loop_restarts {
+		c.looping = false
+		return false
+	}
+	if c.looping {
+		return false
+	}
+	c.looping = true
+	return true
+}
+
+// recent returns the number of restarts within -crash-loop-window.
+func (c *crashLoop) recent() int {
+	return len(c.restarts)
+}
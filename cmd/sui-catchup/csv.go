@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var csv_file = runFlags.String("csv-file", "", "Append a row per successful scrape to this CSV file, e.g. to graph a catch-up later")
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *csv_file == "" {
+			return nil, nil
+		}
+		return openCSV(*csv_file)
+	})
+}
+
+// csvLog appends progress samples to a CSV file.
+type csvLog struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// openCSV opens the named file for appending, writing the header row if the
+// file is new or empty.
+func openCSV(name string) (*csvLog, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening CSV file failed: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("opening CSV file failed: %v", err)
+	}
+	c := &csvLog{f: f, w: csv.NewWriter(f)}
+	if info.Size() == 0 {
+		if err := c.write([]string{"timestamp", "known", "synced", "delta", "rate"}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// update appends a row for p. Failed scrapes are skipped as they carry no
+// new watermarks.
+func (c *csvLog) update(p catchup.Progress) error {
+	if p.Err != nil {
+		return nil
+	}
+	return c.write([]string{
+		p.Time.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(int64(p.Known), 10),
+		strconv.FormatInt(int64(p.Synced), 10),
+		strconv.FormatInt(int64(p.Lag), 10),
+		strconv.FormatFloat(p.Rate, 'f', 2, 64),
+	})
+}
+
+// write writes and flushes a row, so that the file is complete even if the
+// process is killed.
+func (c *csvLog) write(row []string) error {
+	if err := c.w.Write(row); err != nil {
+		return fmt.Errorf("writing CSV file failed: %v", err)
+	}
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return fmt.Errorf("writing CSV file failed: %v", err)
+	}
+	return nil
+}
+
+func (c *csvLog) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// dashboardHTML is a single-page dashboard of /status and /events, showing
+// a live graph of the lag, the rate, the ETA and a table of the nodes.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(dashboardHTML)
+}
+
+// statusMux returns a mux serving sui-catchup's own metrics, the node's
+// state on /status and /events and the dashboard of it on /.
+func statusMux(metrics *exporter, state *health, events *broadcaster) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/status", state.serveStatus)
+	mux.Handle("/events", events)
+	mux.HandleFunc("/", serveDashboard)
+	return mux
+}
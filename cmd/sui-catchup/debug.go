@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var debug_metrics = nodeFlags.String("debug-metrics", "", "Log the names, labels and values of the metrics matching this regular expression on every scrape, e.g. checkpoint")
+
+// newMetricsDump returns a catchup.Options.Inspect function logging the
+// series of the families whose name matches pattern, as scraped from addr.
+func newMetricsDump(pattern, addr string) (func(map[string]*dto.MetricFamily), error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -debug-metrics: %v", err)
+	}
+	return func(families map[string]*dto.MetricFamily) {
+		var names []string
+		for name := range families {
+			if re.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var b bytes.Buffer
+		_, _ = fmt.Fprintf(&b, "%d of %d metrics scraped from %s match %q\n", len(names), len(families), redact(addr), pattern)
+		for _, name := range names {
+			f := families[name]
+			for _, m := range f.GetMetric() {
+				_, _ = fmt.Fprintf(&b, "  %s%s %s (%s)\n", name, formatLabels(m.GetLabel()), formatSeriesValue(f.GetType(), m), strings.ToLower(f.GetType().String()))
+			}
+		}
+		_, _ = logOutput.Write(b.Bytes())
+	}, nil
+}
+
+// formatLabels renders labels as in the text exposition format, e.g.
+// {pipeline="main"}, or not at all without labels.
+func formatLabels(labels []*dto.LabelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.GetName() + "=" + strconv.Quote(l.GetValue())
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formatSeriesValue renders the value of a series, or the count and sum of a
+// summary or histogram.
+func formatSeriesValue(t dto.MetricType, m *dto.Metric) string {
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	switch t {
+	case dto.MetricType_COUNTER:
+		return format(m.GetCounter().GetValue())
+	case dto.MetricType_SUMMARY:
+		return fmt.Sprintf("count %d, sum %s", m.GetSummary().GetSampleCount(), format(m.GetSummary().GetSampleSum()))
+	case dto.MetricType_HISTOGRAM:
+		return fmt.Sprintf("count %d, sum %s", m.GetHistogram().GetSampleCount(), format(m.GetHistogram().GetSampleSum()))
+	case dto.MetricType_UNTYPED:
+		return format(m.GetUntyped().GetValue())
+	default:
+		return format(m.GetGauge().GetValue())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var notify_desktop = runFlags.Bool("notify-desktop", false, "Show a desktop notification when the node catches up or stalls (macOS and Linux)")
+
+// desktopNotifier shows events as native desktop notifications, through
+// osascript on macOS and notify-send elsewhere.
+type desktopNotifier struct {
+	// command is the path of osascript or notify-send.
+	command string
+}
+
+func newDesktopNotifier() (desktopNotifier, error) {
+	name := "notify-send"
+	switch runtime.GOOS {
+	case "darwin":
+		name = "osascript"
+	case "windows":
+		return desktopNotifier{}, fmt.Errorf("-notify-desktop is only supported on macOS and Linux")
+	}
+	command, err := exec.LookPath(name)
+	if err != nil {
+		return desktopNotifier{}, fmt.Errorf("-notify-desktop needs %s: %v", name, err)
+	}
+	return desktopNotifier{command: command}, nil
+}
+
+func (d desktopNotifier) notify(ctx context.Context, ev event) error {
+	if ev.Kind != eventCaughtUp && ev.Kind != eventStalled {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// Passing the text as arguments spares quoting it as AppleScript.
+		cmd = exec.CommandContext(ctx, d.command,
+			"-e", "on run argv",
+			"-e", "display notification (item 1 of argv) with title (item 2 of argv)",
+			"-e", "end run",
+			ev.String(), "sui-catchup")
+	} else {
+		urgency := "normal"
+		if ev.Kind == eventStalled {
+			urgency = "critical"
+		}
+		cmd = exec.CommandContext(ctx, d.command, "--app-name=sui-catchup", "--urgency="+urgency, "sui-catchup", ev.String())
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("desktop notification: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
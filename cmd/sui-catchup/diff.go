@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var diff_metrics = diffFlags.String("diff-metrics", "checkpoint|epoch|round|transaction|protocol|peers|pruned|archive|snapshot|version|uptime",
+	"With diff, regular expression of the names of the metrics compared")
+
+// runDiff implements the diff command, printing how the sync-relevant
+// metrics of two sources differ, each a node scraped once or a recording of
+// -record, e.g. to tell what is different about a node that is stuck.
+func runDiff(args []string) int {
+	if len(args) != 2 {
+		slog.Error("Please specify the two metrics sources to compare, e.g. diff node-1:9184 stuck.rec")
+		return exitError
+	}
+	re, err := regexp.Compile(*diff_metrics)
+	if err != nil {
+		slog.Error(fmt.Sprintf("invalid -diff-metrics: %v", err))
+		return exitError
+	}
+	var families [2]map[string]*dto.MetricFamily
+	for i, source := range args {
+		if families[i], err = loadDiffSource(source); err != nil {
+			slog.Error(err.Error(), "source", source)
+			return exitError
+		}
+	}
+	printMetricsDiff(os.Stdout, args, families, re)
+	return exitCaughtUp
+}
+
+// loadDiffSource returns the metric families of source: those of the last
+// metrics response of a recording, those of a metrics page saved to a file,
+// or else those scraped from source as a metrics URL or host:port.
+func loadDiffSource(source string) (map[string]*dto.MetricFamily, error) {
+	if _, err := os.Stat(source); err == nil {
+		return loadMetricsFile(source)
+	}
+	addr := source
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid source %q, must be a file, a metrics URL or host:port", source)
+	}
+	if u.Path == "" {
+		u.Path = "/metrics"
+	}
+	transport, _, addr, err := newTransport(u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if *scrape_timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *scrape_timeout)
+		defer cancel()
+	}
+	return catchup.FetchMetrics(ctx, addr, transport)
+}
+
+// loadMetricsFile reads a recording, taking its last response that is a
+// metrics page, or else a metrics page in the Prometheus text format.
+func loadMetricsFile(path string) (map[string]*dto.MetricFamily, error) {
+	if responses, err := readRecording(path); err == nil {
+		for i := len(responses) - 1; i >= 0; i-- {
+			resp := responses[i]
+			if resp.Error != "" || resp.Status != 200 {
+				continue
+			}
+			// Recordings also hold the responses of RPC and health
+			// requests, which do not parse as metrics.
+			families, err := catchup.ParseMetrics(resp.Header, bytes.NewReader(resp.body))
+			if err == nil && len(families) > 0 {
+				return families, nil
+			}
+		}
+		return nil, fmt.Errorf("recording %s holds no metrics page", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return catchup.ParseMetrics(nil, f)
+}
+
+// printMetricsDiff prints the series of the families matching re that
+// differ between the two sources, or are missing from either, with the
+// difference of their values where both are numbers.
+func printMetricsDiff(w io.Writer, sources []string, families [2]map[string]*dto.MetricFamily, re *regexp.Regexp) {
+	values := map[string]*[2]string{}
+	for i, fams := range families {
+		for name, f := range fams {
+			if !re.MatchString(name) {
+				continue
+			}
+			for _, m := range f.GetMetric() {
+				key := name + formatLabels(sortedLabels(m.GetLabel()))
+				if values[key] == nil {
+					values[key] = &[2]string{}
+				}
+				values[key][i] = formatSeriesValue(f.GetType(), m)
+			}
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Metric\t%s\t%s\tDifference\n", sources[0], sources[1])
+	differ := 0
+	for _, key := range keys {
+		a, b := values[key][0], values[key][1]
+		if a == b {
+			continue
+		}
+		differ++
+		diff := "-"
+		switch {
+		case a == "":
+			a, diff = "-", "only in "+sources[1]
+		case b == "":
+			b, diff = "-", "only in "+sources[0]
+		default:
+			// Summaries and histograms are not single numbers.
+			x, errA := strconv.ParseFloat(a, 64)
+			y, errB := strconv.ParseFloat(b, 64)
+			if errA == nil && errB == nil {
+				diff = strconv.FormatFloat(y-x, 'g', -1, 64)
+				if !strings.HasPrefix(diff, "-") {
+					diff = "+" + diff
+				}
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, a, b, diff)
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintf(w, "%d of %d series compared differ\n", differ, len(keys))
+}
+
+// sortedLabels returns labels sorted by name, as the protobuf format does
+// not guarantee an order.
+func sortedLabels(labels []*dto.LabelPair) []*dto.LabelPair {
+	sorted := append([]*dto.LabelPair(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].GetName() < sorted[j].GetName() })
+	return sorted
+}
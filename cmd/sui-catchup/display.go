@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// printMetricNames reports the metric names picked by auto-detection.
+func printMetricNames(w io.Writer, p catchup.Progress) {
+	var names []string
+	for _, name := range []string{p.KnownMetric, p.SyncedMetric, p.ExecutedMetric} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	_, _ = fmt.Fprintf(w, "Using %s\n", strings.Join(names, ", "))
+}
+
+// formatIdentity names the node by its metric labels, e.g. "validator-1 on
+// mainnet", or returns "" if it has none.
+func formatIdentity(id catchup.Identity) string {
+	switch {
+	case id.Validator != "" && id.Network != "":
+		return id.Validator + " on " + id.Network
+	case id.Validator != "":
+		return id.Validator
+	default:
+		return id.Network
+	}
+}
+
+// display renders progress, keeping what is needed across samples.
+type display struct {
+	// rates holds recent catch-up rates, if shown, and sampled the time of
+	// the last progress added to it.
+	rates   *sparkline
+	sampled time.Time
+	// lags holds the recent lags, if charted, and charted the time of the
+	// last progress added to it.
+	lags    *brailleChart
+	charted time.Time
+	// start is the first successful scrape, from which progress is measured,
+	// or the last one the synced checkpoint went back or jumped on.
+	start catchup.Progress
+	// color is set to color the status by severity.
+	color bool
+	// since is when the session started, if the elapsed time is shown.
+	since time.Time
+
+	// last and in_sync are the progress rendered last, and shown the status
+	// it was rendered as, for redraw.
+	last    catchup.Progress
+	in_sync bool
+	shown   string
+}
+
+// printProgress renders p as the status line. in_sync is set in -follow mode
+// while a node that has caught up stays within the lag threshold.
+func (d *display) printProgress(w io.Writer, p catchup.Progress, in_sync bool) {
+	// Render into a buffer so that multi-line statuses are written at once.
+	var writer bytes.Buffer
+	defer func() {
+		if writer.Len() == 0 {
+			return
+		}
+		b := []byte(redact(writer.String()))
+		if d.color {
+			b = colorize(b, statusColor(p, in_sync))
+		}
+		d.shown = string(b)
+		_, _ = w.Write(b)
+	}()
+	d.last, d.in_sync = p, in_sync
+
+	restoring := p.Snapshot != nil && !p.Snapshot.Done
+	if p.Err == nil && (d.start.Time.IsZero() || p.Regressed || p.Jumped) && !restoring {
+		d.start = p
+	}
+
+	switch {
+	case p.Err == nil && restoring:
+		printSnapshot(&writer, p.Snapshot)
+	case p.Err != nil:
+		_, _ = fmt.Fprintf(&writer, "Error fetching metrics: %v (attempt %d, retrying in %s)\n", p.Err, p.Errors, formatETA(p.RetryAt.Sub(p.Time)))
+	case in_sync:
+		_, _ = fmt.Fprintf(&writer, "Node in sync, %s checkpoints behind\n", formatCount(p.Lag))
+	case p.CaughtUp:
+		_, _ = fmt.Fprintf(&writer, "Node caught up\n")
+	case p.Known != 0 && p.Synced != 0, p.LagExpr != "":
+		var spark string
+		if d.rates != nil {
+			// A redraw must not add the same sample again.
+			if p.Time != d.sampled {
+				d.sampled = p.Time
+				d.rates.add(p.InstantRate)
+			}
+			if str := d.rates.String(); str != "" {
+				spark = " " + str
+			}
+		}
+		_, _ = fmt.Fprintf(&writer, "Catching up, %s%s checkpoints behind%s (%s; scrape %s)%s\n", formatEpoch(p), formatCount(p.Lag),
+			formatPeers(p), formatRate(p.Rate, 0)+formatSpeeds(p)+formatCompletion(p.ETA), formatLatency(p.ScrapeDuration), spark)
+		// Execution trails state sync, by far at times, and shows which
+		// of the two is holding the node back.
+		if e := p.Execution; e != nil {
+			_, _ = fmt.Fprintf(&writer, "Executing, %s checkpoints behind state sync (%s)%s\n", formatCount(e.Lag), formatRate(e.Rate, e.ETA), formatTPS(p))
+		} else if p.TPS > 0 {
+			_, _ = fmt.Fprintf(&writer, "Executing%s\n", formatTPS(p))
+		}
+		if pr := p.Process; pr != nil {
+			_, _ = fmt.Fprintf(&writer, "Node process %s\n", formatProcess(pr))
+		}
+		if memoryPressure(p) {
+			_, _ = fmt.Fprintf(&writer, "%s\n", formatMemoryPressure(p))
+		}
+		if p.FromArchive {
+			_, _ = fmt.Fprintf(&writer, "Fetching checkpoints from the archive rather than peers, which changes the expected rate\n")
+		}
+		if p.ParseWarnings > 0 {
+			_, _ = fmt.Fprintf(&writer, "Skipped %d lines of the node's metrics that failed to parse\n", p.ParseWarnings)
+		}
+		if p.Restarts > 0 {
+			_, _ = fmt.Fprintf(&writer, "The node restarted %d times during this session\n", p.Restarts)
+		}
+		if noPeers(p) {
+			_, _ = fmt.Fprintf(&writer, "The node has 0 peers and cannot sync until it connects to some\n")
+		}
+		if neverCatchesUp(p) {
+			_, _ = fmt.Fprintf(&writer, "The network produces %s checkpoints/s but the node syncs only %s/s, so it will never catch up at this speed (net %s/s)\n",
+				formatPerSecond(p.NetworkRate), formatPerSecond(p.SyncRate), formatPerSecond(p.ClosureRate))
+		}
+		if total := p.Known - d.start.Synced; total > 0 && d.start.Lag > 0 {
+			done := p.Synced - d.start.Synced
+			_, _ = fmt.Fprintf(&writer, "%s %d%% — %s/%s checkpoints\n", progressBar(done/total, 20), int64(100*done/total), formatCount(done), formatCount(total))
+		}
+	}
+	// Catching up is pointless if the node will stall at the next upgrade.
+	if protocolTooOld(p) && p.Err == nil {
+		_, _ = fmt.Fprintf(&writer, "The node supports protocol versions up to %d but the network is at %d, so it will stall at the epoch that moved to %d until its binary is upgraded\n",
+			int64(p.Protocol), int64(p.NetworkProtocol), int64(p.Protocol)+1)
+	}
+	// Stopping exactly at the end of an epoch has its own causes, unlike
+	// a stall mid-epoch.
+	if p.AtEpochEnd && p.Err == nil {
+		cause := "usually because it misses the next epoch's committee or its binary does not support the next protocol version"
+		if protocolTooOld(p) {
+			cause = "because its binary does not support the network's protocol version, upgrade it"
+		}
+		_, _ = fmt.Fprintf(&writer, "Stuck for %s at checkpoint %d, the last of epoch %d, so the node cannot start epoch %d, %s\n",
+			formatETA(p.StalledFor), int64(p.Synced), int64(p.Epoch), int64(p.Epoch)+1, cause)
+	}
+	if h := p.Health; h != nil && p.Err == nil {
+		if h.Healthy {
+			_, _ = fmt.Fprintf(&writer, "Serving requests (health check %s)\n", formatLatency(h.Latency))
+		} else {
+			_, _ = fmt.Fprintf(&writer, "Not serving requests: %v\n", h.Err)
+		}
+	}
+	if v := p.Verification; v != nil && p.Err == nil {
+		switch {
+		case v.Err != nil:
+			_, _ = fmt.Fprintf(&writer, "Cannot verify the latest checkpoint: %v\n", v.Err)
+		case v.Verified:
+			_, _ = fmt.Fprintf(&writer, "Checkpoint %d has the same digest %s as on the trusted endpoint\n", int64(v.Checkpoint), v.Digest)
+		default:
+			_, _ = fmt.Fprintf(&writer, "Checkpoint %d has digest %s but %s on the trusted endpoint, so the node is not on the canonical chain\n",
+				int64(v.Checkpoint), v.Digest, v.TrustedDigest)
+		}
+	}
+	if onFallback(p) {
+		_, _ = fmt.Fprintf(&writer, "Scraping fallback endpoint %s\n", p.Endpoint)
+	}
+	if tx := p.Transactions; tx != nil && p.Err == nil && !p.CaughtUp && !in_sync {
+		_, _ = fmt.Fprintf(&writer, "Executing, %s transactions behind (%s)\n", formatCount(tx.Lag), formatRate(tx.Rate, tx.ETA))
+	}
+	if p.DBSizeMetric != "" && p.Err == nil && !p.CaughtUp && !in_sync {
+		line := fmt.Sprintf("Database %s, growing %s/s", formatBytes(p.DBSize), formatBytes(p.DBGrowth))
+		if p.DBGrowth > 0 && p.ETA > 0 {
+			line += fmt.Sprintf(", ~%s once caught up", formatBytes(p.DBSize+p.DBGrowth*p.ETA.Seconds()))
+		}
+		_, _ = fmt.Fprintln(&writer, line)
+	}
+	// Pruning has to keep pace after catching up too.
+	if p.PrunedMetric != "" && p.Err == nil {
+		line := fmt.Sprintf("Pruned up to checkpoint %d, retaining %s checkpoints", int64(p.Pruned), formatCount(p.Retained))
+		switch {
+		case p.RetainedGrowth >= 0.5:
+			line += fmt.Sprintf(" (growing by %s/s)", formatPerSecond(p.RetainedGrowth))
+		case p.RetainedGrowth <= -0.5:
+			line += fmt.Sprintf(" (shrinking by %s/s)", formatPerSecond(-p.RetainedGrowth))
+		}
+		if p.PrunedObjects > 0 {
+			line += fmt.Sprintf(", %s objects pruned", formatCount(p.PrunedObjects))
+		}
+		_, _ = fmt.Fprintln(&writer, line)
+	}
+	// Consensus keeps running once the checkpoints have caught up, so its
+	// lag stays relevant.
+	if c := p.Consensus; c != nil && p.Err == nil {
+		_, _ = fmt.Fprintf(&writer, "Consensus, round %d, %s rounds not committed (%s)\n", int64(c.Target), formatCount(c.Lag), formatRate(c.Rate, c.ETA))
+	}
+	for _, pp := range p.Pairs {
+		if p.Err != nil {
+			break
+		}
+		switch {
+		case pp.Err != nil:
+			_, _ = fmt.Fprintf(&writer, "%s: %v\n", pp.Name, pp.Err)
+		case pp.Reached:
+			_, _ = fmt.Fprintf(&writer, "%s: reached, %s %s %s\n", pp.Name, formatCount(pp.Current), pp.Op, formatCount(pp.Target))
+		default:
+			_, _ = fmt.Fprintf(&writer, "%s: %s to go, at %s, waiting for %s %s (%s)\n", pp.Name, formatCount(pp.Lag), formatCount(pp.Current), pp.Op, formatCount(pp.Target), formatRate(pp.Rate, pp.ETA))
+		}
+	}
+	if d.lags != nil && p.Err == nil && !restoring {
+		if p.Time != d.charted {
+			d.charted = p.Time
+			d.lags.add(p.Time, p.Lag)
+		}
+		if lines := d.lags.lines(p.Time); lines != nil {
+			_, _ = fmt.Fprintf(&writer, "Lag over the last %s\n%s\n", formatETA(d.lags.span), strings.Join(lines, "\n"))
+		}
+	}
+	if !d.since.IsZero() {
+		_, _ = fmt.Fprintf(&writer, "Elapsed %s, since %s\n", formatETA(time.Since(d.since)), d.since.Format(timestampFormat))
+	}
+}
+
+// redraw renders the last progress again with its ETA counted down since it
+// was scraped, if that changes the status.
+func (d *display) redraw(w io.Writer) {
+	last, p := d.last, d.last
+	if p.Time.IsZero() || p.ETA <= 0 && d.since.IsZero() {
+		return
+	}
+	if p.ETA > 0 {
+		p.ETA -= time.Since(p.Time)
+		if p.ETA < time.Second {
+			p.ETA = time.Second
+		}
+	}
+	prev := d.shown
+	var b bytes.Buffer
+	d.printProgress(&b, p, d.in_sync)
+	// The countdown is relative to the scrape, not to the previous redraw.
+	d.last = last
+	if b.String() != prev {
+		_, _ = w.Write(b.Bytes())
+	}
+}
+
+// printSnapshot renders the progress of a formal snapshot restore.
+func printSnapshot(w io.Writer, s *catchup.Snapshot) {
+	parts := s.Partitions
+	var eta string
+	if parts.ETA > 0 {
+		eta = fmt.Sprintf(", ~%s remaining", formatETA(parts.ETA))
+	}
+	_, _ = fmt.Fprintf(w, "Restoring snapshot, %s objects, %s downloaded (%s/s%s)\n", formatCount(s.Objects), formatBytes(s.Bytes), formatBytes(s.Throughput), eta)
+	if parts.Target > 0 {
+		_, _ = fmt.Fprintf(w, "%s %d%% — %d/%d partitions\n", progressBar(parts.Current/parts.Target, 20), int64(100*parts.Current/parts.Target), int64(parts.Current), int64(parts.Target))
+	}
+}
+
+// progressBar renders the fraction f, between 0 and 1, as a bar of the given
+// width, e.g. "[######----]".
+func progressBar(f float64, width int) string {
+	n := int(f * float64(width))
+	if n < 0 {
+		n = 0
+	} else if n > width {
+		n = width
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat("-", width-n) + "]"
+}
+
+var raw_numbers = waitFlags.Bool("raw-numbers", false, "Show counts and rates in the status as plain integers, e.g. for scripts, instead of abbreviated as in 1.92M")
+
+// formatCount abbreviates counts from 10,000 on to three significant digits,
+// e.g. "1.92M", "850K" or "12.3K", unless -raw-numbers.
+func formatCount(n float64) string {
+	if *raw_numbers || math.Abs(n) < 1e4 {
+		return fmt.Sprintf("%d", int64(n))
+	}
+	const units = "KMBT"
+	i := -1
+	for ; math.Abs(n) >= 999.5 && i < len(units)-1; i++ {
+		n /= 1000
+	}
+	switch a := math.Abs(n); {
+	case a >= 99.95:
+		return fmt.Sprintf("%.0f%c", n, units[i])
+	case a >= 9.995:
+		return fmt.Sprintf("%.1f%c", n, units[i])
+	default:
+		return fmt.Sprintf("%.2f%c", n, units[i])
+	}
+}
+
+// formatPerSecond renders a rate at a precision suited to its magnitude, e.g.
+// "0.4", "25" or "12.3K", unless -raw-numbers.
+func formatPerSecond(r float64) string {
+	switch a := math.Abs(r); {
+	case *raw_numbers:
+		return fmt.Sprintf("%d", int64(r))
+	case a >= 1e4:
+		return formatCount(r)
+	case a >= 9.95 || a < 0.05:
+		return fmt.Sprintf("%.0f", r)
+	default:
+		return fmt.Sprintf("%.1f", r)
+	}
+}
+
+// formatBytes abbreviates a size in bytes, e.g. "1.4 TB" or "850.0 MB".
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1000 && n > -1000 {
+		return fmt.Sprintf("%d B", int64(n))
+	}
+	i := -1
+	for ; (n >= 1000 || n <= -1000) && i < len(units)-1; i++ {
+		n /= 1000
+	}
+	return fmt.Sprintf("%.1f %cB", n, units[i])
+}
+
+// formatEpoch describes the epoch the node is syncing through, e.g.
+// "epoch 412/517, ", or returns "" if the node does not expose its epoch.
+func formatEpoch(p catchup.Progress) string {
+	switch {
+	case p.Epoch == 0:
+		return ""
+	case p.NetworkEpoch == 0:
+		return fmt.Sprintf("epoch %d, ", int64(p.Epoch))
+	default:
+		return fmt.Sprintf("epoch %d/%d, ", int64(p.Epoch), int64(p.NetworkEpoch))
+	}
+}
+
+// formatPeers describes the node's peer count, e.g. ", 12 peers", or returns
+// "" if the node does not expose it.
+func formatPeers(p catchup.Progress) string {
+	switch {
+	case p.PeersMetric == "":
+		return ""
+	case p.Peers == 1:
+		return ", 1 peer"
+	default:
+		return fmt.Sprintf(", %d peers", int64(p.Peers))
+	}
+}
+
+// noPeers reports whether a node that has not caught up has no peers to sync
+// from, which explains why it makes no progress.
+func noPeers(p catchup.Progress) bool {
+	return p.PeersMetric != "" && p.Peers == 0 && !p.CaughtUp
+}
+
+// protocolTooOld reports whether the node's binary does not support the
+// network's current protocol version. Such a node seems to catch up, but stalls
+// for good at the epoch change that upgraded the network to it.
+func protocolTooOld(p catchup.Progress) bool {
+	return p.Protocol != 0 && p.NetworkProtocol != 0 && p.Protocol < p.NetworkProtocol
+}
+
+// neverCatchesUp reports whether the network produces checkpoints faster than
+// the node syncs them, by at least one a second, so that it would fall ever
+// further behind at its current speed.
+func neverCatchesUp(p catchup.Progress) bool {
+	return p.Err == nil && !p.CaughtUp && p.ClosureRate <= -0.5
+}
+
+// formatEpochDuration describes how long the node took to sync through the
+// epoch it just left, e.g. " after 1h02m in the previous epoch", or returns ""
+// if that is not known.
+func formatEpochDuration(p catchup.Progress) string {
+	if p.EpochDuration <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" after %s in the previous epoch", formatETA(p.EpochDuration))
+}
+
+// forked reports whether the node's latest checkpoint differs from the one on
+// the trusted endpoint.
+func forked(p catchup.Progress) bool {
+	v := p.Verification
+	return v != nil && v.Err == nil && !v.Verified
+}
+
+// formatRate describes a smoothed catch-up rate and the resulting ETA.
+func formatRate(rate float64, eta time.Duration) string {
+	var str string
+	if rate >= 0 {
+		str = fmt.Sprintf("catching up at %s/s", formatPerSecond(rate))
+	} else {
+		str = fmt.Sprintf("falling behind at %s/s", formatPerSecond(-rate))
+	}
+	if eta > 0 {
+		str += fmt.Sprintf(", ~%s remaining", formatETA(eta))
+	}
+	return str
+}
+
+// formatSpeeds describes the rates the lag's rate is made of, e.g. ", syncing
+// 30/s against the network's 25/s", or returns "" before they are known.
+func formatSpeeds(p catchup.Progress) string {
+	if p.SyncRate == 0 && p.NetworkRate == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", syncing %s/s against the network's %s/s", formatPerSecond(p.SyncRate), formatPerSecond(p.NetworkRate))
+}
+
+// formatProcess describes the resource usage of the node's process, e.g.
+// "using 3.2 CPUs, 12.4 GB resident, 1024 of 65536 file descriptors".
+func formatProcess(pr *catchup.Process) string {
+	parts := []string{fmt.Sprintf("using %.1f CPUs", pr.CPU)}
+	if pr.RSS > 0 {
+		resident := formatBytes(pr.RSS) + " resident"
+		if memoryLimit > 0 {
+			resident += " of " + formatBytes(memoryLimit)
+		}
+		parts = append(parts, resident)
+	}
+	switch {
+	case pr.MaxFDs > 0:
+		parts = append(parts, fmt.Sprintf("%d of %d file descriptors", int64(pr.OpenFDs), int64(pr.MaxFDs)))
+	case pr.OpenFDs > 0:
+		parts = append(parts, fmt.Sprintf("%d file descriptors", int64(pr.OpenFDs)))
+	}
+	if pr.HasQueueDepth {
+		parts = append(parts, fmt.Sprintf("%d tasks queued", int64(pr.QueueDepth)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatTPS describes the node's execution throughput, e.g. ", 850
+// transactions/s", or returns "" if it is not known.
+func formatTPS(p catchup.Progress) string {
+	if p.TPS <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %s transactions/s", formatCount(math.Round(p.TPS)))
+}
+
+// formatCompletion describes when the node is expected to have caught up,
+// e.g. ", expected caught up at 14:32, in 2h11m", or returns "" if it is not
+// catching up. The tip keeps advancing while the node syncs, which the
+// ETA allows for as the rate is that at which the lag shrinks.
+func formatCompletion(eta time.Duration) string {
+	if eta <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", expected caught up at %s, in %s", formatClock(time.Now().Add(eta)), formatETA(eta))
+}
+
+// formatClock renders t in local time, with the weekday or date when it is
+// not today, e.g. "14:32", "Tue 09:15" or "Mar 4 09:15".
+func formatClock(t time.Time) string {
+	t = t.Local()
+	now := time.Now()
+	switch {
+	case t.YearDay() == now.YearDay() && t.Year() == now.Year():
+		return t.Format("15:04")
+	case t.Sub(now) < 6*24*time.Hour:
+		return t.Format("Mon 15:04")
+	default:
+		return t.Format("Jan 2 15:04")
+	}
+}
+
+// formatLatency renders a scrape duration, e.g. "85ms" or "1.2s".
+func formatLatency(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// formatETA renders d at a precision suited to its magnitude, e.g. "2h11m",
+// "14m" or "40s".
+func formatETA(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Round(time.Minute).Minutes()))
+	default:
+		return fmt.Sprintf("%ds", int((d+time.Second-1)/time.Second))
+	}
+}
+
+// onFallback reports whether p was scraped from one of -fallback-addr rather
+// than -addr.
+func onFallback(p catchup.Progress) bool {
+	for _, a := range splitList(*fallback_addrs) {
+		if p.Endpoint == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables that set flags, e.g.
+// SUI_CATCHUP_MAX_WAIT for -max-wait.
+const envPrefix = "SUI_CATCHUP_"
+
+// envName returns the environment variable that sets the named flag.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnv sets every flag not given on the command line from its environment
+// variable, if present. Flags set this way count as given for loadConfig, so
+// the precedence is command line, environment, config file, default.
+func loadEnv() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if e := flag.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), e)
+		}
+	})
+	return err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// commandLine replaces the command line with the flags of the wait command
+// parsed from args until the test ends, when the flags get their defaults
+// back.
+func commandLine(t *testing.T, args ...string) {
+	t.Helper()
+	fs := newCommandFlags("wait", runCommandFlags)
+	saved := flag.CommandLine
+	flag.CommandLine = fs
+	t.Cleanup(func() {
+		fs.VisitAll(func(f *flag.Flag) {
+			_ = f.Value.Set(f.DefValue)
+		})
+		flag.CommandLine = saved
+	})
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("max-wait: 1m\ncaught_up_lag: 5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		env     string
+		config  string
+		want    time.Duration
+		wantLag int
+	}{
+		{"default", nil, "", "", 0, 0},
+		{"config file", nil, "", config, time.Minute, 5},
+		{"environment over config file", nil, "2m", config, 2 * time.Minute, 5},
+		{"command line over environment", []string{"-max-wait", "3m"}, "2m", config, 3 * time.Minute, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("SUI_CATCHUP_MAX_WAIT", tt.env)
+			}
+			commandLine(t, tt.args...)
+			if err := loadEnv(); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(tt.config); err != nil {
+				t.Fatal(err)
+			}
+			if *max_wait != tt.want || *caught_up_lag != tt.wantLag {
+				t.Errorf("got -max-wait %v and -caught-up-lag %d, want %v and %d", *max_wait, *caught_up_lag, tt.want, tt.wantLag)
+			}
+		})
+	}
+}
+
+func TestOptionErrors(t *testing.T) {
+	commandLine(t)
+	t.Setenv("SUI_CATCHUP_MAX_WAIT", "soon")
+	if err := loadEnv(); err == nil || !strings.Contains(err.Error(), "SUI_CATCHUP_MAX_WAIT") {
+		t.Errorf("got error %v, want one naming SUI_CATCHUP_MAX_WAIT", err)
+	}
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("no-such-flag: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(config); err == nil || !strings.Contains(err.Error(), `unknown option "no-such-flag"`) {
+		t.Errorf("got error %v, want an unknown option", err)
+	}
+}
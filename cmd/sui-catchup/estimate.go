@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	estimate_from     = estimateFlags.Int64("estimate-from", -1, "Checkpoint the estimate command assumes a restore starts from (default: the node's synced checkpoint)")
+	estimate_tip      = estimateFlags.Int64("estimate-tip", 0, "Network tip the estimate command assumes (default: the node's highest known checkpoint, or -rpc-tip-url's)")
+	estimate_rate     = estimateFlags.Float64("estimate-rate", 0, "Sync rate in checkpoints per second the estimate command assumes (default: measured by probing the node)")
+	estimate_tip_rate = estimateFlags.Float64("estimate-tip-rate", -1, "Checkpoints per second the network adds that the estimate command assumes (default: measured by probing the node)")
+	estimate_probe    = estimateFlags.Duration("estimate-probe", 30*time.Second, "How long the estimate command probes the node to measure the rates it is not given")
+)
+
+// runEstimate implements the estimate command, which projects how long a
+// restore from -estimate-from to the network tip takes, for capacity
+// planning. Whatever is not given by flags is measured by scraping the node,
+// twice -estimate-probe apart for the rates.
+func runEstimate() int {
+	from, tip := float64(*estimate_from), float64(*estimate_tip)
+	rate, tipRate := *estimate_rate, *estimate_tip_rate
+	if from < 0 || tip == 0 || rate == 0 || tipRate < 0 {
+		p, err := probeNode(rate == 0 || tipRate < 0)
+		if err != nil {
+			slog.Error(err.Error())
+			return exitError
+		}
+		if from < 0 {
+			from = p.last.Synced
+		}
+		if tip == 0 {
+			tip = p.last.Known
+		}
+		if rate == 0 {
+			rate = p.syncRate()
+		}
+		if tipRate < 0 {
+			tipRate = p.tipRate()
+		}
+	}
+
+	remaining := tip - from
+	_, _ = fmt.Printf("Restoring %s checkpoints, from %d to %d, at %.0f/s while the network adds %.0f/s\n",
+		formatCount(remaining), int64(from), int64(tip), rate, tipRate)
+	if remaining <= 0 {
+		_, _ = fmt.Println("Nothing to restore, the start is at the tip")
+		return exitCaughtUp
+	}
+	// The tip keeps advancing during the restore, so the lag only shrinks by
+	// the difference.
+	if rate <= tipRate {
+		slog.Error("The restore never catches up, as it is not faster than the network", "rate", rate, "tip_rate", tipRate)
+		return exitError
+	}
+	took := time.Duration(remaining / (rate - tipRate) * float64(time.Second))
+	_, _ = fmt.Printf("Takes %s, caught up around %s\n", formatETA(took), formatClock(time.Now().Add(took)))
+	return exitCaughtUp
+}
+
+// estimateProbe holds the first and last of the scrapes of a probe.
+type estimateProbe struct {
+	first, last catchup.Progress
+}
+
+// syncRate is how many checkpoints per second the node synced during the
+// probe.
+func (p estimateProbe) syncRate() float64 {
+	if dt := p.last.Time.Sub(p.first.Time).Seconds(); dt > 0 {
+		return (p.last.Synced - p.first.Synced) / dt
+	}
+	return 0
+}
+
+// tipRate is how many checkpoints per second the network added during the
+// probe.
+func (p estimateProbe) tipRate() float64 {
+	if dt := p.last.Time.Sub(p.first.Time).Seconds(); dt > 0 {
+		return (p.last.Known - p.first.Known) / dt
+	}
+	return 0
+}
+
+// probeNode scrapes the node, a second time -estimate-probe later if rates are
+// wanted.
+func probeNode(rates bool) (estimateProbe, error) {
+	forward, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, err := watchAddr(forward)
+	if err != nil {
+		return estimateProbe{}, err
+	}
+	watcher, err := newWatcher(addr, 0)
+	if err != nil {
+		return estimateProbe{}, err
+	}
+	var r estimateProbe
+	if r.first, err = watcher.Check(forward); err != nil {
+		return r, err
+	}
+	r.last = r.first
+	if rates {
+		slog.Info("Probing the node to measure its rates", "for", *estimate_probe)
+		time.Sleep(*estimate_probe)
+		if r.last, err = watcher.Check(forward); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var events_file = runFlags.String("events-file", "", "Append the lifecycle events, e.g. the session starting, epochs crossed, stalls, recoveries and catching up, to this file as JSON lines for post-incident timelines")
+
+// Kinds of the session lines of -events-file, besides those of the events.
+const (
+	eventSessionStarted = "session_started"
+	eventSessionEnded   = "session_ended"
+)
+
+// eventLine is a line of -events-file. The watermarks are omitted before the
+// node was scraped successfully.
+type eventLine struct {
+	Event          string            `json:"event"`
+	Time           string            `json:"time"`
+	Node           string            `json:"node"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	Known          *int64            `json:"known,omitempty"`
+	Synced         *int64            `json:"synced,omitempty"`
+	Lag            *int64            `json:"lag,omitempty"`
+	Epoch          *int64            `json:"epoch,omitempty"`
+	Rate           *float64          `json:"rate,omitempty"`
+	ElapsedSeconds float64           `json:"elapsed_seconds"`
+	Reason         string            `json:"reason,omitempty"`
+	Status         string            `json:"status,omitempty"`
+	ExitCode       *int              `json:"exit_code,omitempty"`
+	Message        string            `json:"message,omitempty"`
+}
+
+// eventsFile appends events to -events-file. Unlike notifiers it is written
+// to in order, as events are sent, so that the file reads as a timeline.
+type eventsFile struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+}
+
+// openEventsFile opens path for appending and records the start of the
+// session.
+func openEventsFile(path string) (*eventsFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening -events-file failed: %v", err)
+	}
+	e := &eventsFile{f: f, start: time.Now()}
+	if err := e.write(eventLine{Event: eventSessionStarted, Time: formatEventTime(e.start), Node: nodeName(), Tags: tagMap()}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// record appends ev.
+func (e *eventsFile) record(ev event) error {
+	l := eventLine{
+		Event:          ev.Kind,
+		Time:           formatEventTime(ev.Time),
+		Node:           ev.Node,
+		Tags:           ev.Tags,
+		ElapsedSeconds: ev.Elapsed.Seconds(),
+		Reason:         redact(ev.Reason),
+		Message:        redact(ev.String()),
+	}
+	setWatermarks(&l, ev.Progress)
+	return e.write(l)
+}
+
+// end records the end of the session with the exit code and the last
+// successful scrape, and closes the file.
+func (e *eventsFile) end(code int, last catchup.Progress) error {
+	end := time.Now()
+	l := eventLine{
+		Event:          eventSessionEnded,
+		Time:           formatEventTime(end),
+		Node:           nodeName(),
+		Tags:           tagMap(),
+		ElapsedSeconds: end.Sub(e.start).Seconds(),
+		Status:         exitStatus(code),
+		ExitCode:       &code,
+	}
+	setWatermarks(&l, last)
+	err := e.write(l)
+	if cerr := e.f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("closing -events-file failed: %v", cerr)
+	}
+	return err
+}
+
+// setWatermarks sets the watermarks of l from p, if it is a successful scrape.
+func setWatermarks(l *eventLine, p catchup.Progress) {
+	if p.Time.IsZero() || p.Err != nil {
+		return
+	}
+	known, synced, lag, epoch, rate := int64(p.Known), int64(p.Synced), int64(p.Lag), int64(p.Epoch), p.Rate
+	l.Known, l.Synced, l.Lag, l.Rate = &known, &synced, &lag, &rate
+	if p.Epoch != 0 {
+		l.Epoch = &epoch
+	}
+}
+
+// formatEventTime formats t in UTC with milliseconds, which keeps the lines
+// of a file in order when sorted as text.
+func formatEventTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+func (e *eventsFile) write(l eventLine) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("writing -events-file failed: %v", err)
+	}
+	return nil
+}
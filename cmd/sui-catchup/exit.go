@@ -0,0 +1,38 @@
+package main
+
+// Exit codes. These are part of the command's interface and documented in
+// the README; do not renumber them.
+const (
+	exitCaughtUp     = 0 // the node caught up
+	exitError        = 1 // invalid usage or an unexpected error
+	exitTimeout      = 2 // -max-wait elapsed before the node caught up
+	exitScrapeFailed = 3 // -max-errors scrapes failed, or -max-wait elapsed while failing
+	exitStalled      = 4 // the synced checkpoint stopped advancing
+	exitBehind       = 5 // -once found the node behind
+	exitEndOfReplay  = 6 // the -replay recording ended before the node caught up
+
+	// exitInterrupted follows the shell convention of 128 + SIGINT.
+	exitInterrupted = 130 // interrupted by SIGINT or SIGTERM
+)
+
+// exitStatus names an exit code for machine-readable reports.
+func exitStatus(code int) string {
+	switch code {
+	case exitCaughtUp:
+		return "caught_up"
+	case exitTimeout:
+		return "timeout"
+	case exitScrapeFailed:
+		return "scrape_failed"
+	case exitStalled:
+		return "stalled"
+	case exitBehind:
+		return "behind"
+	case exitEndOfReplay:
+		return "end_of_replay"
+	case exitInterrupted:
+		return "interrupted"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The flags are declared in groups by what they configure, and each command
+// takes the groups relevant to it, see commands, besides commonFlags.
+var (
+	// commonFlags are taken by every command: configuration and logging.
+	commonFlags = newFlagGroup()
+	// transportFlags configure how the node and other endpoints are
+	// reached.
+	transportFlags = newFlagGroup()
+	// nodeFlags select the node and how it is watched.
+	nodeFlags = newFlagGroup()
+	// waitFlags configure waiting for nodes to catch up, with their
+	// progress printed.
+	waitFlags = newFlagGroup()
+	// listenFlags set where sui-catchup serves HTTP.
+	listenFlags = newFlagGroup()
+	// reportFlags describe the node to monitoring.
+	reportFlags = newFlagGroup()
+	// runFlags configure the outputs, notifications and hooks of wait and
+	// watch.
+	runFlags = newFlagGroup()
+
+	// The flags of a single command.
+	serveFlags    = newFlagGroup()
+	historyFlags  = newFlagGroup()
+	checkFlags    = newFlagGroup()
+	estimateFlags = newFlagGroup()
+	fleetFlags    = newFlagGroup()
+	mockFlags     = newFlagGroup()
+	diffFlags     = newFlagGroup()
+)
+
+// flagGroups are all the groups of flags.
+var flagGroups = []*flag.FlagSet{
+	commonFlags, transportFlags, nodeFlags, waitFlags, listenFlags, reportFlags, runFlags,
+	serveFlags, historyFlags, checkFlags, estimateFlags, fleetFlags, mockFlags, diffFlags,
+}
+
+// newFlagGroup returns a set declaring a group of flags, which is never
+// parsed itself.
+func newFlagGroup() *flag.FlagSet {
+	return flag.NewFlagSet("", flag.PanicOnError)
+}
+
+// newCommandFlags returns the flag set of a command, with the flags of
+// commonFlags and of groups. They share their values with the groups', so
+// that parsing the set sets the variables the flags were declared with.
+func newCommandFlags(name string, groups []*flag.FlagSet) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, g := range append([]*flag.FlagSet{commonFlags}, groups...) {
+		g.VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		})
+	}
+	return fs
+}
+
+// lookupFlag returns the flag of any command with the given name, or nil.
+func lookupFlag(name string) *flag.Flag {
+	for _, g := range flagGroups {
+		if f := g.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// splitCommand returns the first argument of args that is not a flag or a
+// flag's value, which names the command, or "" if there is none, and args
+// without it. Flags may thus be given before the command's name as well as
+// after it.
+func splitCommand(args []string) (string, []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return "", args
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return arg, append(args[:i:i], args[i+1:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := lookupFlag(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++ // the flag's value
+			}
+		}
+	}
+	return "", args
+}
+
+// durationFlag is a time.Duration flag that also accepts a bare number of
+// seconds, as -interval did before it took durations.
+type durationFlag time.Duration
+
+func newDurationFlag(fs *flag.FlagSet, name string, value time.Duration, usage string) *time.Duration {
+	d := value
+	fs.Var((*durationFlag)(&d), name, usage)
+	return &d
+}
+
+func (d *durationFlag) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationFlag) Set(value string) error {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		*d = durationFlag(n * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(v)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		rest    []string
+	}{
+		{nil, "", nil},
+		{[]string{"-addr", "http://node:9184/metrics"}, "", []string{"-addr", "http://node:9184/metrics"}},
+		{[]string{"check", "-w", "100"}, "check", []string{"-w", "100"}},
+		// A flag's value is not taken for the command.
+		{[]string{"-addr", "check", "wait"}, "wait", []string{"-addr", "check"}},
+		{[]string{"-follow", "watch", "-interval=5s"}, "watch", []string{"-follow", "-interval=5s"}},
+		{[]string{"--", "check"}, "", []string{"--", "check"}},
+		{[]string{"compare", "a", "b"}, "compare", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		command, rest := splitCommand(tt.args)
+		if command != tt.command || len(rest)+len(tt.rest) > 0 && !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("splitCommand(%q) = %q, %q, want %q, %q", tt.args, command, rest, tt.command, tt.rest)
+		}
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	for _, c := range commands {
+		fs := newCommandFlags(c.name, c.flags)
+		if fs.Lookup("config") == nil || fs.Lookup("log-level") == nil {
+			t.Errorf("%s: common flags missing", c.name)
+		}
+	}
+
+	tests := []struct {
+		command string
+		flag    string
+		want    bool
+	}{
+		{"wait", "slack-webhook", true},
+		{"wait", "slack-webhook-file", true},
+		{"check", "w", true},
+		{"check", "slack-webhook", false},
+		{"serve", "consul-token-file", true},
+		{"history", "history-db", true},
+		{"history", "addr", false},
+		{"diff", "bearer-token", true},
+		{"diff", "interval", false},
+		{"mock", "listen", true},
+		{"mock", "ca-file", false},
+	}
+	for _, tt := range tests {
+		for _, c := range commands {
+			if c.name != tt.command {
+				continue
+			}
+			fs := newCommandFlags(c.name, c.flags)
+			fs.Init(c.name, flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			err := fs.Parse([]string{"-" + tt.flag + "=" + flagValue(fs.Lookup(tt.flag))})
+			if got := err == nil; got != tt.want {
+				t.Errorf("%s -%s: got error %v, want accepted %v", tt.command, tt.flag, err, tt.want)
+			}
+		}
+	}
+}
+
+// flagValue returns the current value of f, or "" if there is no such flag,
+// so that parsing it leaves it unchanged.
+func flagValue(f *flag.Flag) string {
+	if f == nil {
+		return ""
+	}
+	return f.Value.String()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// target is a node watched as part of a fleet.
+type target struct {
+	name string
+	addr string
+}
+
+// runFleet watches several nodes at once, showing a table of the nodes sorted
+// by lag, or a log line per update when not on a terminal, until all of them
+// have caught up. The exit code is that of the first node,
+// in order, that did not catch up.
+func runFleet(targets []target) int {
+	watchers := make([]*catchup.Watcher, len(targets))
+	for i, t := range targets {
+		w, err := newWatcher(t.addr, *stall_timeout)
+		if err != nil {
+			slog.Error(err.Error(), "node", t.name)
+			return exitError
+		}
+		watchers[i] = w
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *max_wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *max_wait)
+		defer cancel()
+	}
+
+	type update struct {
+		i int
+		p catchup.Progress
+	}
+	type result struct {
+		i   int
+		err error
+	}
+	updates := make(chan update)
+	results := make(chan result, len(watchers))
+	for i, w := range watchers {
+		go func(i int, w *catchup.Watcher) {
+			done := make(chan error, 1)
+			go func() {
+				if *follow {
+					done <- w.Follow(ctx)
+				} else {
+					done <- w.Wait(ctx)
+				}
+			}()
+			for p := range w.Events() {
+				updates <- update{i, p}
+			}
+			results <- result{i, <-done}
+		}(i, w)
+	}
+
+	out := newOutput()
+	var warned_versions string
+	last := make([]catchup.Progress, len(targets))
+	errs := make([]error, len(targets))
+	for running := len(watchers); running > 0; {
+		var changed int
+		select {
+		case u := <-updates:
+			changed = u.i
+			last[u.i] = u.p
+		case r := <-results:
+			changed = r.i
+			errs[r.i] = r.err
+			running--
+		}
+		if versions := fleetVersions(targets, last); versions != warned_versions {
+			if versions != "" {
+				slog.Warn("Nodes run different versions, which commonly makes one lag", "versions", versions)
+			}
+			warned_versions = versions
+		}
+		// A status updated in place shows every node, a log only the node
+		// that changed.
+		var b bytes.Buffer
+		if out.interactive {
+			printFleetTable(&b, targets, last, errs)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%s: %s\n", targets[changed].name, fleetStatus(last[changed], errs[changed]))
+		}
+		_, _ = out.status.Write(b.Bytes())
+	}
+	out.stop()
+
+	for i, err := range errs {
+		if code := fleetExitCode(err, last[i]); code != exitCaughtUp {
+			return code
+		}
+	}
+	return exitCaughtUp
+}
+
+// printFleetTable renders the fleet as a table sorted by lag, the furthest
+// behind first, so that stragglers stand out. Nodes not scraped yet come last.
+func printFleetTable(w io.Writer, targets []target, last []catchup.Progress, errs []error) {
+	order := make([]int, len(targets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		pa, pb := last[order[a]], last[order[b]]
+		if pa.Time.IsZero() != pb.Time.IsZero() {
+			return pb.Time.IsZero()
+		}
+		return pa.Lag > pb.Lag
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NODE\tVERSION\tSYNCED\tLAG\tRATE\tETA\tSTATUS")
+	for _, i := range order {
+		p := last[i]
+		name := targets[i].name
+		if id := formatIdentity(p.Identity); id != "" {
+			name += " (" + id + ")"
+		}
+		version, synced, lag, rate, eta := "-", "-", "-", "-", "-"
+		if p.Version != "" {
+			version = p.Version
+		}
+		if !p.Time.IsZero() {
+			synced = fmt.Sprintf("%d", int64(p.Synced))
+			lag = fmt.Sprintf("%d", int64(p.Lag))
+			rate = fmt.Sprintf("%d/s", int64(p.Rate))
+		}
+		if p.ETA > 0 {
+			eta = formatETA(p.ETA)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", name, version, synced, lag, rate, eta, fleetState(p, errs[i]))
+	}
+	_ = tw.Flush()
+}
+
+// fleetVersions lists the nodes' versions, e.g. "node-1: 1.36.2, node-2:
+// 1.35.0", if they do not all run the same one, and is empty otherwise.
+func fleetVersions(targets []target, last []catchup.Progress) string {
+	var versions []string
+	distinct := map[string]bool{}
+	for i, p := range last {
+		if p.Version != "" {
+			versions = append(versions, targets[i].name+": "+p.Version)
+			distinct[p.Version] = true
+		}
+	}
+	if len(distinct) < 2 {
+		return ""
+	}
+	return strings.Join(versions, ", ")
+}
+
+// fleetState is the short form of fleetStatus shown in the fleet table.
+func fleetState(p catchup.Progress, err error) string {
+	var stall *catchup.StallError
+	switch {
+	case errors.As(err, &stall):
+		return "stalled"
+	case err != nil && err != context.Canceled && err != context.DeadlineExceeded:
+		return redact(err.Error())
+	case p.Time.IsZero():
+		return "waiting"
+	case p.Err != nil:
+		return fmt.Sprintf("error (attempt %d)", p.Errors)
+	case p.FellBehind:
+		return "fell behind"
+	case p.CaughtUp:
+		return "caught up"
+	case noPeers(p):
+		return "no peers"
+	case p.FromArchive:
+		return "catching up from archive"
+	case p.Rate < 0:
+		return "falling behind"
+	default:
+		return "catching up"
+	}
+}
+
+// fleetStatus describes a node of a fleet in a single line.
+func fleetStatus(p catchup.Progress, err error) string {
+	switch {
+	case err != nil && err != context.Canceled && err != context.DeadlineExceeded:
+		return redact(err.Error())
+	case p.Time.IsZero():
+		return "waiting for the first scrape"
+	case p.Err != nil:
+		return fmt.Sprintf("error fetching metrics: %s (attempt %d)", redact(p.Err.Error()), p.Errors)
+	case p.FellBehind:
+		return fmt.Sprintf("fell behind, %s checkpoints behind (%s)", formatCount(p.Lag), formatRate(p.Rate, p.ETA))
+	case p.CaughtUp:
+		return fmt.Sprintf("caught up, %d checkpoints behind", int64(p.Lag))
+	default:
+		return fmt.Sprintf("%s%s checkpoints behind%s (%s)", formatEpoch(p), formatCount(p.Lag), formatPeers(p), formatRate(p.Rate, p.ETA))
+	}
+}
+
+// fleetExitCode maps the error a node's watcher returned to an exit code.
+func fleetExitCode(err error, last catchup.Progress) int {
+	var stall *catchup.StallError
+	var scrape *catchup.ScrapeError
+	switch {
+	case err == nil:
+		return exitCaughtUp
+	case errors.As(err, &stall):
+		return exitStalled
+	case errors.As(err, &scrape):
+		return exitScrapeFailed
+	case err == context.Canceled:
+		return exitInterrupted
+	case err == context.DeadlineExceeded && *follow && last.CaughtUp && !last.FellBehind:
+		return exitCaughtUp
+	case err == context.DeadlineExceeded && last.Err != nil:
+		return exitScrapeFailed
+	case err == context.DeadlineExceeded:
+		return exitTimeout
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	fleet_exec       = fleetFlags.String("fleet-exec", "", "With fleet wait, shell command run for each node once the nodes before it have caught up, before waiting for it, e.g. 'ssh $SUI_CATCHUP_HOST sudo systemctl restart sui-node'")
+	fleet_settle     = fleetFlags.Duration("fleet-settle", 30*time.Second, "With fleet wait, how long to wait after -fleet-exec before watching the node, so that its metrics from before the restart are not taken as caught up")
+	fleet_one_behind = fleetFlags.Bool("fleet-one-behind", false, "With fleet wait, move on to the next node only while every other node is caught up, so that at most one is behind at a time")
+)
+
+// parseFleetTargets parses the nodes of fleet wait, each a metrics URL, or a
+// host:port of /metrics, optionally named as name=address.
+func parseFleetTargets(args []string) ([]target, error) {
+	var targets []target
+	for _, arg := range args {
+		name, addr, ok := strings.Cut(arg, "=")
+		if !ok || strings.Contains(name, "/") {
+			name, addr = "", arg
+		}
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid node %q, must be a metrics URL or host:port", arg)
+		}
+		if u.Path == "" {
+			u.Path = "/metrics"
+		}
+		if name == "" {
+			name = u.Host
+		}
+		targets = append(targets, target{name: name, addr: u.String()})
+	}
+	return targets, nil
+}
+
+// runFleetWait implements fleet wait, the primitive of a rolling restart: it
+// waits for the given nodes, in order, to catch up one after the other,
+// running -fleet-exec for each before waiting for it, e.g. to restart it.
+// Without -fleet-exec, exiting 0 signals that the next node may be
+// restarted. The exit code is that of the first node that did not catch up.
+func runFleetWait(args []string) int {
+	if len(args) == 0 {
+		slog.Error("Please specify the nodes to wait for, in order, e.g. fleet wait node-1:9184 node-2:9184")
+		return exitError
+	}
+	targets, err := parseFleetTargets(args)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out := newOutput()
+	defer out.stop()
+	for i, t := range targets {
+		step := fmt.Sprintf("[%d/%d] %s", i+1, len(targets), t.name)
+		if *fleet_one_behind && len(targets) > 1 {
+			if err := waitOthersCaughtUp(ctx, out, targets, i); err != nil {
+				slog.Error("Waiting for the other nodes to catch up failed", "node", t.name, "err", err)
+				return fleetExitCode(err, catchup.Progress{})
+			}
+		}
+		if *fleet_exec != "" {
+			_, _ = fmt.Fprintf(out.log, "%s: running -fleet-exec\n", step)
+			if err := runFleetCommand(ctx, t, i); err != nil {
+				slog.Error(err.Error(), "node", t.name)
+				return exitError
+			}
+			select {
+			case <-time.After(*fleet_settle):
+			case <-ctx.Done():
+				return exitInterrupted
+			}
+		}
+		last, err := waitFleetNode(ctx, out, step, t)
+		if err != nil {
+			_, _ = fmt.Fprintf(out.log, "%s: did not catch up: %s\n", step, fleetStatus(last, err))
+			return fleetExitCode(err, last)
+		}
+		_, _ = fmt.Fprintf(out.log, "%s: caught up, %d checkpoints behind\n", step, int64(last.Lag))
+	}
+	_, _ = fmt.Fprintf(out.log, "All %d nodes caught up\n", len(targets))
+	return exitCaughtUp
+}
+
+// waitFleetNode waits up to -max-wait for t to catch up, showing its status
+// under step, and returns its last progress.
+func waitFleetNode(ctx context.Context, out *output, step string, t target) (catchup.Progress, error) {
+	w, err := newWatcher(t.addr, *stall_timeout)
+	if err != nil {
+		return catchup.Progress{}, err
+	}
+	if *max_wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *max_wait)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Wait(ctx)
+	}()
+	var last catchup.Progress
+	for p := range w.Events() {
+		last = p
+		// Catching up is logged once Wait returns.
+		if !p.CaughtUp {
+			_, _ = fmt.Fprintf(out.status, "%s: %s\n", step, fleetStatus(p, nil))
+		}
+	}
+	return last, <-done
+}
+
+// waitOthersCaughtUp scrapes every node but that at i on every interval
+// until all of them are caught up, a node that cannot be scraped counting as
+// behind as it may be restarting.
+func waitOthersCaughtUp(ctx context.Context, out *output, targets []target, i int) error {
+	watchers := make([]*catchup.Watcher, len(targets))
+	for j, t := range targets {
+		if j == i {
+			continue
+		}
+		w, err := newWatcher(t.addr, 0)
+		if err != nil {
+			return err
+		}
+		watchers[j] = w
+	}
+	if *max_wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *max_wait)
+		defer cancel()
+	}
+	var waiting string
+	for {
+		var behind []string
+		for j, w := range watchers {
+			if w == nil {
+				continue
+			}
+			if p, err := w.Check(ctx); err != nil || !p.CaughtUp {
+				behind = append(behind, targets[j].name)
+			}
+		}
+		if len(behind) == 0 {
+			return nil
+		}
+		if list := strings.Join(behind, ", "); list != waiting {
+			_, _ = fmt.Fprintf(out.log, "Waiting for %s to catch up before moving on to %s\n", list, targets[i].name)
+			waiting = list
+		}
+		select {
+		case <-time.After(*update_interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runFleetCommand runs -fleet-exec for the node t at index i, passing it in
+// SUI_CATCHUP_* environment variables.
+func runFleetCommand(ctx context.Context, t target, i int) error {
+	cmd := shellCommand(ctx, *fleet_exec)
+	var host string
+	if u, err := url.Parse(t.addr); err == nil {
+		host = u.Hostname()
+	}
+	cmd.Env = append(os.Environ(),
+		"SUI_CATCHUP_NODE="+t.name,
+		"SUI_CATCHUP_ADDR="+t.addr,
+		"SUI_CATCHUP_HOST="+host,
+		fmt.Sprintf("SUI_CATCHUP_INDEX=%d", i+1))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("-fleet-exec failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	grafana_url       = runFlags.String("grafana-url", "", "Post annotations to this Grafana when the node starts catching up, catches up, stalls or falls behind")
+	grafana_token     = runFlags.String("grafana-token", "", "Grafana service account token for -grafana-url")
+	grafana_dashboard = runFlags.String("grafana-dashboard-uid", "", "UID of the dashboard to annotate (default: an organization-wide annotation)")
+	grafana_tags      = runFlags.String("grafana-tags", "sui-catchup", "Comma-separated tags of the annotations, to which the node name and event are added")
+)
+
+// grafanaNotifier posts events as annotations, so that a restore's
+// lifecycle is overlaid on the node's dashboards.
+type grafanaNotifier struct {
+	url       string
+	header    http.Header
+	dashboard string
+	tags      []string
+}
+
+func newGrafanaNotifier() grafanaNotifier {
+	n := grafanaNotifier{
+		url:       strings.TrimSuffix(*grafana_url, "/") + "/api/annotations",
+		dashboard: *grafana_dashboard,
+		tags:      splitList(*grafana_tags),
+	}
+	if *grafana_token != "" {
+		n.header = http.Header{"Authorization": {"Bearer " + *grafana_token}}
+	}
+	return n
+}
+
+// timeline marks the notifier as wanting eventStarted.
+func (grafanaNotifier) timeline() {}
+
+func (g grafanaNotifier) notify(ctx context.Context, ev event) error {
+	// The tags are added in the key:value form Grafana's own tags take.
+	tags := append(append(append([]string(nil), g.tags...), ev.Node, ev.Kind), joinTags(":")...)
+	annotation := map[string]interface{}{
+		"time": ev.Progress.Time.UnixNano() / 1e6,
+		"tags": tags,
+		"text": ev.String(),
+	}
+	if g.dashboard != "" {
+		annotation["dashboardUID"] = g.dashboard
+	}
+	if err := postJSON(ctx, g.url, g.header, annotation); err != nil {
+		return fmt.Errorf("grafana: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	graphite_addr   = runFlags.String("graphite-addr", "", "Send the lag, rate and ETA to this Graphite carbon host:port in the plaintext protocol on every interval")
+	graphite_prefix = runFlags.String("graphite-prefix", "sui_catchup", "Prefix of the Graphite metric paths, e.g. sui_catchup.validator-1")
+)
+
+// graphiteTimeout bounds connecting to carbon and sending a batch, so that
+// an unreachable server does not hold up scraping.
+const graphiteTimeout = 5 * time.Second
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *graphite_addr == "" {
+			return nil, nil
+		}
+		return newGraphiteSink(*graphite_addr, *graphite_prefix), nil
+	})
+}
+
+// graphiteSink sends the derived catch-up state to carbon in the plaintext
+// protocol, e.g. sui_catchup.checkpoint_lag 1200 1767366245, over a TCP
+// connection that is reopened after a failure.
+type graphiteSink struct {
+	addr    string
+	prefix  string
+	tags    string // Graphite 1.1 tags suffix, e.g. ;region=eu-west-1
+	conn    net.Conn
+	lastErr string
+}
+
+func newGraphiteSink(addr, prefix string) *graphiteSink {
+	g := &graphiteSink{addr: addr, prefix: prefix}
+	for _, t := range tags {
+		g.tags += ";" + t.name + "=" + t.value
+	}
+	return g
+}
+
+// update sends the series of p. Like pusher.push, it returns an error only
+// when it differs from the previous update's.
+func (g *graphiteSink) update(p catchup.Progress) error {
+	if p.Err != nil {
+		return nil
+	}
+	err := g.send(p)
+	if err == nil {
+		g.lastErr = ""
+		return nil
+	}
+	if err.Error() == g.lastErr {
+		return nil
+	}
+	g.lastErr = err.Error()
+	return fmt.Errorf("sending to Graphite failed: %v", err)
+}
+
+func (g *graphiteSink) send(p catchup.Progress) error {
+	caughtUp := 0.0
+	if p.CaughtUp {
+		caughtUp = 1
+	}
+	var b bytes.Buffer
+	for _, s := range []struct {
+		name  string
+		value float64
+	}{
+		{"checkpoint_lag", p.Lag},
+		{"execution_lag", p.ExecutionLag},
+		{"catchup_rate", p.Rate},
+		{"eta_seconds", p.ETA.Seconds()},
+		{"caught_up", caughtUp},
+	} {
+		_, _ = fmt.Fprintf(&b, "%s.%s%s %s %d\n", g.prefix, s.name, g.tags, strconv.FormatFloat(s.value, 'f', -1, 64), p.Time.Unix())
+	}
+	if g.conn == nil {
+		conn, err := net.DialTimeout("tcp", g.addr, graphiteTimeout)
+		if err != nil {
+			return err
+		}
+		g.conn = conn
+	}
+	_ = g.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	if _, err := g.conn.Write(b.Bytes()); err != nil {
+		g.Close()
+		return err
+	}
+	return nil
+}
+
+func (g *graphiteSink) Close() error {
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
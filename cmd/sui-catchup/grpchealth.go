@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	grpc_listen  = serveFlags.String("grpc-listen", "", "In serve mode, also serve the standard gRPC health service grpc.health.v1.Health on this address, e.g. :9091, SERVING only while the node is ready")
+	grpc_service = serveFlags.String("grpc-service", "sui-node", "Service name the gRPC health service answers for besides the empty name of the server as a whole")
+)
+
+// Values of the HealthCheckResponse.ServingStatus enum.
+const (
+	grpcServing        = 1
+	grpcNotServing     = 2
+	grpcServiceUnknown = 3
+)
+
+// gRPC status codes answered besides OK.
+const (
+	grpcInvalid       = 3
+	grpcNotFound      = 5
+	grpcUnimplemented = 12
+)
+
+// grpcHealth serves grpc.health.v1.Health, reporting SERVING while the node
+// is ready and NOT_SERVING while it is not, so that gRPC-native load
+// balancers such as Envoy check its data freshness directly. It speaks gRPC
+// over HTTP/2 without TLS, as pkg/catchup speaks it to nodes, rather than
+// pulling in a gRPC framework for two methods.
+type grpcHealth struct {
+	mu      sync.Mutex
+	serving bool
+	// changed is closed, and replaced, whenever serving changes, waking
+	// the Watch streams.
+	changed chan struct{}
+}
+
+func newGRPCHealth() *grpcHealth {
+	return &grpcHealth{changed: make(chan struct{})}
+}
+
+// set records whether the node is ready.
+func (g *grpcHealth) set(ready bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ready == g.serving {
+		return
+	}
+	g.serving = ready
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// status returns the serving status and a channel closed once it changes.
+func (g *grpcHealth) status() (int, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.serving {
+		return grpcServing, g.changed
+	}
+	return grpcNotServing, g.changed
+}
+
+// server returns a server of the health service on addr.
+func (g *grpcHealth) server(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/grpc.health.v1.Health/Check", g.check)
+	mux.HandleFunc("/grpc.health.v1.Health/Watch", g.watch)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		grpcFinish(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	})
+	return &http.Server{Addr: addr, Handler: h2c.NewHandler(mux, &http2.Server{})}
+}
+
+// check answers Check with the current status.
+func (g *grpcHealth) check(w http.ResponseWriter, r *http.Request) {
+	service, err := readHealthRequest(r.Body)
+	if err != nil {
+		grpcFinish(w, grpcInvalid, err.Error())
+		return
+	}
+	if !knownService(service) {
+		grpcFinish(w, grpcNotFound, "unknown service "+strconv.Quote(service))
+		return
+	}
+	status, _ := g.status()
+	grpcStart(w)
+	_, _ = w.Write(healthResponse(status))
+	grpcFinish(w, 0, "")
+}
+
+// watch streams the status on Watch, once at first and then on every
+// change, until the client goes away.
+func (g *grpcHealth) watch(w http.ResponseWriter, r *http.Request) {
+	service, err := readHealthRequest(r.Body)
+	if err != nil {
+		grpcFinish(w, grpcInvalid, err.Error())
+		return
+	}
+	grpcStart(w)
+	flusher, _ := w.(http.Flusher)
+	for {
+		status, changed := g.status()
+		if !knownService(service) {
+			// Watch reports unknown services rather than failing, in
+			// case they are added later.
+			status, changed = grpcServiceUnknown, nil
+		}
+		if _, err := w.Write(healthResponse(status)); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func knownService(service string) bool {
+	return service == "" || service == *grpc_service
+}
+
+// readHealthRequest reads the service of a HealthCheckRequest.
+func readHealthRequest(body io.Reader) (string, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return "", fmt.Errorf("reading request failed: %v", err)
+	}
+	if header[0] != 0 {
+		return "", fmt.Errorf("compressed requests are not supported")
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if len(msg) > 1<<16 {
+		return "", fmt.Errorf("request of %d bytes is too large", len(msg))
+	}
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return "", fmt.Errorf("reading request failed: %v", err)
+	}
+	var service string
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return "", fmt.Errorf("decoding request failed: %v", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeString(msg)
+			if n < 0 {
+				return "", fmt.Errorf("decoding request failed: %v", protowire.ParseError(n))
+			}
+			service, msg = v, msg[n:]
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+			return "", fmt.Errorf("decoding request failed: %v", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+	}
+	return service, nil
+}
+
+// healthResponse frames a HealthCheckResponse with the given status.
+func healthResponse(status int) []byte {
+	msg := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), uint64(status))
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// grpcStart sends the headers of a gRPC response.
+func grpcStart(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcFinish ends a gRPC response with its status in the trailers.
+func grpcFinish(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", message)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"time"
+)
+
+var (
+	haproxy_listen     = serveFlags.String("haproxy-agent-listen", "", "In serve mode, answer HAProxy agent checks on this TCP address, e.g. :9092, with up while the node is ready and down while it is not")
+	haproxy_weight_lag = serveFlags.Int("haproxy-agent-weight-lag", 0, "Lag in checkpoints below which a node that is not ready yet is weighted back in by the HAProxy agent check in proportion to how close it is, instead of down (0 disables)")
+)
+
+// haproxyAgent answers HAProxy's agent-check: on every connection, it
+// writes the server's state and closes it, so that HAProxy pulls an RPC
+// backend out of rotation while the node catches up.
+type haproxyAgent struct {
+	state *health
+	ln    net.Listener
+}
+
+func newHAProxyAgent(addr string, state *health) (*haproxyAgent, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for HAProxy agent checks failed: %v", err)
+	}
+	return &haproxyAgent{state: state, ln: ln}, nil
+}
+
+// serve answers agent checks until the agent is closed.
+func (a *haproxyAgent) serve() {
+	for {
+		conn, err := a.ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if _, err := fmt.Fprintf(conn, "%s\n", a.response()); err != nil {
+				slog.Debug("Answering HAProxy agent check failed", "err", err)
+			}
+		}()
+	}
+}
+
+func (a *haproxyAgent) close() error {
+	return a.ln.Close()
+}
+
+// response is the agent's answer: up at full weight while the node is
+// ready, and down while it is not, or up at a weight growing as its lag
+// shrinks below -haproxy-agent-weight-lag. The weight always accompanies up
+// so that it is restored once the node is ready.
+func (a *haproxyAgent) response() string {
+	s := a.state.status()
+	if s.Ready {
+		return "up 100%"
+	}
+	failing := s.ScrapedAt == nil || s.FailedScrapes > 0
+	if *haproxy_weight_lag <= 0 || failing || s.Lag >= int64(*haproxy_weight_lag) {
+		return "down"
+	}
+	weight := math.Max(1, math.Floor(100*(1-float64(s.Lag)/float64(*haproxy_weight_lag))))
+	return fmt.Sprintf("up %.0f%%", weight)
+}
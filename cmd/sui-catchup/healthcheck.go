@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	healthcheck   = runFlags.Bool("healthcheck", false, "Scrape once and exit 0 if the node is at most -lag-threshold checkpoints behind, 1 otherwise, without any output")
+	lag_threshold = reportFlags.Int("lag-threshold", 0, "Lag in checkpoints up to which -healthcheck considers the node healthy")
+)
+
+// runHealthcheck implements -healthcheck, e.g. for Docker's HEALTHCHECK or
+// cron jobs. The node is also unhealthy when it crosses an alert threshold;
+// the rate takes a second scrape an interval later.
+func runHealthcheck(watcher *catchup.Watcher) int {
+	ctx := context.Background()
+	p, err := watcher.Check(ctx)
+	if err != nil || p.Lag > float64(*lag_threshold) {
+		return exitError
+	}
+	if *alert_min_rate > 0 && !p.CaughtUp {
+		time.Sleep(*update_interval)
+		if p, err = watcher.Check(ctx); err != nil {
+			return exitError
+		}
+	}
+	if alertReason(p) != "" {
+		return exitError
+	}
+	return exitCaughtUp
+}
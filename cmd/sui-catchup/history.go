@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var history_db = historyFlags.String("history-db", "", "Record samples in this SQLite database, keyed by -addr, so that history survives restarts")
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id           INTEGER PRIMARY KEY,
+	node         TEXT    NOT NULL,
+	started      INTEGER NOT NULL,
+	updated      INTEGER NOT NULL,
+	start_synced INTEGER NOT NULL,
+	synced       INTEGER NOT NULL,
+	known        INTEGER NOT NULL,
+	caught_up    INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS sessions_node ON sessions (node, started);
+CREATE TABLE IF NOT EXISTS samples (
+	session INTEGER NOT NULL REFERENCES sessions (id),
+	time    INTEGER NOT NULL,
+	known   INTEGER NOT NULL,
+	synced  INTEGER NOT NULL,
+	lag     INTEGER NOT NULL,
+	rate    REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_session ON samples (session, time);
+`
+
+func init() {
+	registerSink(func(w *catchup.Watcher) (sink, error) {
+		if *history_db == "" {
+			return nil, nil
+		}
+		store, err := openHistory(*history_db, *validator_addr)
+		if err != nil {
+			return nil, err
+		}
+		// A replay runs on the recording's clock, which the samples of
+		// earlier sessions are not on.
+		if *replay_file == "" {
+			samples, err := store.recent(seedSpan())
+			if err != nil {
+				store.Close()
+				return nil, err
+			}
+			if len(samples) > 0 {
+				w.Seed(samples)
+				slog.Info("Resuming the rate from the last session", "samples", len(samples), "since", formatETA(time.Since(samples[len(samples)-1].Time)))
+			}
+		}
+		return store, nil
+	})
+}
+
+// historyDB records the samples of a node's catch-up sessions. A session
+// lasts until the node has caught up, so a catch-up interrupted by a restart
+// of sui-catchup is resumed rather than split in two. Times are stored as
+// Unix nanoseconds.
+type historyDB struct {
+	db      *sql.DB
+	node    string
+	session int64 // 0 until the first sample of a new session
+}
+
+// openHistory opens or creates the database at path and resumes the node's
+// last session if it had not caught up yet.
+func openHistory(path, node string) (*historyDB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening history database failed: %v", err)
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening history database failed: %v", err)
+	}
+	h := &historyDB{db: db, node: node}
+	err = db.QueryRow(`SELECT id FROM sessions WHERE node = ? AND caught_up = 0 ORDER BY started DESC LIMIT 1`, node).Scan(&h.session)
+	if err != nil && err != sql.ErrNoRows {
+		db.Close()
+		return nil, fmt.Errorf("reading history database failed: %v", err)
+	}
+	return h, nil
+}
+
+// update records p. Failed scrapes are skipped as they carry no new
+// watermarks.
+func (h *historyDB) update(p catchup.Progress) error {
+	if p.Err != nil {
+		return nil
+	}
+	if h.session == 0 {
+		res, err := h.db.Exec(`INSERT INTO sessions (node, started, updated, start_synced, synced, known) VALUES (?, ?, ?, ?, ?, ?)`,
+			h.node, p.Time.UnixNano(), p.Time.UnixNano(), int64(p.Synced), int64(p.Synced), int64(p.Known))
+		if err != nil {
+			return fmt.Errorf("writing history database failed: %v", err)
+		}
+		if h.session, err = res.LastInsertId(); err != nil {
+			return fmt.Errorf("writing history database failed: %v", err)
+		}
+	}
+	_, err := h.db.Exec(`INSERT INTO samples (session, time, known, synced, lag, rate) VALUES (?, ?, ?, ?, ?, ?)`,
+		h.session, p.Time.UnixNano(), int64(p.Known), int64(p.Synced), int64(p.Lag), p.Rate)
+	if err == nil {
+		_, err = h.db.Exec(`UPDATE sessions SET updated = ?, synced = ?, known = ?, caught_up = ? WHERE id = ?`,
+			p.Time.UnixNano(), int64(p.Synced), int64(p.Known), p.CaughtUp, h.session)
+	}
+	if err != nil {
+		return fmt.Errorf("writing history database failed: %v", err)
+	}
+	if p.CaughtUp {
+		// Anything after catching up, e.g. in -follow mode, belongs to the
+		// next catch-up.
+		h.session = 0
+	}
+	return nil
+}
+
+// seedSpan is how far back the rate is seeded from: long enough for the
+// moving average, with its default 30s smoothing, to settle and to cover the
+// -rate-window or the -rate-samples of the other rates.
+func seedSpan() time.Duration {
+	span := 5 * 30 * time.Second
+	if d := time.Duration(*rate_samples) * *update_interval; d > span {
+		span = d
+	}
+	if *rate_window > span {
+		span = *rate_window
+	}
+	return span
+}
+
+// recent returns the samples of the resumed session within span of its last
+// one, oldest first, or none if no session was resumed.
+func (h *historyDB) recent(span time.Duration) ([]catchup.Observation, error) {
+	if h.session == 0 {
+		return nil, nil
+	}
+	rows, err := h.db.Query(`SELECT time, known, synced FROM samples WHERE session = ?1
+		AND time >= (SELECT MAX(time) FROM samples WHERE session = ?1) - ?2 ORDER BY time`, h.session, span.Nanoseconds())
+	if err != nil {
+		return nil, fmt.Errorf("reading history database failed: %v", err)
+	}
+	defer rows.Close()
+	var samples []catchup.Observation
+	for rows.Next() {
+		var at, known, synced int64
+		if err := rows.Scan(&at, &known, &synced); err != nil {
+			return nil, fmt.Errorf("reading history database failed: %v", err)
+		}
+		samples = append(samples, catchup.Observation{Time: time.Unix(0, at), Known: float64(known), Synced: float64(synced)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading history database failed: %v", err)
+	}
+	return samples, nil
+}
+
+func (h *historyDB) Close() error {
+	return h.db.Close()
+}
+
+// printHistory writes the sessions recorded in the database at path, oldest
+// first, e.g.
+//
+//	NODE                           STARTED               DURATION  SYNCED  RATE   STATUS
+//	http://localhost:9184/metrics  2023-06-01T09:12:44Z  2h11m     118200  15/s   caught up
+func printHistory(w io.Writer, path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("opening history database failed: %v", err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT node, started, updated, start_synced, synced, known, caught_up FROM sessions ORDER BY started`)
+	if err != nil {
+		return fmt.Errorf("reading history database failed: %v", err)
+	}
+	defer rows.Close()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NODE\tSTARTED\tDURATION\tSYNCED\tRATE\tSTATUS")
+	for rows.Next() {
+		var node string
+		var started, updated, startSynced, synced, known int64
+		var caughtUp bool
+		if err := rows.Scan(&node, &started, &updated, &startSynced, &synced, &known, &caughtUp); err != nil {
+			return fmt.Errorf("reading history database failed: %v", err)
+		}
+		duration := time.Duration(updated - started)
+		var rate float64
+		if duration > 0 {
+			rate = float64(synced-startSynced) / duration.Seconds()
+		}
+		status := "caught up"
+		if !caughtUp {
+			status = fmt.Sprintf("%d checkpoints behind", known-synced)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d/s\t%s\n", node, time.Unix(0, started).UTC().Format(time.RFC3339),
+			formatETA(duration), synced-startSynced, int64(rate), status)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading history database failed: %v", err)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	on_start_exec       = runFlags.String("on-start-exec", "", "Shell command run when sui-catchup starts watching a node that is behind, with its state in SUI_CATCHUP_* environment variables")
+	on_progress_exec    = runFlags.String("on-progress-exec", "", "Shell command run at most every -on-progress-interval while watching, with the node's state in SUI_CATCHUP_* environment variables")
+	on_progress_every   = runFlags.Duration("on-progress-interval", time.Minute, "Least time between runs of -on-progress-exec")
+	on_caught_up_exec   = runFlags.String("on-caught-up-exec", "", "Shell command run when the node has caught up, e.g. 'systemctl start sui-rpc-proxy'")
+	on_fall_behind_exec = runFlags.String("on-fall-behind-exec", "", "Shell command run when the node falls behind again in -follow mode")
+	on_exec_timeout     = runFlags.Duration("on-exec-timeout", 5*time.Minute, "How long the -on-*-exec commands but -on-stall-exec may run before being killed")
+)
+
+// execHooks runs the -on-*-exec commands of the lifecycle events, and of
+// -on-progress-exec on the scrapes it is not throttled for, for site-specific
+// automation.
+type execHooks struct {
+	// ran is when -on-progress-exec last ran, zero if it never did, and
+	// done is closed once it has finished.
+	ran  time.Time
+	done chan struct{}
+}
+
+// newExecHooks returns the hooks of the events, or nil without any.
+func newExecHooks() *execHooks {
+	if *on_start_exec == "" && *on_caught_up_exec == "" && *on_fall_behind_exec == "" && *on_progress_exec == "" {
+		return nil
+	}
+	return &execHooks{}
+}
+
+// timeline makes execHooks a timelineNotifier, as -on-start-exec is run on
+// eventStarted.
+func (h *execHooks) timeline() {}
+
+func (h *execHooks) notify(ctx context.Context, ev event) error {
+	var option, command string
+	switch ev.Kind {
+	case eventStarted:
+		option, command = "-on-start-exec", *on_start_exec
+	case eventCaughtUp:
+		option, command = "-on-caught-up-exec", *on_caught_up_exec
+	case eventFellBehind:
+		option, command = "-on-fall-behind-exec", *on_fall_behind_exec
+	}
+	if command == "" {
+		return nil
+	}
+	// Commands get their own timeout rather than that of notifications.
+	return runHook(context.Background(), option, command, ev.Kind, ev.Progress, ev.Elapsed)
+}
+
+// progress runs -on-progress-exec in the background for p, unless it ran
+// less than -on-progress-interval ago or has not finished yet.
+func (h *execHooks) progress(ctx context.Context, p catchup.Progress, elapsed time.Duration) {
+	if *on_progress_exec == "" || p.Err != nil {
+		return
+	}
+	if !h.ran.IsZero() && time.Since(h.ran) < *on_progress_every {
+		return
+	}
+	if h.done != nil {
+		select {
+		case <-h.done:
+		default:
+			return
+		}
+	}
+	h.ran = time.Now()
+	h.done = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		if err := runHook(ctx, "-on-progress-exec", *on_progress_exec, "progress", p, elapsed); err != nil {
+			slog.Warn(err.Error())
+		}
+	}(h.done)
+}
+
+// runHook runs command of option for an event of the given kind with the
+// shell, passing the node's state in SUI_CATCHUP_* environment variables.
+func runHook(ctx context.Context, option, command, kind string, p catchup.Progress, elapsed time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, *on_exec_timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), hookEnv(kind, p, elapsed)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", option, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// hookEnv returns the SUI_CATCHUP_* environment variables describing p.
+func hookEnv(kind string, p catchup.Progress, elapsed time.Duration) []string {
+	return []string{
+		"SUI_CATCHUP_EVENT=" + kind,
+		"SUI_CATCHUP_NODE=" + nodeName(),
+		fmt.Sprintf("SUI_CATCHUP_KNOWN=%d", int64(p.Known)),
+		fmt.Sprintf("SUI_CATCHUP_SYNCED=%d", int64(p.Synced)),
+		fmt.Sprintf("SUI_CATCHUP_LAG=%d", int64(p.Lag)),
+		fmt.Sprintf("SUI_CATCHUP_RATE=%.2f", p.Rate),
+		fmt.Sprintf("SUI_CATCHUP_SYNC_RATE=%.2f", p.SyncRate),
+		fmt.Sprintf("SUI_CATCHUP_ETA_SECONDS=%d", int64(p.ETA.Seconds())),
+		fmt.Sprintf("SUI_CATCHUP_EPOCH=%d", int64(p.Epoch)),
+		fmt.Sprintf("SUI_CATCHUP_CAUGHT_UP=%t", p.CaughtUp),
+		fmt.Sprintf("SUI_CATCHUP_ELAPSED_SECONDS=%d", int64(elapsed.Seconds())),
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	influx_dest  = runFlags.String("influx", "", "Write a sample per interval in InfluxDB line protocol to this write endpoint, e.g. http://influxdb:8086/api/v2/write?org=ops&bucket=sui, or append it to this file")
+	influx_token = runFlags.String("influx-token", "", "API token sent to the -influx endpoint")
+)
+
+// influxTimeout bounds a write to the -influx endpoint.
+const influxTimeout = 5 * time.Second
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *influx_dest == "" {
+			return nil, nil
+		}
+		return newInfluxSink(*influx_dest, *influx_token, nodeName())
+	})
+}
+
+// influxSink writes samples in InfluxDB line protocol, e.g.
+//
+//	sui_catchup,node=validator-1 lag=1200,rate=15.2,caught_up=false 1767366245000000000
+//
+// to an InfluxDB or Telegraf HTTP endpoint, or appends them to a file for
+// Telegraf's tail input.
+type influxSink struct {
+	url     string
+	header  http.Header
+	file    *os.File
+	node    string
+	lastErr string
+}
+
+func newInfluxSink(dest, token, node string) (*influxSink, error) {
+	s := &influxSink{node: node}
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		s.url = dest
+		if token != "" {
+			s.header = http.Header{"Authorization": {"Token " + token}}
+		}
+		return s, nil
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening -influx file failed: %v", err)
+	}
+	s.file = f
+	return s, nil
+}
+
+// line renders p as a point of the sui_catchup measurement.
+func (s *influxSink) line(p catchup.Progress) string {
+	fields := []string{
+		"known=" + influxFloat(p.Known),
+		"synced=" + influxFloat(p.Synced),
+		"lag=" + influxFloat(p.Lag),
+		"execution_lag=" + influxFloat(p.ExecutionLag),
+		"rate=" + influxFloat(p.Rate),
+		"eta_seconds=" + influxFloat(p.ETA.Seconds()),
+		"caught_up=" + strconv.FormatBool(p.CaughtUp),
+	}
+	series := "sui_catchup,node=" + influxEscape(s.node)
+	for _, t := range tags {
+		series += "," + influxEscape(t.name) + "=" + influxEscape(t.value)
+	}
+	return fmt.Sprintf("%s %s %d\n", series, strings.Join(fields, ","), p.Time.UnixNano())
+}
+
+// update writes the point of p. Like pusher.push, it returns an error only
+// when it differs from the previous update's.
+func (s *influxSink) update(p catchup.Progress) error {
+	if p.Err != nil {
+		return nil
+	}
+	line := s.line(p)
+	var err error
+	if s.file != nil {
+		_, err = s.file.WriteString(line)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), influxTimeout)
+		err = post(ctx, s.url, s.header, "text/plain; charset=utf-8", []byte(line))
+		cancel()
+	}
+	if err == nil {
+		s.lastErr = ""
+		return nil
+	}
+	if err.Error() == s.lastErr {
+		return nil
+	}
+	s.lastErr = err.Error()
+	return fmt.Errorf("writing to InfluxDB failed: %v", err)
+}
+
+func (s *influxSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func influxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// influxEscape escapes a tag value for line protocol.
+func influxEscape(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	kube_selector  = nodeFlags.String("kube-selector", "", "Watch every running pod matching this Kubernetes label selector, e.g. app=sui-fullnode, instead of -addr")
+	kube_namespace = nodeFlags.String("kube-namespace", "", "Namespace of the pods to watch (default: the current context's or the pod's own namespace)")
+	kube_port      = nodeFlags.String("kube-port", "metrics", "Name or number of the container port serving the pods' metrics")
+	kube_path      = nodeFlags.String("kube-path", "/metrics", "Path of the pods' metrics endpoint")
+)
+
+// runKube watches the pods selected by -kube-selector.
+func runKube() int {
+	targets, err := discoverPods(context.Background())
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+	if len(targets) == 0 {
+		slog.Error("No running pods match the selector", "selector", *kube_selector)
+		return exitError
+	}
+	return runFleet(targets)
+}
+
+// kubeClient returns a client for the cluster of the current kubeconfig
+// context, or the one sui-catchup runs in, along with its configuration and
+// the namespace to look for pods in.
+func kubeClient() (*kubernetes.Clientset, *rest.Config, string, error) {
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+	rest, err := config.ClientConfig()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("loading Kubernetes configuration failed: %v", err)
+	}
+	namespace := *kube_namespace
+	if namespace == "" {
+		if namespace, _, err = config.Namespace(); err != nil {
+			return nil, nil, "", fmt.Errorf("loading Kubernetes configuration failed: %v", err)
+		}
+	}
+	client, err := kubernetes.NewForConfig(rest)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("creating Kubernetes client failed: %v", err)
+	}
+	return client, rest, namespace, nil
+}
+
+// discoverPods returns the metrics addresses of the running pods matching
+// -kube-selector, ordered by name.
+func discoverPods(ctx context.Context) ([]target, error) {
+	client, _, namespace, err := kubeClient()
+	if err != nil {
+		return nil, err
+	}
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: *kube_selector})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods failed: %v", err)
+	}
+
+	var targets []target
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		port, ok := containerPort(pod, *kube_port)
+		if !ok {
+			return nil, fmt.Errorf("pod %s has no container port %q", pod.Name, *kube_port)
+		}
+		targets = append(targets, target{
+			name: pod.Name,
+			addr: "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))) + *kube_path,
+		})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets, nil
+}
+
+// containerPort looks up the port with the given name or number among the
+// pod's containers.
+func containerPort(pod corev1.Pod, port string) (int32, bool) {
+	if n, err := strconv.Atoi(port); err == nil {
+		return int32(n), true
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == port {
+				return p.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
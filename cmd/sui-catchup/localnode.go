@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// discoverLocalNode returns the metrics address to watch when -addr was not
+// given: the first of the common metrics ports on localhost that serves
+// metrics, or else a port a running sui-node process listens on that does.
+// Failing both, the default -addr is watched, for a node that is still
+// starting.
+func discoverLocalNode() string {
+	if f := flag.Lookup("addr"); f.Value.String() != f.DefValue {
+		return *validator_addr
+	}
+	// Only the local host is probed.
+	if *prometheus_url != "" || *replay_file != "" || *ssh_host != "" || *proxy_url != "" || *node_config != "" || *source != catchup.SourceMetrics {
+		return *validator_addr
+	}
+	addr, err := probeMetricsPorts("localhost", metricsPorts)
+	if err != nil {
+		ports := nodeListenPorts()
+		if len(ports) == 0 {
+			return *validator_addr
+		}
+		if addr, err = probeMetricsPorts("localhost", ports); err != nil {
+			return *validator_addr
+		}
+	}
+	if addr != *validator_addr {
+		slog.Info("Found the node's metrics", "addr", addr)
+		// Names and fallbacks go by -addr.
+		_ = flag.Set("addr", addr)
+	}
+	return addr
+}
+
+// nodeListenPorts returns the TCP ports that running sui-node processes
+// listen on, read from /proc. It returns none where there is no /proc or the
+// processes belong to another user.
+func nodeListenPorts() []string {
+	inodes := map[string]bool{}
+	dirs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range dirs {
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil || strings.TrimSpace(string(comm)) != "sui-node" {
+			continue
+		}
+		fds, _ := filepath.Glob(filepath.Join(dir, "fd", "*"))
+		for _, fd := range fds {
+			// Sockets link to socket:[inode].
+			if target, err := os.Readlink(fd); err == nil && strings.HasPrefix(target, "socket:[") {
+				inodes[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] = true
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	var ports []string
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		for _, port := range listeningPorts(table, inodes) {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// listenState is the state of a listening socket in /proc/net/tcp.
+const listenState = "0A"
+
+// listeningPorts returns the ports of the listening sockets in the
+// /proc/net/tcp style table whose inodes are among inodes.
+func listeningPorts(table string, inodes map[string]bool) []string {
+	f, err := os.Open(table)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var ports []string
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != listenState || !inodes[fields[9]] {
+			continue
+		}
+		_, hex, ok := strings.Cut(fields[1], ":")
+		port, err := strconv.ParseUint(hex, 16, 16)
+		if !ok || err != nil {
+			continue
+		}
+		ports = append(ports, fmt.Sprint(port))
+	}
+	return ports
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	log_file     = commonFlags.String("log-file", "", "Also log errors, warnings and progress milestones to this file, rotating it by -log-max-size and -log-max-age")
+	log_max_size = commonFlags.Int("log-max-size", 100, "Size in megabytes beyond which -log-file is rotated, or 0 for no limit")
+	log_max_age  = commonFlags.Duration("log-max-age", 24*time.Hour, "Age beyond which -log-file is rotated, or 0 for no limit")
+	log_keep     = commonFlags.Int("log-keep", 7, "Number of rotated -log-file files kept")
+)
+
+// milestones logs progress milestones, such as the node catching up, to
+// -log-file only, as they are already shown with the status.
+var milestones = slog.New(discardHandler{})
+
+// rotatingFile is a log file that is renamed with the time of its rotation
+// appended, e.g. sui-catchup.log.20260102T150405, once it grows beyond
+// maxSize bytes or gets older than maxAge, keeping the last keep rotated
+// files.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, fmt.Errorf("opening -log-file failed: %v", err)
+	}
+	return r, nil
+}
+
+// open opens the file for appending, continuing with an existing file.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	full := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	old := r.maxAge > 0 && time.Since(r.opened) >= r.maxAge
+	if full || old {
+		if err := r.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating -log-file failed: %v", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.path+"."+time.Now().Format("20060102T150405")); err != nil {
+		return err
+	}
+	// The timestamps sort in the order of rotation.
+	rotated, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(rotated)
+	for len(rotated) > r.keep {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return r.open()
+}
+
+// fanoutHandler passes records to every handler that is enabled for them.
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var first error
+	for _, handler := range h {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := handler.Handle(ctx, record.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
+
+// discardHandler drops every record.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
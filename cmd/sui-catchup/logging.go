@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	log_level  = commonFlags.String("log-level", "info", "Least severe level of the errors and warnings logged: debug, info, warn or error")
+	log_format = commonFlags.String("log-format", "text", "Format of the errors and warnings logged: text or json")
+)
+
+// logOutput is where errors and warnings are logged: standard error, or
+// above the status while it is updated in place on the same terminal, so
+// that the next update does not overwrite them.
+var logOutput = &swapWriter{w: os.Stderr}
+
+// swapWriter is a writer whose destination can be changed while in use.
+type swapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *swapWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// set changes the destination to w and returns the previous one.
+func (s *swapWriter) set(w io.Writer) io.Writer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.w
+	s.w = w
+	return prev
+}
+
+// setupLogging makes the default slog logger, which the log package writes
+// to as well, log to logOutput and -log-file as configured by -log-level and
+// -log-format.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*log_level)); err != nil {
+		return fmt.Errorf("invalid -log-level %q, must be debug, info, warn or error", *log_level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	handler, err := newLogHandler(logOutput, opts)
+	if err != nil {
+		return err
+	}
+	if *log_file != "" {
+		f, err := openRotatingFile(*log_file, int64(*log_max_size)<<20, *log_max_age, *log_keep)
+		if err != nil {
+			return err
+		}
+		file, _ := newLogHandler(f, opts)
+		handler = fanoutHandler{handler, file}
+		milestones = slog.New(redactHandler{file})
+	}
+	slog.SetDefault(slog.New(redactHandler{errorRecorder{handler}}))
+	return nil
+}
+
+// newLogHandler returns a handler writing to w in -log-format.
+func newLogHandler(w io.Writer, opts *slog.HandlerOptions) (slog.Handler, error) {
+	switch strings.ToLower(*log_format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid -log-format %q, must be text or json", *log_format)
+	}
+}
@@ -1,152 +1,718 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gosuri/uilive"
-	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
-	"go.uber.org/atomic"
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
 )
 
 var (
-	validator_addr  = flag.String("addr", "http://localhost:9184/metrics", "Validator metrics address")
-	update_interval = flag.Int("interval", 1, "How often to check in seconds")
-
-	metric_channel chan *dto.MetricFamily = make(chan *dto.MetricFamily, 2)
-
-	highest_known_checkpoint  atomic.Float64
-	highest_synced_checkpoint atomic.Float64
-	last_delta                atomic.Float64
+	validator_addr  = nodeFlags.String("addr", "http://localhost:9184/metrics", "Validator metrics address, or unix:///path/to/socket")
+	fallback_addrs  = nodeFlags.String("fallback-addr", "", "Comma-separated other metrics URLs of the same node, e.g. its pod IP and an ingress, failed over to in order when the one in use is unreachable")
+	update_interval = newDurationFlag(nodeFlags, "interval", time.Second, "How often to check, e.g. 250ms or 10s")
+	max_interval    = newDurationFlag(nodeFlags, "max-interval", 0, "Check less often while far behind, every hundredth of the ETA but at most this often, e.g. 1m, and every -interval close to the tip (0 disables)")
+	known_metric    = nodeFlags.String("known-metric", "", "Name of the metric holding the highest known checkpoint (default: auto-detect)")
+	synced_metric   = nodeFlags.String("synced-metric", "", "Name of the metric holding the highest synced checkpoint (default: auto-detect)")
+	executed_metric = nodeFlags.String("executed-metric", "", "Name of the metric holding the highest executed checkpoint (default: auto-detect)")
+	lag_expr        = nodeFlags.String("expr", "", "Define the lag as an expression over the node's metrics, e.g. 'highest_known_checkpoint - last_executed_checkpoint'")
+	require_exec    = nodeFlags.Bool("require-executed", false, "Only consider the node caught up once it has also executed up to the tip")
+	health_url      = nodeFlags.String("health-url", "", "Also probe whether the node serves requests: a health endpoint URL answering 2xx, or tcp://host:port of e.g. its RPC port")
+	require_healthy = nodeFlags.Bool("require-healthy", false, "Only consider the node caught up while -health-url succeeds")
+	epoch_metric    = nodeFlags.String("epoch-metric", "", "Name of the metric holding the node's current epoch (default: auto-detect)")
+	series_labels   = nodeFlags.String("label", "", "Comma-separated name=value labels selecting the series of metrics exposed once per store or network, e.g. store=perpetual")
+	consensus       = nodeFlags.Bool("consensus", false, "Also report a validator's consensus lag between the highest received and last committed round")
+	received_metric = nodeFlags.String("received-round-metric", "", "Name of the metric holding the highest received consensus round (default: auto-detect)")
+	commit_metric   = nodeFlags.String("committed-round-metric", "", "Name of the metric holding the last committed consensus round (default: auto-detect)")
+	db_size_metric  = nodeFlags.String("db-size-metric", "", "Name of the metric holding the size of the node's database in bytes (default: auto-detect)")
+	pruned_metric   = nodeFlags.String("pruned-metric", "", "Name of the metric holding the highest pruned checkpoint (default: auto-detect)")
+	pruned_obj      = nodeFlags.String("pruned-objects-metric", "", "Name of the metric counting the objects the node has pruned (default: auto-detect)")
+	archive_metric  = nodeFlags.String("archive-metric", "", "Name of the metric counting checkpoints fetched from the archive fallback (default: auto-detect)")
+	peers_metric    = nodeFlags.String("peers-metric", "", "Name of the metric holding the node's number of connected peers (default: auto-detect)")
+	mode            = nodeFlags.String("mode", catchup.ModeNode, "What to watch: node, snapshot-restore to also watch a formal snapshot restore before the catch-up, indexer, graphql for the GraphQL service at -addr, or archive for the checkpoints an archival fullnode has uploaded to its archive against those it has executed")
+	source          = nodeFlags.String("source", catchup.SourceMetrics, "Where the node's watermarks are read from: metrics, its Prometheus metrics, grpc, the gRPC API of newer sui-node builds at -addr such as http://localhost:9000, against -rpc-tip-url or -reference-addr, or exec:/path/to/script, a program run on every update that prints the known and synced checkpoints as JSON or name=value lines")
+	track           = nodeFlags.String("track", catchup.TrackCheckpoints, "Watermarks that decide whether the node has caught up: checkpoints, transactions or both")
+	known_tx_metric = nodeFlags.String("known-tx-metric", "", "Name of the metric holding the highest known transaction (default: auto-detect)")
+	exec_tx_metric  = nodeFlags.String("executed-tx-metric", "", "Name of the metric holding the highest executed transaction (default: auto-detect)")
+	rpc_tip_url     = nodeFlags.String("rpc-tip-url", "", "Take the network tip from this Sui JSON-RPC endpoint instead of the node's highest known checkpoint, or from the -rpc-tip-quorum of comma-separated ones")
+	rpc_tip_quorum  = nodeFlags.String("rpc-tip-quorum", catchup.TipMedian, "How several -rpc-tip-url endpoints agree on the tip: median, which one stale or lying endpoint cannot skew, or max")
+	verify_rpc_url  = nodeFlags.String("verify-rpc-url", "", "Once caught up, compare the digest of the latest checkpoint on the node's own JSON-RPC endpoint with -trusted-rpc-url, and only consider the node caught up if they match")
+	trusted_rpc_url = nodeFlags.String("trusted-rpc-url", "", "Sui JSON-RPC endpoint -verify-rpc-url is compared with (default: -rpc-tip-url)")
+	reference_addr  = nodeFlags.String("reference-addr", "", "Take the network tip from the synced checkpoint of this known-healthy node's metrics address")
+	caught_up_lag   = nodeFlags.Int("caught-up-lag", 0, "Consider the node caught up once it is at most this many checkpoints behind")
+	rate_window     = nodeFlags.Duration("rate-window", 0, "Compute the catch-up rate over the samples of this window, e.g. 60s, instead of as a moving average")
+	rate_algo       = nodeFlags.String("rate-algo", "", "How the catch-up rate is estimated: ewma, window (over -rate-window), median or regression (over -rate-samples) (default: window with -rate-window, ewma otherwise)")
+	rate_samples    = nodeFlags.Int("rate-samples", catchup.DefaultRateSamples, "Number of scrapes the median and regression -rate-algo estimate the rate over")
+	max_wait        = waitFlags.Duration("max-wait", 0, "Give up if the node has not caught up after this long (0 waits forever)")
+	scrape_timeout  = transportFlags.Duration("scrape-timeout", 30*time.Second, "Timeout for each scrape as a whole, including tip and reference requests")
+	max_errors      = nodeFlags.Int("max-errors", 0, "Exit after this many consecutive failed scrapes (0 retries forever)")
+	max_backoff     = nodeFlags.Duration("max-backoff", 30*time.Second, "Maximum time to back off for between retries after failed scrapes, at least -interval")
+	retries         = nodeFlags.Int("retries", 0, "Retry a failed request to the node this many times within a scrape, e.g. while a proxy in front of it answers 503, before the scrape fails")
+	retry_status    = nodeFlags.String("retry-status", "502,503,504", "Comma-separated HTTP status codes of the node retried by -retries; others fail the scrape at once")
+	attempt_timeout = nodeFlags.Duration("attempt-timeout", 0, "Timeout for each attempt of a request retried by -retries, within -scrape-timeout (0 disables)")
+	stall_timeout   = nodeFlags.Duration("stall-timeout", 0, "Exit if the synced checkpoint does not advance for this long (0 disables)")
+	follow          = waitFlags.Bool("follow", false, "Keep monitoring after the node has caught up")
+	listen_addr     = listenFlags.String("listen", "", "Serve sui-catchup's own metrics on this address, e.g. :9090")
+	push_url        = runFlags.String("pushgateway-url", "", "Push sui-catchup's own metrics to this Pushgateway on every interval")
+	push_job        = runFlags.String("pushgateway-job", "sui_catchup", "Job name to push metrics under")
+	prometheus_url  = nodeFlags.String("prometheus-url", "", "Read the watermarks from this Prometheus server's query API instead of scraping -addr")
+	query_selector  = nodeFlags.String("query-selector", "", "Label matchers picking the node's series on -prometheus-url, e.g. instance=\"node-1:9184\"")
+	replica_labels  = nodeFlags.String("query-replica-labels", "replica,prometheus_replica", "Comma-separated labels telling apart replicas of the same series on -prometheus-url, e.g. behind Thanos")
+	follow_lag      = nodeFlags.Int("follow-threshold", 10, "Lag in checkpoints beyond which a caught-up node is reported as falling behind in -follow mode")
+	parse_all       = nodeFlags.Bool("parse-all", false, "Parse every metric of the node's pages rather than picking out only those read and stopping once they have been")
+	tolerant_parse  = nodeFlags.Bool("tolerant-parsing", false, "Skip the lines of the node's metrics that fail to parse, counting them as parse warnings, rather than failing the scrape")
+	max_sync_rate   = nodeFlags.Float64("max-sync-rate", 10000, "Checkpoints per second beyond which the synced checkpoint advancing between two scrapes is reported as a jump, e.g. a snapshot restore, rather than counted as syncing (0 disables)")
 )
 
-func main() {
-	log.SetFlags(0)
+// commands are the subcommands, in the order listed by -help, with the
+// groups of flags each takes besides commonFlags.
+var commands = []struct {
+	name, usage string
+	flags       []*flag.FlagSet
+}{
+	{"wait", "Wait for the node to catch up (the default without a command)", runCommandFlags},
+	{"watch", "Keep watching the node after it has caught up, as -follow", runCommandFlags},
+	{"check", "Check the node once as a Nagios and Icinga plugin", []*flag.FlagSet{transportFlags, nodeFlags, checkFlags}},
+	{"serve", "Keep watching the node and serve readiness probes and its status on -listen", []*flag.FlagSet{transportFlags, nodeFlags, listenFlags, reportFlags, serveFlags}},
+	{"estimate", "Estimate how long a restore from -estimate-from takes", []*flag.FlagSet{transportFlags, nodeFlags, estimateFlags}},
+	{"history", "List the catch-up sessions recorded in -history-db", []*flag.FlagSet{historyFlags}},
+	{"compare", "Watch two nodes side by side", []*flag.FlagSet{transportFlags, nodeFlags, waitFlags}},
+	{"diff", "Compare the metrics of two nodes or recordings", []*flag.FlagSet{transportFlags, diffFlags}},
+	{"fleet wait", "Wait for nodes to catch up one after the other", []*flag.FlagSet{transportFlags, nodeFlags, waitFlags, fleetFlags}},
+	{"mock", "Serve the metrics of a synthetic node on -listen", []*flag.FlagSet{listenFlags, mockFlags}},
+}
 
-	flag.Parse()
+// runCommandFlags are the groups of flags of wait and watch.
+var runCommandFlags = []*flag.FlagSet{transportFlags, nodeFlags, waitFlags, listenFlags, reportFlags, runFlags, historyFlags}
 
-	if *validator_addr == "" {
-		log.Fatal("Please specify -addr")
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: sui-catchup [command] [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(out, "  %-10s  %s\n", c.name, c.usage)
 	}
+	_, _ = fmt.Fprintf(out, "\nsui-catchup <command> -help lists the flags of a command. Those of wait:\n")
+	newCommandFlags("wait", runCommandFlags).PrintDefaults()
+}
 
-	interval := time.Duration(*update_interval) * time.Second
-
-	// Start with the DefaultTransport for sane defaults.
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	// Conservatively disable HTTP keep-alives as this program will only
-	// ever need a single HTTP request.
-	transport.DisableKeepAlives = true
-	// Timeout early if the server doesn't even return the headers.
-	transport.ResponseHeaderTimeout = time.Minute
+// commandUsage returns the usage of the command with the given flags.
+func commandUsage(name, description string, fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		_, _ = fmt.Fprintf(out, "Usage: sui-catchup %s [flags] [arguments]\n\n%s.\n\nFlags:\n", name, description)
+		fs.PrintDefaults()
+	}
+}
 
-	writer := uilive.New()
+func main() {
+	// Until -log-level and -log-format are known, errors are logged bare.
+	log.SetFlags(0)
 
-	writer.Start()
+	// Flags may be given before or after the command's name, and fleet
+	// takes an action, e.g. fleet wait.
+	command, args := splitCommand(os.Args[1:])
+	if command == "fleet" {
+		var action string
+		action, args = splitCommand(args)
+		command = strings.TrimSpace(command + " " + action)
+	}
+	var fs *flag.FlagSet
+	switch command {
+	case "":
+		fs = newCommandFlags("wait", runCommandFlags)
+		fs.Usage = usage
+	case "help":
+		fs = newCommandFlags("help", nil)
+		fs.Usage = usage
+	}
+	for _, c := range commands {
+		if c.name == command {
+			fs = newCommandFlags(c.name, c.flags)
+			fs.Usage = commandUsage(c.name, c.usage, fs)
+		}
+	}
+	if fs == nil {
+		slog.Error("Unknown command, see -help for the commands", "command", command)
+		exit(exitError)
+	}
+	// Parsing the command's flags into flag.CommandLine lets the flags be
+	// looked up and set by name, e.g. from -config, as those of the
+	// command only.
+	flag.CommandLine = fs
+	_ = fs.Parse(args)
 
-	// Launch the reader that reads the state
-	go monitorChannel(writer)
+	if err := loadEnv(); err != nil {
+		slog.Error(err.Error())
+		result.fail(err.Error())
+		exit(exitError)
+	}
+	if err := loadConfig(*config_file); err != nil {
+		slog.Error(err.Error())
+		result.fail(err.Error())
+		exit(exitError)
+	}
+	if err := loadSecrets(); err != nil {
+		slog.Error(err.Error())
+		result.fail(err.Error())
+		exit(exitError)
+	}
+	if err := setupLogging(); err != nil {
+		slog.Error(err.Error())
+		result.fail(err.Error())
+		exit(exitError)
+	}
+	if *node_config != "" {
+		if err := loadNodeConfig(*node_config); err != nil {
+			slog.Error(err.Error())
+			exit(exitError)
+		}
+	}
+	if err := resolveTags(); err != nil {
+		slog.Error(err.Error())
+		exit(exitError)
+	}
 
-	// Fetch metrics every `schedule` duration
-	ticker := time.NewTicker(interval)
+	switch command {
+	case "", "wait":
+		exit(run())
+	case "watch":
+		*follow = true
+		exit(run())
+	case "help":
+		usage()
+		exit(exitCaughtUp)
+	case "history":
+		exit(runHistory())
+	case "serve":
+		exit(runServe())
+	case "mock":
+		exit(runMock())
+	case "estimate":
+		exit(runEstimate())
+	case "check":
+		exit(runCheck())
+	case "compare":
+		exit(runCompare(flag.Args()))
+	case "diff":
+		exit(runDiff(flag.Args()))
+	case "fleet wait":
+		exit(runFleetWait(flag.Args()))
+	}
+}
 
-	// Fetch state in a loop
-	_, _ = fmt.Fprintf(writer, "")
-	var errors int = 0
-	for {
-		err := fetchMetricFamilies(*validator_addr, metric_channel, transport)
+// newWatcher returns a watcher for the node with the given metrics address,
+// or the node selected by -query-selector on -prometheus-url, configured by
+// flags with the given stall timeout.
+func newWatcher(addr string, stallTimeout time.Duration) (*catchup.Watcher, error) {
+	// Requests go to the Prometheus server instead of the node, if any.
+	var fallbacks []string
+	if *prometheus_url != "" {
+		addr = *prometheus_url
+	} else if addr == *validator_addr {
+		fallbacks = splitList(*fallback_addrs)
+	}
+	for _, a := range fallbacks {
+		if !strings.HasPrefix(a, "http://") && !strings.HasPrefix(a, "https://") {
+			return nil, fmt.Errorf("invalid -fallback-addr %q, must be an http or https URL", a)
+		}
+	}
+	transport, node, addr, err := newTransport(addr, fallbacks)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for _, l := range splitList(*series_labels) {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -label %q, must be name=value", l)
+		}
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	retryStatus := []int{}
+	for _, code := range splitList(*retry_status) {
+		n, err := strconv.Atoi(code)
 		if err != nil {
-			errors++
-			str := ""
-			for i := 0; i < errors; i++ {
-				str += "."
-			}
-			_, _ = fmt.Fprintf(writer, "Error fetching metrics: %v %s\n", err, str)
-			time.Sleep(time.Millisecond * 5)
-		} else {
-			if highest_known_checkpoint.Load() != 0 {
-				if highest_known_checkpoint.Load()-highest_synced_checkpoint.Load() <= 0 {
-					break
-				}
-			}
+			return nil, fmt.Errorf("invalid -retry-status entry %q, must be an HTTP status code", code)
+		}
+		retryStatus = append(retryStatus, n)
+	}
+	pairs, pairsOnly, err := untilPairs()
+	if err != nil {
+		return nil, err
+	}
+	var tip string
+	tips := splitList(*rpc_tip_url)
+	if len(tips) > 0 {
+		tip, tips = tips[0], tips[1:]
+	}
+	var now func() time.Time
+	switch {
+	case *replay_file != "" && *record_file != "":
+		return nil, errors.New("only one of -record and -replay may be given")
+	case *record_file != "":
+		if transport, err = newRecorder(transport); err != nil {
+			return nil, err
+		}
+	case *replay_file != "":
+		r, err := loadReplay()
+		if err != nil {
+			return nil, err
+		}
+		transport, now = r, r.clock
+	}
+	src, command := *source, []string(nil)
+	if rest, ok := strings.CutPrefix(src, catchup.SourceExec+":"); ok {
+		src, command = catchup.SourceExec, strings.Fields(rest)
+	}
+	opts := catchup.Options{
+		Addr:                 addr,
+		FallbackAddrs:        fallbacks,
+		Interval:             *update_interval,
+		MaxInterval:          *max_interval,
+		KnownMetric:          *known_metric,
+		SyncedMetric:         *synced_metric,
+		ExecutedMetric:       *executed_metric,
+		RequireExecuted:      *require_exec,
+		HealthURL:            *health_url,
+		RequireHealthy:       *require_healthy,
+		VerifyURL:            *verify_rpc_url,
+		TrustedURL:           *trusted_rpc_url,
+		LagExpr:              *lag_expr,
+		Pairs:                pairs,
+		PairsOnly:            pairsOnly,
+		EpochMetric:          *epoch_metric,
+		Labels:               labels,
+		PeersMetric:          *peers_metric,
+		DBSizeMetric:         *db_size_metric,
+		PrunedMetric:         *pruned_metric,
+		PrunedObjectsMetric:  *pruned_obj,
+		ArchiveMetric:        *archive_metric,
+		Consensus:            *consensus,
+		ReceivedRoundMetric:  *received_metric,
+		CommittedRoundMetric: *commit_metric,
+		Mode:                 *mode,
+		Source:               src,
+		Command:              command,
+		Track:                *track,
+		KnownTxMetric:        *known_tx_metric,
+		ExecutedTxMetric:     *exec_tx_metric,
+		TipURL:               tip,
+		TipURLs:              tips,
+		TipQuorum:            *rpc_tip_quorum,
+		ReferenceAddr:        *reference_addr,
+		CaughtUpLag:          float64(*caught_up_lag),
+		RateAlgorithm:        *rate_algo,
+		RateWindow:           *rate_window,
+		RateSamples:          *rate_samples,
+		StallTimeout:         stallTimeout,
+		ScrapeTimeout:        *scrape_timeout,
+		MaxErrors:            *max_errors,
+		MaxBackoff:           *max_backoff,
+		Retries:              *retries,
+		RetryStatus:          retryStatus,
+		AttemptTimeout:       *attempt_timeout,
+		BehindThreshold:      float64(*follow_lag),
+		MaxSyncRate:          *max_sync_rate,
+		TolerantParsing:      *tolerant_parse,
+		ParseAll:             *parse_all,
+		Transport:            transport,
+		GRPCDialContext:      node.dial,
+		GRPCTLSConfig:        node.tlsConfig,
+		WrapGRPCTransport:    node.wrap,
+		RedialInterval:       *redial_interval,
+		Now:                  now,
+	}
+	if *prometheus_url != "" {
+		opts.Addr = ""
+		opts.PrometheusURL = addr
+		opts.QuerySelector = *query_selector
+		opts.ReplicaLabels = splitList(*replica_labels)
+	}
+	if *debug_metrics != "" {
+		dump, err := newMetricsDump(*debug_metrics, addr)
+		if err != nil {
+			return nil, err
 		}
-		<-ticker.C
+		opts.Inspect = dump
 	}
-	if highest_known_checkpoint.Load() != 0 {
-		_, _ = fmt.Fprintf(writer, "Node caught up\n")
+	return catchup.New(opts)
+}
+
+// watchAddr returns the metrics address of the node to watch: -addr, the
+// local node's if -addr was not given, or a port-forward to -kube-pod lasting
+// until ctx is done.
+func watchAddr(ctx context.Context) (string, error) {
+	if *kube_pod != "" {
+		return portForward(ctx)
 	}
-	writer.Stop()
+	return discoverLocalNode(), nil
 }
 
-// FetchMetricFamilies retrieves metrics from the provided URL, decodes them
-// into MetricFamily proto messages, and sends them to the provided channel. It
-// returns after all MetricFamilies have been sent. The provided transport
-// may be nil (in which case the default Transport is used).
-func fetchMetricFamilies(url string, ch chan<- *dto.MetricFamily, transport http.RoundTripper) error {
-	req, err := http.NewRequest("GET", url, nil)
+// runHistory prints the catch-up sessions recorded in -history-db.
+func runHistory() int {
+	if *history_db == "" {
+		slog.Error("Please specify -history-db")
+		return exitError
+	}
+	if err := printHistory(os.Stdout, *history_db); err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+	return exitCaughtUp
+}
+
+func run() int {
+	if *validator_addr == "" {
+		slog.Error("Please specify -addr")
+		return exitError
+	}
+
+	if *kube_selector != "" {
+		return runKube()
+	}
+
+	forward, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, err := watchAddr(forward)
 	if err != nil {
-		return fmt.Errorf("creating GET request for URL %q failed: %v", url, err)
+		slog.Error(err.Error())
+		return exitError
 	}
-	//req.Header.Add("Accept", acceptHeader)
-	client := http.Client{Transport: transport}
-	resp, err := client.Do(req)
+	watcher, err := newWatcher(addr, *stall_timeout)
 	if err != nil {
-		return fmt.Errorf("executing GET request for URL %q failed: %v", url, err)
+		slog.Error(err.Error())
+		return exitError
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("GET request for URL %q returned HTTP status %s", url, resp.Status)
+	if *healthcheck {
+		return runHealthcheck(watcher)
+	}
+	if *once {
+		return runOnce(watcher)
 	}
-	return parseResponse(resp, ch)
-}
 
-func monitorChannel(writer *uilive.Writer) {
-	for {
-		f := <-metric_channel
-		switch f.GetName() {
-		case "highest_known_checkpoint":
-			highest_known_checkpoint.Store(f.GetMetric()[0].GetGauge().GetValue())
-		case "highest_synced_checkpoint":
-			highest_synced_checkpoint.Store(f.GetMetric()[0].GetGauge().GetValue())
-		}
-
-		if highest_known_checkpoint.Load() != 0 && highest_synced_checkpoint.Load() != 0 {
-			delta := highest_known_checkpoint.Load() - highest_synced_checkpoint.Load()
-			rate := delta - last_delta.Load()
-			last_delta.Store(delta)
-
-			var str string
-			if rate < 0 {
-				str = fmt.Sprintf("catching up at %d/s", -int64(rate)/int64(*update_interval))
-			} else {
-				str = fmt.Sprintf("falling behind at %d/s", int64(rate)/int64(*update_interval))
-			}
-			_, _ = fmt.Fprintf(writer, "Catching up, %d checkpoints behind (%s)\n", int64(delta), str)
-			time.Sleep(time.Millisecond * 5) // Needed to allow multiple updates
+	if err := checkAlertFlags(); err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+	if err := resolveMemoryLimit(); err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+
+	notifiers, err := newNotifiers()
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+	hooks := newExecHooks()
+	if hooks != nil {
+		notifiers = append(notifiers, hooks)
+	}
+	var journal *eventsFile
+	if *events_file != "" {
+		journal, err = openEventsFile(*events_file)
+		if err != nil {
+			slog.Error(err.Error())
+			return exitError
 		}
 	}
-}
 
-func parseReader(in io.Reader, ch chan<- *dto.MetricFamily) error {
-	var parser expfmt.TextParser
-	metricFamilies, err := parser.TextToMetricFamilies(in)
+	sinks, err := openSinks(watcher)
 	if err != nil {
-		return fmt.Errorf("reading text format failed: %v", err)
+		slog.Error(err.Error())
+		return exitError
 	}
+	defer sinks.close()
 
-	ch <- metricFamilies["highest_known_checkpoint"]
-	ch <- metricFamilies["highest_synced_checkpoint"]
+	// Stop cleanly on SIGINT and SIGTERM. A second signal kills the process
+	// as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	// A replay stops at the end of the recording.
+	var replayed chan struct{}
+	if *replay_file != "" {
+		r, _ := loadReplay()
+		replayed = r.done
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-replayed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
 
-	return nil
-}
+	var out *output
+	var dashboard *tui
+	if *tui_mode && *quiet {
+		slog.Error("-tui and -quiet cannot be used together")
+		return exitError
+	}
+	if *tui_mode {
+		dashboard, err = newTUI(*validator_addr, stop)
+		if err != nil {
+			slog.Error(err.Error())
+			return exitError
+		}
+		out = dashboard.output()
+	} else {
+		out = newOutput()
+	}
+	view := display{color: out.interactive && useColor()}
+	if out.interactive && *sparkline_size > 0 {
+		view.rates = newSparkline(*sparkline_size)
+	}
+	if out.interactive && *chart_span > 0 {
+		view.lags = newBrailleChart(*chart_span, *chart_width, *chart_height)
+	}
+
+	if *max_wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *max_wait)
+		defer cancel()
+	}
 
-func parseResponse(resp *http.Response, ch chan<- *dto.MetricFamily) error {
-	if err := parseReader(resp.Body, ch); err != nil {
-		return err
+	done := make(chan error, 1)
+	go func() {
+		if *follow {
+			done <- watcher.Follow(ctx)
+		} else {
+			done <- watcher.Wait(ctx)
+		}
+	}()
+
+	notifications := &dispatcher{notifiers: notifiers, events: journal}
+	summary := newSession()
+	if *show_elapsed {
+		view.since = summary.start
+	}
+	milestones.Info("Watching node", "node", nodeName())
+	var last catchup.Progress
+	var started, caught_up, slow_scrapes, too_old, losing, memory_high bool
+	var crashes crashLoop
+	var alerts alerter
+	var stall_hook stallHook
+	// On a terminal the status is redrawn between scrapes, counting down the
+	// ETA, however long -interval is.
+	var redraw <-chan time.Time
+	if out.interactive && dashboard == nil && *refresh > 0 {
+		ticker := time.NewTicker(*refresh)
+		defer ticker.Stop()
+		redraw = ticker.C
+	}
+	progress := watcher.Events()
+scrapes:
+	for {
+		var p catchup.Progress
+		select {
+		case <-redraw:
+			view.redraw(out.status)
+			continue
+		case next, ok := <-progress:
+			if !ok {
+				break scrapes
+			}
+			p = next
+		}
+		summary.observe(p)
+		result.observe(p)
+		sinks.update(p)
+		// Warn once per episode of scrapes taking most of the interval, which
+		// makes them overrun it and skip ticks.
+		if slow := p.ScrapeDuration >= *update_interval*8/10; slow && !slow_scrapes {
+			slog.Warn("Scraping took most of the interval, consider a longer -interval", "took", formatLatency(p.ScrapeDuration), "interval", *update_interval)
+			slow_scrapes = true
+		} else if !slow {
+			slow_scrapes = false
+		}
+		if p.Endpoint != last.Endpoint && last.Endpoint != "" {
+			if onFallback(p) {
+				slog.Warn("Failed over to another endpoint", "endpoint", p.Endpoint, "from", last.Endpoint)
+			} else {
+				slog.Info("Back on the primary endpoint", "endpoint", p.Endpoint)
+			}
+		}
+		if p.RemoteAddr != last.RemoteAddr && p.RemoteAddr != "" && p.Err == nil {
+			slog.Debug("Node answering from", "addr", p.RemoteAddr)
+			if last.RemoteAddr != "" {
+				slog.Info("Node answering from another address", "addr", p.RemoteAddr, "was", last.RemoteAddr)
+			}
+		}
+		if id := formatIdentity(p.Identity); id != "" && p.Identity != last.Identity {
+			_, _ = fmt.Fprintf(out.log, "Watching %s\n", id)
+		}
+		if p.Version != last.Version && p.Version != "" {
+			if last.Version == "" {
+				_, _ = fmt.Fprintf(out.log, "Node version %s\n", p.Version)
+			} else {
+				_, _ = fmt.Fprintf(out.log, "Node version changed from %s to %s\n", last.Version, p.Version)
+			}
+		}
+		if p.Err != nil {
+			slog.Warn("Fetching metrics failed", "err", p.Err, "attempt", p.Errors)
+		} else {
+			if last.SyncedMetric == "" && p.SyncedMetric != "" && (*known_metric == "" || *synced_metric == "") {
+				printMetricNames(out.log, p)
+			}
+			if !started && !p.CaughtUp {
+				notifications.send(newEvent(eventStarted, p, summary.start))
+			}
+			started = true
+			// A node too old for the network looks healthy until it
+			// stalls, so this is not left to the status line alone.
+			if old := protocolTooOld(p); old && !too_old {
+				slog.Warn("Node too old for the network's protocol version, upgrade it before it stalls",
+					"supported", int64(p.Protocol), "network", int64(p.NetworkProtocol))
+				notifications.send(newEvent(eventProtocolTooOld, p, summary.start))
+				too_old = true
+			} else if !old {
+				too_old = false
+			}
+			if never := neverCatchesUp(p); never && !losing {
+				slog.Warn("The network produces checkpoints faster than the node syncs them, so it will never catch up at this speed",
+					"sync_rate", math.Round(p.SyncRate), "network_rate", math.Round(p.NetworkRate), "closure_rate", math.Round(p.ClosureRate))
+				losing = true
+			} else if !never && p.ClosureRate >= 0 {
+				losing = false
+			}
+			// An OOM kill restarts the node without a trace in its
+			// metrics, so the approach to the limit is logged.
+			if memoryPressure(p) && !memory_high {
+				slog.Warn("The node is close to its memory limit and risks being OOM-killed",
+					"resident", formatBytes(p.Process.RSS), "limit", formatBytes(memoryLimit))
+				memory_high = true
+			} else if memoryRelieved(p) {
+				memory_high = false
+			}
+			if p.ParseWarnings > 0 && last.ParseWarnings == 0 {
+				slog.Warn("Skipped the lines of the node's metrics that failed to parse", "lines", p.ParseWarnings)
+			}
+			if p.Restarted {
+				slog.Warn("The node restarted", "uptime", p.Uptime.Round(time.Second), "restarts", p.Restarts)
+			}
+			if crashes.update(p) {
+				slog.Warn("The node keeps restarting and may be crash-looping", "restarts", crashes.recent(), "within", *crash_loop_window)
+				ev := newEvent(eventCrashLooping, p, summary.start)
+				ev.Reason = fmt.Sprintf("%d restarts within %s", crashes.recent(), formatETA(*crash_loop_window))
+				notifications.send(ev)
+			}
+			// The rates start over rather than going negative, so this
+			// would otherwise go unnoticed.
+			if p.Regressed {
+				slog.Warn("The node's synced checkpoint went back, it may have restarted from an older snapshot or lost its database",
+					"from", int64(p.PreviousSynced), "to", int64(p.Synced))
+				notifications.send(newEvent(eventCheckpointRegressed, p, summary.start))
+			}
+			if p.Jumped {
+				slog.Warn("The node's synced checkpoint jumped ahead faster than it can sync, it may have restored a snapshot",
+					"from", int64(p.PreviousSynced), "to", int64(p.Synced))
+				notifications.send(newEvent(eventCheckpointJumped, p, summary.start))
+			}
+			if p.EpochChanged {
+				_, _ = fmt.Fprintf(out.log, "Entered epoch %d%s\n", int64(p.Epoch), formatEpochDuration(p))
+				notifications.send(newEvent(eventEpochChanged, p, summary.start))
+			}
+			if p.CaughtUp && !caught_up {
+				if *follow {
+					_, _ = fmt.Fprintf(out.log, "Node caught up\n")
+				}
+				notifications.send(newEvent(eventCaughtUp, p, summary.start))
+			}
+			if p.FellBehind && !last.FellBehind {
+				_, _ = fmt.Fprintf(out.log, "Node fell behind, %d checkpoints behind\n", int64(p.Lag))
+				notifications.send(newEvent(eventFellBehind, p, summary.start))
+			}
+			if !p.FellBehind && last.FellBehind {
+				_, _ = fmt.Fprintf(out.log, "Node back in sync, %d checkpoints behind\n", int64(p.Lag))
+				notifications.send(newEvent(eventRecovered, p, summary.start))
+			}
+			caught_up = caught_up || p.CaughtUp
+		}
+		if kind, reason := alerts.update(p); kind != "" {
+			ev := newEvent(kind, p, summary.start)
+			ev.Reason = reason
+			_, _ = fmt.Fprintf(out.log, "%s\n", ev)
+			notifications.send(ev)
+		}
+		stall_hook.update(ctx, p)
+		if hooks != nil {
+			hooks.progress(ctx, p, time.Since(summary.start))
+		}
+		in_sync := *follow && caught_up && !p.FellBehind
+		if dashboard != nil {
+			dashboard.update(p, in_sync)
+		}
+		view.printProgress(out.status, p, in_sync)
+		last = p
+	}
+	code := exitCaughtUp
+	err = <-done
+	if err != nil {
+		var stall *catchup.StallError
+		var scrape *catchup.ScrapeError
+		code = exitError
+		if errors.As(err, &stall) {
+			code = exitStalled
+			notifications.send(newEvent(eventStalled, last, summary.start))
+		} else if errors.As(err, &scrape) {
+			code = exitScrapeFailed
+		} else if err == context.Canceled && isClosed(replayed) {
+			code = exitEndOfReplay
+			err = errEndOfRecording
+		} else if err == context.Canceled {
+			code = exitInterrupted
+			err = errors.New("interrupted")
+		} else if err == context.DeadlineExceeded && *follow && caught_up && !last.FellBehind {
+			code = exitCaughtUp
+			err = nil
+		} else if err == context.DeadlineExceeded {
+			code = exitTimeout
+			if last.Err != nil {
+				code = exitScrapeFailed
+			}
+			err = fmt.Errorf("node did not catch up within %s", *max_wait)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(out.status, "%s\n", redact(err.Error()))
+			result.fail(err.Error())
+		}
+	}
+	notifications.wait()
+	if notifications.events != nil {
+		if err := notifications.events.end(code, summary.last); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+	out.stop()
+	milestones.Info("Stopped watching node", "node", nodeName(), "status", exitStatus(code), "elapsed", formatETA(time.Since(summary.start)),
+		"synced", int64(summary.last.Synced), "lag", int64(summary.last.Lag), "failed_scrapes", summary.errors)
+	if *quiet {
+		summary.printOutcome(os.Stdout, err)
+	} else if *summary_json != "-" {
+		summary.print(os.Stdout)
+	}
+	if *summary_json != "" {
+		if err := summary.writeJSON(*summary_json, code); err != nil {
+			slog.Error(err.Error())
+		}
 	}
-	return nil
+	return code
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	memory_limit = runFlags.String("memory-limit", "auto", "Memory limit of the node to warn about approaching, e.g. 64GiB; auto reads the cgroup limit, or else the host's memory, of a sui-node process on this host, off disables the warning")
+	memory_warn  = runFlags.Float64("memory-warn", 0.9, "Fraction of -memory-limit at which the node's resident memory is warned about")
+)
+
+// memoryLimit is the node's memory limit in bytes as resolved from
+// -memory-limit, zero if not known.
+var memoryLimit float64
+
+// cgroupUnlimited is the least value cgroup v1 reports for no memory limit,
+// a page-aligned maximum signed integer.
+const cgroupUnlimited = 1 << 62
+
+// resolveMemoryLimit sets memoryLimit from -memory-limit.
+func resolveMemoryLimit() error {
+	if *memory_warn <= 0 || *memory_warn > 1 {
+		return fmt.Errorf("invalid -memory-warn %v, must be above 0 and at most 1", *memory_warn)
+	}
+	switch *memory_limit {
+	case "off", "":
+		return nil
+	case "auto":
+		limit, source := detectMemoryLimit()
+		if limit > 0 {
+			slog.Info("Detected the node's memory limit", "limit", formatBytes(limit), "source", source)
+		}
+		memoryLimit = limit
+		return nil
+	}
+	limit, err := parseByteSize(*memory_limit)
+	if err != nil || limit <= 0 {
+		return fmt.Errorf("invalid -memory-limit %q, must be a size such as 64GiB, auto or off", *memory_limit)
+	}
+	memoryLimit = limit
+	return nil
+}
+
+// parseByteSize parses a number of bytes with an optional decimal or binary
+// unit, e.g. 64G, 64GB or 64GiB.
+func parseByteSize(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := strings.ToUpper(strings.TrimSpace(s[i:]))
+	base := 1000.0
+	if strings.HasSuffix(unit, "IB") {
+		base, unit = 1024, strings.TrimSuffix(unit, "IB")
+	} else {
+		unit = strings.TrimSuffix(unit, "B")
+	}
+	if unit == "" {
+		return n, nil
+	}
+	exp := strings.Index("KMGTPE", unit)
+	if exp < 0 || len(unit) != 1 {
+		return 0, fmt.Errorf("unknown unit %q", s[i:])
+	}
+	return n * math.Pow(base, float64(exp+1)), nil
+}
+
+// detectMemoryLimit returns the memory limit of the first sui-node process on
+// this host and where it was read from: the limit of its cgroup, or the
+// host's memory if the cgroup has none. It returns 0 if there is no such
+// process.
+func detectMemoryLimit() (float64, string) {
+	dirs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range dirs {
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil || strings.TrimSpace(string(comm)) != "sui-node" {
+			continue
+		}
+		for _, path := range cgroupLimitFiles(filepath.Join(dir, "cgroup")) {
+			if limit, ok := readCgroupLimit(path); ok {
+				return limit, path
+			}
+		}
+		if total := hostMemory(); total > 0 {
+			return total, "/proc/meminfo"
+		}
+		return 0, ""
+	}
+	return 0, ""
+}
+
+// cgroupLimitFiles returns the files holding the memory limit of the cgroups
+// listed in the /proc/<pid>/cgroup file at path, cgroup v2 first.
+func cgroupLimitFiles(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are hierarchy-ID:controllers:path, the controllers empty
+		// for cgroup v2.
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[1] == "":
+			files = append([]string{filepath.Join("/sys/fs/cgroup", fields[2], "memory.max")}, files...)
+		case strings.Contains(","+fields[1]+",", ",memory,"):
+			files = append(files, filepath.Join("/sys/fs/cgroup/memory", fields[2], "memory.limit_in_bytes"))
+		}
+	}
+	return files
+}
+
+// readCgroupLimit reads a cgroup memory limit file, reporting false if it
+// cannot be read or sets no limit.
+func readCgroupLimit(path string) (float64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, false
+	}
+	limit, err := strconv.ParseFloat(s, 64)
+	if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+		return 0, false
+	}
+	return limit, true
+}
+
+// hostMemory returns the host's total memory in bytes from /proc/meminfo, or 0
+// if it cannot be read.
+func hostMemory() float64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       65787628 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			kb, _ := strconv.ParseFloat(fields[1], 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// memoryPressure reports whether the node's resident memory is at least
+// -memory-warn of its limit, which risks an OOM kill that silently restarts
+// the catch-up.
+func memoryPressure(p catchup.Progress) bool {
+	return p.Err == nil && p.Process != nil && memoryLimit > 0 && p.Process.RSS >= *memory_warn*memoryLimit
+}
+
+// memoryRelieved reports whether the node's resident memory has dropped
+// clearly below the warning threshold again, so that memory hovering around
+// it is warned about only once.
+func memoryRelieved(p catchup.Progress) bool {
+	return p.Err == nil && (p.Process == nil || memoryLimit <= 0 || p.Process.RSS < (*memory_warn-0.05)*memoryLimit)
+}
+
+// formatMemoryPressure describes how close the node is to its memory limit,
+// e.g. "The node uses 93% of its 64.0 GB memory limit ...".
+func formatMemoryPressure(p catchup.Progress) string {
+	return fmt.Sprintf("The node uses %d%% of its %s memory limit and risks being killed for running out of memory",
+		int64(100*p.Process.RSS/memoryLimit), formatBytes(memoryLimit))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// exporter publishes the derived catch-up state as Prometheus metrics.
+type exporter struct {
+	registry     *prometheus.Registry
+	lag          prometheus.Gauge
+	execLag      prometheus.Gauge
+	rate         prometheus.Gauge
+	eta          prometheus.Gauge
+	scrapeErrors prometheus.Counter
+	caughtUp     prometheus.Gauge
+	scrapeTime   prometheus.Gauge
+	peers        prometheus.Gauge
+	roundLag     prometheus.Gauge
+	dbGrowth     prometheus.Gauge
+	fromArchive  prometheus.Gauge
+	parseWarns   prometheus.Counter
+}
+
+func newExporter() *exporter {
+	e := &exporter{
+		registry: prometheus.NewRegistry(),
+		lag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "checkpoint_lag",
+			Help:      "Number of checkpoints the node is behind its highest known checkpoint.",
+		}),
+		execLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "execution_lag",
+			Help:      "Number of checkpoints the node's executed checkpoint is behind its highest known checkpoint.",
+		}),
+		rate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "catchup_rate",
+			Help:      "Smoothed rate in checkpoints per second at which the lag is shrinking.",
+		}),
+		eta: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "eta_seconds",
+			Help:      "Estimated seconds until the node has caught up, 0 if it is not catching up.",
+		}),
+		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sui_catchup",
+			Name:      "scrape_errors_total",
+			Help:      "Number of failed scrapes of the node's metrics endpoint.",
+		}),
+		caughtUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "caught_up",
+			Help:      "Whether the node has caught up (1) or not (0).",
+		}),
+		scrapeTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of the last scrape of the node's metrics endpoint.",
+		}),
+		peers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "peers",
+			Help:      "Number of peers the node is connected to, if it exposes them.",
+		}),
+		roundLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "consensus_round_lag",
+			Help:      "Number of consensus rounds received but not committed yet, with -consensus.",
+		}),
+		dbGrowth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "db_growth_bytes_per_second",
+			Help:      "Smoothed rate at which the node's database grows, if it exposes its size.",
+		}),
+		fromArchive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sui_catchup",
+			Name:      "syncing_from_archive",
+			Help:      "Whether the node fetched checkpoints from the archive fallback since the previous scrape (1) or not (0).",
+		}),
+		parseWarns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sui_catchup",
+			Name:      "parse_warnings_total",
+			Help:      "Number of lines of the node's metrics skipped with -tolerant-parsing because they failed to parse.",
+		}),
+	}
+	// The tags label every series, as they do the other outputs.
+	labels := prometheus.Labels{}
+	for _, t := range tags {
+		labels[t.name] = t.value
+	}
+	prometheus.WrapRegistererWith(labels, e.registry).MustRegister(e.lag, e.execLag, e.rate, e.eta, e.scrapeErrors, e.caughtUp, e.scrapeTime, e.peers, e.roundLag, e.dbGrowth, e.fromArchive, e.parseWarns)
+	return e
+}
+
+func (e *exporter) update(p catchup.Progress) {
+	e.scrapeTime.Set(p.ScrapeDuration.Seconds())
+	if p.Err != nil {
+		e.scrapeErrors.Inc()
+		return
+	}
+	e.lag.Set(p.Lag)
+	e.execLag.Set(p.ExecutionLag)
+	e.rate.Set(p.Rate)
+	e.eta.Set(p.ETA.Seconds())
+	e.peers.Set(p.Peers)
+	e.dbGrowth.Set(p.DBGrowth)
+	e.parseWarns.Add(float64(p.ParseWarnings))
+	if p.FromArchive {
+		e.fromArchive.Set(1)
+	} else {
+		e.fromArchive.Set(0)
+	}
+	if p.Consensus != nil {
+		e.roundLag.Set(p.Consensus.Lag)
+	}
+	if p.CaughtUp {
+		e.caughtUp.Set(1)
+	} else {
+		e.caughtUp.Set(0)
+	}
+}
+
+// serve exposes the metrics, along with state and its events and the
+// dashboard, on addr in the background until the returned server is closed.
+func (e *exporter) serve(addr string, state *health, events *broadcaster) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: statusMux(e, state, events)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Serving metrics failed", "addr", addr, "err", err)
+		}
+	}()
+	return srv
+}
+
+// pusher pushes the exporter's metrics to a Prometheus Pushgateway.
+type pusher struct {
+	pusher  *push.Pusher
+	lastErr string
+}
+
+func newPusher(url, job string, e *exporter) *pusher {
+	return &pusher{pusher: push.New(url, job).Gatherer(e.registry)}
+}
+
+// push replaces the pushed metrics with the current values. It returns an
+// error only when it differs from the previous push's, so that a persistently
+// unreachable gateway is reported once rather than on every interval.
+func (p *pusher) push() error {
+	err := p.pusher.Push()
+	if err == nil {
+		p.lastErr = ""
+		return nil
+	}
+	if err.Error() == p.lastErr {
+		return nil
+	}
+	p.lastErr = err.Error()
+	return err
+}
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *listen_addr == "" && *push_url == "" {
+			return nil, nil
+		}
+		return newOwnMetrics(), nil
+	})
+}
+
+// ownMetrics serves sui-catchup's own metrics, with the node's status and
+// events, on -listen, and pushes them to -pushgateway-url.
+type ownMetrics struct {
+	exporter *exporter
+	state    *health
+	events   *broadcaster
+	srv      *http.Server
+	pusher   *pusher
+}
+
+func newOwnMetrics() *ownMetrics {
+	m := &ownMetrics{exporter: newExporter()}
+	if *listen_addr != "" {
+		m.state, m.events = &health{}, newBroadcaster()
+		m.srv = m.exporter.serve(*listen_addr, m.state, m.events)
+	}
+	if *push_url != "" {
+		m.pusher = newPusher(*push_url, *push_job, m.exporter)
+	}
+	return m
+}
+
+func (m *ownMetrics) update(p catchup.Progress) error {
+	m.exporter.update(p)
+	if m.state != nil {
+		m.state.set(p)
+		m.events.publish("progress", m.state.status())
+	}
+	if m.pusher != nil {
+		if err := m.pusher.push(); err != nil {
+			return fmt.Errorf("pushing metrics failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (m *ownMetrics) Close() error {
+	if m.srv != nil {
+		return m.srv.Close()
+	}
+	return nil
+}
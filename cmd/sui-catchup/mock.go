@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rpcpool/sui-catchup/pkg/mockmetrics"
+)
+
+var (
+	mock_known     = mockFlags.Int("mock-known", 100000, "Highest known checkpoint the mock command starts at")
+	mock_synced    = mockFlags.Int("mock-synced", 90000, "Highest synced checkpoint the mock command starts at")
+	mock_tip_rate  = mockFlags.Float64("mock-tip-rate", 4, "Checkpoints per second by which the mock command's highest known checkpoint advances")
+	mock_sync_rate = mockFlags.Float64("mock-sync-rate", 50, "Checkpoints per second by which the mock command's highest synced checkpoint advances, up to the known one")
+	mock_peers     = mockFlags.Int("mock-peers", 8, "Number of peers the mock command reports")
+	mock_version   = mockFlags.String("mock-version", "1.36.2-mock", "Version the mock command reports")
+	mock_validator = mockFlags.String("mock-validator", "", "Validator name the mock command labels its metrics with")
+	mock_protocol  = mockFlags.Int("mock-protocol", 70, "Highest protocol version the mock command supports, and its current one")
+)
+
+// defaultMockAddr is where the mock command listens without -listen, the
+// port sui-node serves its metrics on.
+const defaultMockAddr = ":9184"
+
+// runMock implements the mock command, which serves a synthetic node's
+// /metrics on -listen, to demo sui-catchup or test it end to end without a
+// real node.
+func runMock() int {
+	addr := *listen_addr
+	if addr == "" {
+		addr = defaultMockAddr
+	}
+	node := mockmetrics.New(mockmetrics.Config{
+		Known:     float64(*mock_known),
+		Synced:    float64(*mock_synced),
+		TipRate:   *mock_tip_rate,
+		SyncRate:  *mock_sync_rate,
+		Peers:     *mock_peers,
+		Protocol:  *mock_protocol,
+		Version:   *mock_version,
+		Validator: *mock_validator,
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", node)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	_, _ = fmt.Fprintf(&timestampWriter{w: os.Stdout}, "Serving a mock node's metrics on %s/metrics\n", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		slog.Error("Serving failed", "addr", addr, "err", err)
+		return exitError
+	}
+	return exitInterrupted
+}
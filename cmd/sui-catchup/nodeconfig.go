@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var node_config = nodeFlags.String("node-config", "", "sui-node's fullnode.yaml, to take -addr from its metrics-address and -health-url from its json-rpc-address")
+
+// metricsPorts are the ports sui-node deployments commonly serve metrics on,
+// probed when the node config does not say.
+var metricsPorts = []string{"9184", "9187"}
+
+// probeTimeout bounds each request probing for a metrics endpoint.
+const probeTimeout = 2 * time.Second
+
+// loadNodeConfig sets -addr, and -health-url to the JSON-RPC port, from the
+// sui-node config at path, unless they were given. A config without a
+// metrics-address leaves sui-node's default, which is probed for along with
+// the other common ports.
+func loadNodeConfig(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading -node-config failed: %v", err)
+	}
+	var config struct {
+		MetricsAddress string `yaml:"metrics-address"`
+		JSONRPCAddress string `yaml:"json-rpc-address"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("parsing -node-config %q failed: %v", path, err)
+	}
+
+	if f := flag.Lookup("addr"); f.Value.String() == f.DefValue {
+		var addr string
+		if config.MetricsAddress != "" {
+			host, port, err := net.SplitHostPort(config.MetricsAddress)
+			if err != nil {
+				return fmt.Errorf("invalid metrics-address %q in -node-config %q: %v", config.MetricsAddress, path, err)
+			}
+			addr = metricsURL(reachableHost(host), port)
+		} else if addr, err = probeMetricsPorts("localhost", metricsPorts); err != nil {
+			return fmt.Errorf("-node-config %q has no metrics-address: %v", path, err)
+		}
+		if err := flag.Set("addr", addr); err != nil {
+			return err
+		}
+	}
+	if *health_url == "" && config.JSONRPCAddress != "" {
+		host, port, err := net.SplitHostPort(config.JSONRPCAddress)
+		if err != nil {
+			return fmt.Errorf("invalid json-rpc-address %q in -node-config %q: %v", config.JSONRPCAddress, path, err)
+		}
+		if err := flag.Set("health-url", "tcp://"+net.JoinHostPort(reachableHost(host), port)); err != nil {
+			return err
+		}
+	}
+	slog.Info("Read the node config", "path", path, "addr", *validator_addr, "health_url", *health_url)
+	return nil
+}
+
+// reachableHost returns the host to connect to for an address the node
+// listens on, which is usually all interfaces.
+func reachableHost(host string) string {
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return "localhost"
+	}
+	return host
+}
+
+func metricsURL(host, port string) string {
+	return "http://" + net.JoinHostPort(host, port) + "/metrics"
+}
+
+// probeMetricsPorts returns the URL of the first of ports on host that
+// serves metrics.
+func probeMetricsPorts(host string, ports []string) (string, error) {
+	client := http.Client{Timeout: probeTimeout}
+	for _, port := range ports {
+		u := metricsURL(host, port)
+		req, err := http.NewRequestWithContext(context.Background(), "GET", u, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("no metrics found on %s ports %v", host, ports)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var node_name = reportFlags.String("node-name", "", "Name of the node in notifications (default: the -addr host)")
+
+// Lifecycle events sent to notifiers.
+const (
+	eventStarted    = "started"
+	eventCaughtUp   = "caught_up"
+	eventFellBehind = "fell_behind"
+	eventRecovered  = "recovered"
+	eventStalled    = "stalled"
+
+	// eventProtocolTooOld is sent when the node's binary does not support
+	// the network's protocol version, before it stalls because of it.
+	eventProtocolTooOld = "protocol_too_old"
+
+	// eventEpochChanged is sent when the node syncs into a later epoch.
+	eventEpochChanged = "epoch_changed"
+
+	// eventCheckpointRegressed is sent when the node's synced checkpoint
+	// goes back, e.g. after a restart from an older snapshot, and
+	// eventCheckpointJumped when it advances faster than -max-sync-rate.
+	eventCheckpointRegressed = "checkpoint_regressed"
+	eventCheckpointJumped    = "checkpoint_jumped"
+
+	// eventCrashLooping is sent when the node restarted -crash-loop-restarts
+	// times within -crash-loop-window.
+	eventCrashLooping = "crash_looping"
+
+	eventAlert         = "alert"
+	eventAlertResolved = "alert_resolved"
+)
+
+// notifyTimeout bounds the delivery of a single notification.
+const notifyTimeout = 30 * time.Second
+
+// event is a lifecycle event of the watched node.
+type event struct {
+	Kind     string
+	Time     time.Time // when it happened
+	Node     string
+	Tags     map[string]string // of -tag and the like, nil without any
+	Lag      int64
+	Elapsed  time.Duration // since sui-catchup started
+	Progress catchup.Progress
+	// Reason is the threshold crossed, for alerts.
+	Reason string
+}
+
+func newEvent(kind string, p catchup.Progress, start time.Time) event {
+	at := p.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return event{
+		Kind:     kind,
+		Time:     at,
+		Node:     nodeName(),
+		Tags:     tagMap(),
+		Lag:      int64(p.Lag),
+		Elapsed:  time.Since(start),
+		Progress: p,
+	}
+}
+
+// String describes the event for chat messages, e.g.
+// "node-1 caught up after 2h11m, 0 checkpoints behind".
+func (e event) String() string {
+	switch e.Kind {
+	case eventStarted:
+		return fmt.Sprintf("%s started catching up, %d checkpoints behind", e.Node, e.Lag)
+	case eventCaughtUp:
+		return fmt.Sprintf("%s caught up after %s, %d checkpoints behind", e.Node, formatETA(e.Elapsed), e.Lag)
+	case eventFellBehind:
+		return fmt.Sprintf("%s fell behind, %d checkpoints behind after %s", e.Node, e.Lag, formatETA(e.Elapsed))
+	case eventRecovered:
+		return fmt.Sprintf("%s is back in sync, %d checkpoints behind", e.Node, e.Lag)
+	case eventProtocolTooOld:
+		return fmt.Sprintf("%s supports protocol versions up to %d but the network is at %d, upgrade it before it stalls",
+			e.Node, int64(e.Progress.Protocol), int64(e.Progress.NetworkProtocol))
+	case eventEpochChanged:
+		return fmt.Sprintf("%s entered epoch %d%s, %d checkpoints behind", e.Node, int64(e.Progress.Epoch), formatEpochDuration(e.Progress), e.Lag)
+	case eventCheckpointRegressed:
+		return fmt.Sprintf("%s went back from checkpoint %d to %d, %d checkpoints behind",
+			e.Node, int64(e.Progress.PreviousSynced), int64(e.Progress.Synced), e.Lag)
+	case eventCheckpointJumped:
+		return fmt.Sprintf("%s jumped from checkpoint %d to %d, %d checkpoints behind",
+			e.Node, int64(e.Progress.PreviousSynced), int64(e.Progress.Synced), e.Lag)
+	case eventCrashLooping:
+		return fmt.Sprintf("%s may be crash-looping, %s, %d checkpoints behind", e.Node, e.Reason, e.Lag)
+	case eventAlert:
+		return fmt.Sprintf("%s alert: %s", e.Node, e.Reason)
+	case eventAlertResolved:
+		return fmt.Sprintf("%s alert resolved, %d checkpoints behind", e.Node, e.Lag)
+	case eventStalled:
+		if e.Progress.AtEpochEnd {
+			return fmt.Sprintf("%s stalled at checkpoint %d, the last of epoch %d, for %s, %d checkpoints behind after %s",
+				e.Node, int64(e.Progress.Synced), int64(e.Progress.Epoch), formatETA(e.Progress.StalledFor), e.Lag, formatETA(e.Elapsed))
+		}
+		return fmt.Sprintf("%s stalled at checkpoint %d for %s, %d checkpoints behind after %s",
+			e.Node, int64(e.Progress.Synced), formatETA(e.Progress.StalledFor), e.Lag, formatETA(e.Elapsed))
+	default:
+		return fmt.Sprintf("%s: %s", e.Node, e.Kind)
+	}
+}
+
+// nodeName returns -node-name, or -kube-pod or the -addr host or socket path
+// if unset.
+func nodeName() string {
+	if *node_name != "" {
+		return *node_name
+	}
+	if *kube_pod != "" {
+		return *kube_pod
+	}
+	u, err := url.Parse(*validator_addr)
+	if err != nil {
+		return *validator_addr
+	}
+	if u.Host == "" {
+		return u.Path
+	}
+	return u.Host
+}
+
+// notifier delivers events to an external service.
+type notifier interface {
+	notify(ctx context.Context, ev event) error
+}
+
+// timelineNotifier is a notifier recording a timeline, which is also sent
+// eventStarted. Chat and paging services would only be noisier for it.
+type timelineNotifier interface {
+	notifier
+	timeline()
+}
+
+// newNotifiers returns the notifiers configured by flags.
+func newNotifiers() ([]notifier, error) {
+	var ns []notifier
+	if *slack_webhook != "" {
+		ns = append(ns, slackNotifier{url: *slack_webhook})
+	}
+	if *webhook_url != "" {
+		n, err := newWebhookNotifier()
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	if *pagerduty_key != "" {
+		n, err := newPagerDutyNotifier()
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	if *alertmanager_url != "" {
+		n, err := newAlertmanagerNotifier()
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	if *telegram_token != "" || *telegram_chat != "" {
+		if *telegram_token == "" || *telegram_chat == "" {
+			return nil, fmt.Errorf("both -telegram-bot-token and -telegram-chat-id must be specified")
+		}
+		ns = append(ns, telegramNotifier{token: *telegram_token, chat: *telegram_chat})
+	}
+	if *discord_token != "" || *discord_channel != "" {
+		if *discord_token == "" || *discord_channel == "" {
+			return nil, fmt.Errorf("both -discord-bot-token and -discord-channel-id must be specified")
+		}
+		ns = append(ns, discordNotifier{token: *discord_token, channel: *discord_channel})
+	}
+	if *grafana_url != "" {
+		ns = append(ns, newGrafanaNotifier())
+	}
+	if *bell {
+		if n, ok := newBellNotifier(); ok {
+			ns = append(ns, n)
+		} else {
+			slog.Warn("Not ringing the bell for -bell, as neither standard output nor error is a terminal")
+		}
+	}
+	if *notify_desktop {
+		n, err := newDesktopNotifier()
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// dispatcher sends events to notifiers in the background, so that a slow
+// service does not hold up scraping.
+type dispatcher struct {
+	notifiers []notifier
+	// events, if not nil, records the events in the order they are sent.
+	events *eventsFile
+	wg     sync.WaitGroup
+}
+
+func (d *dispatcher) send(ev event) {
+	milestones.Info(ev.String(), "event", ev.Kind, "synced", int64(ev.Progress.Synced), "lag", ev.Lag)
+	if d.events != nil {
+		if err := d.events.record(ev); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+	for _, n := range d.notifiers {
+		if _, ok := n.(timelineNotifier); ev.Kind == eventStarted && !ok {
+			continue
+		}
+		d.wg.Add(1)
+		go func(n notifier) {
+			defer d.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := n.notify(ctx, ev); err != nil {
+				slog.Warn("Sending notification failed", "err", err)
+			}
+		}(n)
+	}
+}
+
+// wait waits for the notifications sent so far to be delivered.
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
+
+// postJSON posts v as JSON to url, failing unless the response is a 2xx.
+// header, if not nil, is added to the request.
+func postJSON(ctx context.Context, url string, header http.Header, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(ctx, url, header, "application/json", b)
+}
+
+// post posts body to url, failing unless the response is a 2xx.
+func post(ctx context.Context, url string, header http.Header, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating POST request failed: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST request returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
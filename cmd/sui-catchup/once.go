@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	once        = runFlags.Bool("once", false, "Scrape once, print the lag and exit 0 if the node has caught up, 5 if it is behind or 3 if scraping failed")
+	once_sample = runFlags.Duration("once-sample", 0, "With -once, scrape a second time after this long to print the rate and ETA too")
+)
+
+// runOnce implements -once, a snapshot of the node's state for ad-hoc checks
+// and cron jobs.
+func runOnce(watcher *catchup.Watcher) int {
+	ctx := context.Background()
+	p, err := watcher.Check(ctx)
+	if err == nil && *once_sample > 0 && !p.CaughtUp {
+		time.Sleep(*once_sample)
+		p, err = watcher.Check(ctx)
+	}
+	if err != nil {
+		slog.Error("Fetching metrics failed", "err", err)
+		result.fail(err.Error())
+		return exitScrapeFailed
+	}
+	result.observe(p)
+	if p.CaughtUp {
+		fmt.Printf("%s caught up, %d checkpoints behind\n", nodeName(), int64(p.Lag))
+		return exitCaughtUp
+	}
+	var rate string
+	if *once_sample > 0 {
+		rate = fmt.Sprintf(" (%s)", formatRate(p.Rate, 0)+formatCompletion(p.ETA))
+	}
+	fmt.Printf("%s %d checkpoints behind%s\n", nodeName(), int64(p.Lag), rate)
+	return exitBehind
+}
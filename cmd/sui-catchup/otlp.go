@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	otlp_endpoint   = runFlags.String("otlp-endpoint", "", "Export the lag, rate and scrape errors as OpenTelemetry metrics to this OTLP/HTTP endpoint on every interval, e.g. http://collector:4318")
+	otlp_headers    = runFlags.String("otlp-headers", "", "Comma-separated name=value headers sent to -otlp-endpoint, e.g. Authorization=Bearer token")
+	otlp_attributes = runFlags.String("otlp-attributes", "", "Comma-separated name=value resource attributes of the exported metrics, e.g. network=mainnet")
+)
+
+// otlpTimeout bounds an export to -otlp-endpoint.
+const otlpTimeout = 10 * time.Second
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *otlp_endpoint == "" {
+			return nil, nil
+		}
+		return newOTLPExporter(*otlp_endpoint, splitList(*otlp_headers), splitList(*otlp_attributes), nodeName())
+	})
+}
+
+// otlpExporter exports the derived catch-up state as an OTLP metrics request
+// in the JSON encoding, so that no OpenTelemetry SDK is needed.
+type otlpExporter struct {
+	url      string
+	header   http.Header
+	resource otlpKeyValues
+	start    time.Time
+	errors   int64
+	lastErr  string
+}
+
+// newOTLPExporter returns an exporter to endpoint, whose metrics path
+// defaults to /v1/metrics as with OTEL_EXPORTER_OTLP_ENDPOINT. The resource
+// describes the host and node along with attributes.
+func newOTLPExporter(endpoint string, headers, attributes []string, node string) (*otlpExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid -otlp-endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	e := &otlpExporter{url: u.String(), header: http.Header{}, start: time.Now()}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -otlp-headers entry %q, must be name=value", h)
+		}
+		e.header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	host, _ := os.Hostname()
+	e.resource = otlpKeyValues{
+		otlpString("service.name", "sui-catchup"),
+		otlpString("host.name", host),
+		otlpString("sui.node.name", node),
+	}
+	for _, t := range tags {
+		e.resource = append(e.resource, otlpString(t.name, t.value))
+	}
+	for _, a := range attributes {
+		name, value, ok := strings.Cut(a, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -otlp-attributes entry %q, must be name=value", a)
+		}
+		e.resource = append(e.resource, otlpString(strings.TrimSpace(name), strings.TrimSpace(value)))
+	}
+	return e, nil
+}
+
+// The types below mirror the JSON encoding of OTLP's
+// ExportMetricsServiceRequest, in which 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes otlpKeyValues `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpMetric struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description"`
+		Unit        string     `json:"unit"`
+		Gauge       *otlpGauge `json:"gauge,omitempty"`
+		Sum         *otlpSum   `json:"sum,omitempty"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpSum struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+		// AggregationTemporality 2 is cumulative.
+		AggregationTemporality int  `json:"aggregationTemporality"`
+		IsMonotonic            bool `json:"isMonotonic"`
+	}
+	otlpDataPoint struct {
+		StartTimeUnixNano string   `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string   `json:"timeUnixNano"`
+		AsDouble          *float64 `json:"asDouble,omitempty"`
+		AsInt             string   `json:"asInt,omitempty"`
+	}
+	otlpKeyValues []otlpKeyValue
+	otlpKeyValue  struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+)
+
+func otlpString(key, value string) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	kv.Value.StringValue = value
+	return kv
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// request renders the metrics as of p.
+func (e *otlpExporter) request(p catchup.Progress) otlpRequest {
+	gauge := func(name, description, unit string, v float64) otlpMetric {
+		return otlpMetric{Name: name, Description: description, Unit: unit, Gauge: &otlpGauge{
+			DataPoints: []otlpDataPoint{{TimeUnixNano: otlpTime(p.Time), AsDouble: &v}},
+		}}
+	}
+	metrics := []otlpMetric{{
+		Name:        "sui_catchup.scrape_errors",
+		Description: "Number of failed scrapes of the node's metrics endpoint.",
+		Unit:        "{scrape}",
+		Sum: &otlpSum{
+			DataPoints: []otlpDataPoint{{
+				StartTimeUnixNano: otlpTime(e.start),
+				TimeUnixNano:      otlpTime(p.Time),
+				AsInt:             strconv.FormatInt(e.errors, 10),
+			}},
+			AggregationTemporality: 2,
+			IsMonotonic:            true,
+		},
+	}}
+	if p.Err == nil {
+		caughtUp := 0.0
+		if p.CaughtUp {
+			caughtUp = 1
+		}
+		metrics = append(metrics,
+			gauge("sui_catchup.checkpoint_lag", "Number of checkpoints the node is behind its highest known checkpoint.", "{checkpoint}", p.Lag),
+			gauge("sui_catchup.catchup_rate", "Smoothed rate at which the lag is shrinking.", "{checkpoint}/s", p.Rate),
+			gauge("sui_catchup.eta", "Estimated time until the node has caught up, 0 if it is not catching up.", "s", p.ETA.Seconds()),
+			gauge("sui_catchup.caught_up", "Whether the node has caught up (1) or not (0).", "1", caughtUp),
+		)
+	}
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: e.resource},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "sui-catchup"}, Metrics: metrics}},
+	}}}
+}
+
+// update exports the metrics as of p. Like pusher.push, it returns an error
+// only when it differs from the previous export's.
+func (e *otlpExporter) update(p catchup.Progress) error {
+	if p.Err != nil {
+		e.errors++
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	err := postJSON(ctx, e.url, e.header, e.request(p))
+	if err == nil {
+		e.lastErr = ""
+		return nil
+	}
+	if err.Error() == e.lastErr {
+		return nil
+	}
+	e.lastErr = err.Error()
+	return fmt.Errorf("exporting OTLP metrics failed: %v", err)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gosuri/uilive"
+	"github.com/mattn/go-isatty"
+)
+
+var (
+	no_tty       = waitFlags.Bool("no-tty", false, "Append timestamped log lines instead of updating the status in place (default when stdout is not a terminal)")
+	quiet        = waitFlags.Bool("quiet", false, "Print no progress, only a final line with the outcome")
+	show_elapsed = waitFlags.Bool("show-elapsed", false, "Show the time elapsed since sui-catchup started below the status")
+	refresh      = waitFlags.Duration("refresh", 200*time.Millisecond, "How often the status is redrawn on a terminal, independently of -interval, or 0 to redraw on every scrape only")
+)
+
+// output is where progress is rendered. Writes to status replace the current
+// status, writes to log are kept permanently; each write must consist of
+// whole lines.
+type output struct {
+	status io.Writer
+	log    io.Writer
+	stop   func()
+
+	// interactive is set when the status is updated in place.
+	interactive bool
+}
+
+// newOutput returns an output that updates the status in place on a
+// terminal, and otherwise, for systemd, nohup, CI or a legacy Windows
+// console, logs every status update as a timestamped line.
+func newOutput() *output {
+	if *quiet {
+		return &output{status: io.Discard, log: io.Discard, stop: func() {}}
+	}
+	// Consoles that cannot interpret escape sequences, like that of older
+	// Windows versions, get the log lines too.
+	if *no_tty || !(isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())) || !enableVirtualTerminal(os.Stdout) {
+		w := &timestampWriter{w: os.Stdout}
+		return &output{status: w, log: w, stop: func() {}}
+	}
+	writer := uilive.New()
+	// Drawing scrapes faster than the refresh rate would only flicker.
+	if *refresh > 0 {
+		writer.RefreshInterval = *refresh
+	}
+	writer.Start()
+	// Errors and warnings go to the same terminal unless redirected, above
+	// the status like the log.
+	stop := writer.Stop
+	if isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd()) {
+		prev := logOutput.set(writer.Bypass())
+		stop = func() {
+			writer.Stop()
+			logOutput.set(prev)
+		}
+	}
+	return &output{
+		status:      writer,
+		log:         &timestampWriter{w: writer.Bypass()},
+		stop:        stop,
+		interactive: true,
+	}
+}
+
+// timestampFormat is RFC 3339 to the millisecond, precise enough to correlate
+// the output with the node's logs.
+const timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampWriter prefixes every line written to it with the current time.
+type timestampWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prefix := []byte(time.Now().Format(timestampFormat) + " ")
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) > 0 {
+			buf.Write(prefix)
+			buf.Write(line)
+		}
+	}
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var (
+	pagerduty_key      = runFlags.String("pagerduty-routing-key", "", "Trigger a PagerDuty incident through this Events v2 integration key when the node stalls or falls behind, resolving it once the node recovers")
+	pagerduty_severity = runFlags.String("pagerduty-severity", "error", "Severity of PagerDuty incidents: critical, error, warning or info")
+)
+
+// pagerDutyURL is the PagerDuty Events API v2 endpoint.
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyNotifier triggers an incident per node when it stalls or falls
+// behind, and resolves it when the node is back in sync, and likewise for
+// alerts. Other events are ignored.
+type pagerDutyNotifier struct {
+	key      string
+	severity string
+}
+
+func newPagerDutyNotifier() (*pagerDutyNotifier, error) {
+	switch *pagerduty_severity {
+	case "critical", "error", "warning", "info":
+	default:
+		return nil, fmt.Errorf("unknown PagerDuty severity %q", *pagerduty_severity)
+	}
+	return &pagerDutyNotifier{key: *pagerduty_key, severity: *pagerduty_severity}, nil
+}
+
+func (n *pagerDutyNotifier) notify(ctx context.Context, ev event) error {
+	msg := map[string]interface{}{
+		"routing_key": n.key,
+		// Deduplicating on the node makes the resolve match the trigger, and
+		// repeated triggers update the open incident.
+		"dedup_key": "sui-catchup/" + ev.Node,
+	}
+	if ev.Kind == eventAlert || ev.Kind == eventAlertResolved {
+		// Alerts are resolved independently of the node falling behind.
+		msg["dedup_key"] = "sui-catchup/" + ev.Node + "/alert"
+	}
+	switch ev.Kind {
+	case eventFellBehind, eventStalled, eventAlert:
+		msg["event_action"] = "trigger"
+		msg["payload"] = map[string]interface{}{
+			"summary":   ev.String(),
+			"source":    ev.Node,
+			"severity":  n.severity,
+			"component": "sui-node",
+			"timestamp": ev.Time.UTC().Format(time.RFC3339Nano),
+			"custom_details": map[string]interface{}{
+				"known":  int64(ev.Progress.Known),
+				"synced": int64(ev.Progress.Synced),
+				"lag":    ev.Lag,
+				"rate":   ev.Progress.Rate,
+				"tags":   ev.Tags,
+			},
+		}
+	case eventRecovered, eventAlertResolved:
+		msg["event_action"] = "resolve"
+	default:
+		return nil
+	}
+	if err := postJSON(ctx, pagerDutyURL, nil, msg); err != nil {
+		return fmt.Errorf("pagerduty: %v", err)
+	}
+	return nil
+}
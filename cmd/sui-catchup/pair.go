@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	watch_pairs pairFlag
+	until_cond  = nodeFlags.String("until", "caught_up", "Wait until this condition over the node's metrics holds instead of until it has caught up, as current<op>target with op one of >= > <= <, e.g. 'current_epoch>=512', or caught_up")
+)
+
+func init() {
+	nodeFlags.Var(&watch_pairs, "watch", "Also wait for a metric to reach another, as [name:]target:current or [name:]current<op>target with op one of >= > <= <, e.g. 'db:highest_synced_checkpoint>=last_pruned_checkpoint+1000' (repeatable)")
+}
+
+// untilPairs returns the pairs to watch: those of -watch, and that of
+// -until unless it is caught_up, in which case the node must also have
+// caught up.
+func untilPairs() (pairs []catchup.Pair, only bool, err error) {
+	until := strings.TrimSpace(*until_cond)
+	if until == "" || until == "caught_up" {
+		return watch_pairs, false, nil
+	}
+	if !strings.ContainsAny(until, "<>") {
+		return nil, false, fmt.Errorf("invalid -until %q, must be current<op>target or caught_up", until)
+	}
+	p, err := parsePair("-until", until)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(append([]catchup.Pair(nil), watch_pairs...), p), true, nil
+}
+
+// pairFlag collects repeated -watch flags.
+type pairFlag []catchup.Pair
+
+func (f *pairFlag) String() string {
+	var specs []string
+	for _, p := range *f {
+		specs = append(specs, p.Name+":"+p.Current+p.Op+p.Target)
+	}
+	return strings.Join(specs, ", ")
+}
+
+func (f *pairFlag) Set(value string) error {
+	p, err := parsePair("-watch", value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, p)
+	return nil
+}
+
+// parsePair parses a -watch or -until value, as given by option. Without a
+// name, the pair is named after its current value.
+func parsePair(option, spec string) (catchup.Pair, error) {
+	var p catchup.Pair
+	// The longer operators must be looked for first.
+	for _, op := range []string{catchup.PairAtLeast, catchup.PairAtMost, catchup.PairAbove, catchup.PairBelow} {
+		if i := strings.Index(spec, op); i >= 0 {
+			p.Op, p.Current, p.Target = op, spec[:i], spec[i+len(op):]
+			if name, current, ok := strings.Cut(p.Current, ":"); ok {
+				p.Name, p.Current = name, current
+			}
+			break
+		}
+	}
+	if p.Op == "" {
+		parts := strings.Split(spec, ":")
+		switch len(parts) {
+		case 2:
+			p.Target, p.Current = parts[0], parts[1]
+		case 3:
+			p.Name, p.Target, p.Current = parts[0], parts[1], parts[2]
+		default:
+			return p, fmt.Errorf("invalid %s %q, must be [name:]target:current or [name:]current<op>target", option, spec)
+		}
+		p.Op = catchup.PairAtLeast
+	}
+	p.Name, p.Current, p.Target = strings.TrimSpace(p.Name), strings.TrimSpace(p.Current), strings.TrimSpace(p.Target)
+	if p.Current == "" || p.Target == "" {
+		return p, fmt.Errorf("invalid %s %q, both values must be given", option, spec)
+	}
+	if p.Name == "" {
+		p.Name = p.Current
+	}
+	return p, nil
+}
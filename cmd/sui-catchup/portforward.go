@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+var kube_pod = nodeFlags.String("kube-pod", "", "Scrape this pod in -kube-namespace through a port-forward to its -kube-port instead of -addr")
+
+// portForwardRetry is how long to wait before re-establishing a lost
+// port-forward.
+const portForwardRetry = time.Second
+
+func init() {
+	// Lost connections are handled by re-establishing the forward, so keep
+	// client-go from logging them over the status line.
+	utilruntime.ErrorHandlers = nil
+}
+
+// portForward forwards a local port to -kube-port of -kube-pod, like kubectl
+// port-forward, and returns the metrics address to scrape through it. The
+// forward is re-established on the same local port whenever it is lost, until
+// ctx is done; scrapes in between fail and are retried as usual.
+func portForward(ctx context.Context) (string, error) {
+	client, config, namespace, err := kubeClient()
+	if err != nil {
+		return "", err
+	}
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, *kube_pod, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("looking up pod failed: %v", err)
+	}
+	port, ok := containerPort(*pod, *kube_port)
+	if !ok {
+		return "", fmt.Errorf("pod %s has no container port %q", pod.Name, *kube_port)
+	}
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return "", fmt.Errorf("creating port-forward failed: %v", err)
+	}
+	url := client.CoreV1().RESTClient().Post().Resource("pods").Namespace(namespace).Name(pod.Name).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", url)
+
+	// Let the first forward pick a free local port and keep it afterwards.
+	local, done, err := forwardPort(ctx, dialer, fmt.Sprintf("0:%d", port))
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		for {
+			<-done
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(portForwardRetry):
+			}
+			for {
+				if _, done, err = forwardPort(ctx, dialer, fmt.Sprintf("%d:%d", local, port)); err == nil {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(portForwardRetry):
+				}
+			}
+		}
+	}()
+	return fmt.Sprintf("http://127.0.0.1:%d%s", local, *kube_path), nil
+}
+
+// forwardPort establishes a single port-forward for the local:remote port
+// pair, returning the local port and a channel closed once the forward ends.
+func forwardPort(ctx context.Context, dialer httpstream.Dialer, ports string) (uint16, <-chan struct{}, error) {
+	ready := make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{ports}, ctx.Done(), ready, io.Discard, io.Discard)
+	if err != nil {
+		return 0, nil, fmt.Errorf("creating port-forward failed: %v", err)
+	}
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		defer close(done)
+		errc <- fw.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-errc:
+		return 0, nil, fmt.Errorf("port-forward failed: %v", err)
+	}
+	forwarded, err := fw.GetPorts()
+	if err != nil || len(forwarded) == 0 {
+		return 0, nil, fmt.Errorf("port-forward failed: %v", err)
+	}
+	return forwarded[0].Local, done, nil
+}
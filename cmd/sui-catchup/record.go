@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	record_file = nodeFlags.String("record", "", "Append every response scraped to this file, to replay it later with -replay")
+	replay_file = nodeFlags.String("replay", "", "Replay the responses recorded by -record instead of scraping, one per -interval")
+)
+
+// recordHeader precedes the raw body of every response in a recording.
+type recordHeader struct {
+	Time   time.Time   `json:"time"`
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	// Error is set instead of Status when the request failed.
+	Error  string `json:"error,omitempty"`
+	Length int    `json:"length"`
+}
+
+// recorder is a transport appending every response to a recording: a JSON
+// header line per response followed by the body as received, which may be
+// compressed or in the protobuf format, and a newline.
+type recorder struct {
+	next http.RoundTripper
+	w    *os.File
+}
+
+// The -record file is shared by every watcher, e.g. of a fleet.
+var (
+	recordOnce sync.Once
+	recordOut  *os.File
+	recordErr  error
+	recordMu   sync.Mutex
+)
+
+// newRecorder wraps next with a recorder writing to -record.
+func newRecorder(next http.RoundTripper) (*recorder, error) {
+	recordOnce.Do(func() {
+		recordOut, recordErr = os.OpenFile(*record_file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	})
+	if recordErr != nil {
+		return nil, fmt.Errorf("opening -record file failed: %v", recordErr)
+	}
+	return &recorder{next: next, w: recordOut}, nil
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	h := recordHeader{Method: req.Method, URL: req.URL.String()}
+	resp, err := r.next.RoundTrip(req)
+	var body []byte
+	if err != nil {
+		h.Error = err.Error()
+	} else {
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		h.Status = resp.StatusCode
+		h.Header = http.Header{}
+		for _, name := range []string{"Content-Type", "Content-Encoding"} {
+			if v := resp.Header.Get(name); v != "" {
+				h.Header.Set(name, v)
+			}
+		}
+	}
+	h.Time = time.Now()
+	h.Length = len(body)
+	if werr := r.write(h, body); werr != nil {
+		return nil, fmt.Errorf("recording response failed: %v", werr)
+	}
+	return resp, err
+}
+
+func (r *recorder) CloseIdleConnections() {
+	(&http.Client{Transport: r.next}).CloseIdleConnections()
+}
+
+func (r *recorder) write(h recordHeader, body []byte) error {
+	line, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.Write(line)
+	b.WriteByte('\n')
+	b.Write(body)
+	b.WriteByte('\n')
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	_, err = r.w.Write(b.Bytes())
+	return err
+}
+
+// errEndOfRecording is returned by a replay once a URL's responses are used
+// up.
+var errEndOfRecording = errors.New("end of recording")
+
+// replayedResponse is a response read from a recording.
+type replayedResponse struct {
+	recordHeader
+	body []byte
+}
+
+// replay is a transport serving the responses of a recording in order,
+// separately for every method and URL, and a clock returning the time the
+// last one served was recorded at, so that rates are those of the recording.
+type replay struct {
+	mu        sync.Mutex
+	responses map[string][]replayedResponse
+	now       time.Time
+	// done is closed once a URL's responses are used up.
+	done     chan struct{}
+	finished bool
+}
+
+var (
+	replayOnce sync.Once
+	replaying  *replay
+	replayErr  error
+)
+
+// loadReplay returns the replay of -replay, reading the file on first use.
+func loadReplay() (*replay, error) {
+	replayOnce.Do(func() {
+		replaying, replayErr = readReplay(*replay_file)
+	})
+	return replaying, replayErr
+}
+
+func readReplay(path string) (*replay, error) {
+	responses, err := readRecording(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading -replay file failed: %v", err)
+	}
+	if len(responses) == 0 {
+		return nil, fmt.Errorf("-replay file %s is empty", path)
+	}
+	r := &replay{responses: map[string][]replayedResponse{}, done: make(chan struct{})}
+	for _, resp := range responses {
+		key := resp.Method + " " + resp.URL
+		r.responses[key] = append(r.responses[key], resp)
+	}
+	return r, nil
+}
+
+// readRecording reads the responses of a recording in the order recorded.
+func readRecording(path string) ([]replayedResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var responses []replayedResponse
+	in := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := in.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return responses, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var resp replayedResponse
+		if err := json.Unmarshal(line, &resp.recordHeader); err != nil {
+			return nil, fmt.Errorf("invalid header of response %d: %v", n, err)
+		}
+		resp.body = make([]byte, resp.Length+1)
+		if _, err := io.ReadFull(in, resp.body); err != nil {
+			return nil, fmt.Errorf("truncated response %d: %v", n, err)
+		}
+		resp.body = resp.body[:resp.Length]
+		responses = append(responses, resp)
+	}
+}
+
+func (r *replay) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := req.Method + " " + req.URL.String()
+	queue, ok := r.responses[key]
+	if !ok {
+		return nil, fmt.Errorf("no responses for %s recorded", key)
+	}
+	if len(queue) == 0 {
+		if !r.finished {
+			r.finished = true
+			close(r.done)
+		}
+		return nil, errEndOfRecording
+	}
+	resp := queue[0]
+	r.responses[key] = queue[1:]
+	r.now = resp.Time
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.Status, http.StatusText(resp.Status)),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        resp.Header,
+		Body:          io.NopCloser(bytes.NewReader(resp.body)),
+		ContentLength: int64(len(resp.body)),
+		Request:       req,
+	}, nil
+}
+
+// isClosed reports whether ch, which may be nil, is closed.
+func isClosed(ch chan struct{}) bool {
+	if ch == nil {
+		return false
+	}
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// clock returns the time the last response served was recorded at.
+func (r *replay) clock() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	remote_write_url          = runFlags.String("remote-write-url", "", "Remote-write the lag, rate and scrape errors on every interval to this Prometheus remote-write endpoint, e.g. http://mimir:9009/api/v1/push")
+	remote_write_basic_auth   = runFlags.String("remote-write-basic-auth", "", "Credentials for -remote-write-url as user:password")
+	remote_write_bearer_token = runFlags.String("remote-write-bearer-token", "", "Bearer token for -remote-write-url")
+	remote_write_labels       = runFlags.String("remote-write-labels", "", "Comma-separated name=value labels added to the remote-written series, e.g. network=mainnet")
+)
+
+// remoteWriteTimeout bounds a request to -remote-write-url.
+const remoteWriteTimeout = 10 * time.Second
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *remote_write_url == "" {
+			return nil, nil
+		}
+		return newRemoteWriter(*remote_write_url, *remote_write_basic_auth, *remote_write_bearer_token, splitList(*remote_write_labels), nodeName())
+	})
+}
+
+// remoteWriter sends the derived catch-up state as a remote-write 1.0
+// WriteRequest, encoded with protowire as the library decodes gRPC
+// responses, so that no Prometheus server packages are needed.
+type remoteWriter struct {
+	url     string
+	header  http.Header
+	labels  []remoteWriteLabel
+	errors  int64
+	lastErr string
+}
+
+type remoteWriteLabel struct {
+	name, value string
+}
+
+// newRemoteWriter returns a writer to endpoint authenticating with
+// basicAuth or bearerToken. The series are labeled with the job and node
+// along with labels.
+func newRemoteWriter(endpoint, basicAuth, bearerToken string, labels []string, node string) (*remoteWriter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid -remote-write-url %q", endpoint)
+	}
+	w := &remoteWriter{
+		url: u.String(),
+		header: http.Header{
+			"Content-Encoding":                  {"snappy"},
+			"X-Prometheus-Remote-Write-Version": {"0.1.0"},
+		},
+		labels: []remoteWriteLabel{{"job", "sui_catchup"}, {"node", node}},
+	}
+	switch {
+	case basicAuth != "" && bearerToken != "":
+		return nil, errors.New("only one of -remote-write-basic-auth and -remote-write-bearer-token may be specified")
+	case basicAuth != "":
+		if !strings.Contains(basicAuth, ":") {
+			return nil, errors.New("invalid -remote-write-basic-auth, must be user:password")
+		}
+		w.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basicAuth)))
+	case bearerToken != "":
+		w.header.Set("Authorization", "Bearer "+bearerToken)
+	}
+	for _, t := range tags {
+		w.labels = append(w.labels, remoteWriteLabel{t.name, t.value})
+	}
+	for _, l := range labels {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -remote-write-labels entry %q, must be name=value", l)
+		}
+		w.setLabel(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return w, nil
+}
+
+// setLabel sets the label name of every series, replacing its value if
+// already set, e.g. by a tag, as a series may not repeat a label.
+func (w *remoteWriter) setLabel(name, value string) {
+	for i, l := range w.labels {
+		if l.name == name {
+			w.labels[i].value = value
+			return
+		}
+	}
+	w.labels = append(w.labels, remoteWriteLabel{name, value})
+}
+
+// request renders the series as of p as a protobuf WriteRequest.
+func (w *remoteWriter) request(p catchup.Progress) []byte {
+	type series struct {
+		name  string
+		value float64
+	}
+	all := []series{{"sui_catchup_scrape_errors_total", float64(w.errors)}}
+	if p.Err == nil {
+		caughtUp := 0.0
+		if p.CaughtUp {
+			caughtUp = 1
+		}
+		all = append(all,
+			series{"sui_catchup_checkpoint_lag", p.Lag},
+			series{"sui_catchup_execution_lag", p.ExecutionLag},
+			series{"sui_catchup_catchup_rate", p.Rate},
+			series{"sui_catchup_eta_seconds", p.ETA.Seconds()},
+			series{"sui_catchup_caught_up", caughtUp},
+		)
+	}
+	var req []byte
+	for _, s := range all {
+		// Receivers expect the labels sorted by name, __name__ first.
+		labels := append([]remoteWriteLabel{{"__name__", s.name}}, w.labels...)
+		sort.SliceStable(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		var ts []byte
+		for _, l := range labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(p.Time.UnixMilli()))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// snappyLiteral encodes b in the snappy block format as a single literal,
+// which every snappy decoder reads. A request of a few hundred bytes gains
+// little from compression.
+func snappyLiteral(b []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(b)))
+	if len(b) == 0 {
+		return out
+	}
+	// Literals of up to 60 bytes have their length in the tag, longer ones
+	// in the 4 bytes after it.
+	if n := len(b) - 1; n < 60 {
+		out = append(out, byte(n)<<2)
+	} else {
+		out = append(out, 63<<2)
+		out = binary.LittleEndian.AppendUint32(out, uint32(n))
+	}
+	return append(out, b...)
+}
+
+// update writes the series as of p. Like pusher.push, it returns an error
+// only when it differs from the previous write's.
+func (w *remoteWriter) update(p catchup.Progress) error {
+	if p.Err != nil {
+		w.errors++
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remoteWriteTimeout)
+	defer cancel()
+	err := post(ctx, w.url, w.header, "application/x-protobuf", snappyLiteral(w.request(p)))
+	if err == nil {
+		w.lastErr = ""
+		return nil
+	}
+	if err.Error() == w.lastErr {
+		return nil
+	}
+	w.lastErr = err.Error()
+	return fmt.Errorf("remote-writing metrics failed: %v", err)
+}
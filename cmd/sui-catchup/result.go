@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var result_file = commonFlags.String("result-file", "", "Write the outcome as JSON to this file on every exit: status, reason, duration and final watermarks, for provisioners to branch on")
+
+// result is the outcome of the command so far, written to -result-file on
+// exit.
+var result = &outcome{start: time.Now()}
+
+// outcome tracks the last successful scrape and the last error.
+type outcome struct {
+	mu     sync.Mutex
+	start  time.Time
+	last   catchup.Progress
+	reason string
+}
+
+// observe records p if it is a successful scrape.
+func (o *outcome) observe(p catchup.Progress) {
+	if p.Err != nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.last = p
+}
+
+// fail records why the command is likely to exit unsuccessfully.
+func (o *outcome) fail(reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.reason = redact(reason)
+}
+
+// resultReport is the -result-file document. The watermarks are omitted if
+// the node was never scraped successfully.
+type resultReport struct {
+	Node            string            `json:"node"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	Status          string            `json:"status"`
+	ExitCode        int               `json:"exit_code"`
+	Reason          string            `json:"reason,omitempty"`
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	ScrapedAt       *time.Time        `json:"scraped_at,omitempty"`
+	Known           *int64            `json:"known,omitempty"`
+	Synced          *int64            `json:"synced,omitempty"`
+	Lag             *int64            `json:"lag,omitempty"`
+	Epoch           *int64            `json:"epoch,omitempty"`
+}
+
+// write writes the outcome of the command exiting with code to path. The
+// reason is left out on success, where errors logged along the way, e.g. of
+// notifications, did not decide the outcome.
+func (o *outcome) write(path string, code int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	end := time.Now()
+	r := resultReport{
+		Node:            nodeName(),
+		Tags:            tagMap(),
+		Status:          exitStatus(code),
+		ExitCode:        code,
+		Start:           o.start,
+		End:             end,
+		DurationSeconds: end.Sub(o.start).Seconds(),
+	}
+	if code != exitCaughtUp {
+		r.Reason = o.reason
+	}
+	if p := o.last; !p.Time.IsZero() {
+		known, synced, lag, epoch := int64(p.Known), int64(p.Synced), int64(p.Lag), int64(p.Epoch)
+		r.ScrapedAt, r.Known, r.Synced, r.Lag = &p.Time, &known, &synced, &lag
+		if p.Epoch != 0 {
+			r.Epoch = &epoch
+		}
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing -result-file failed: %v", err)
+	}
+	return nil
+}
+
+// exit writes -result-file, if set, and exits with code.
+func exit(code int) {
+	if *result_file != "" {
+		if err := result.write(*result_file, code); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+	os.Exit(code)
+}
+
+// errorRecorder passes records on to its handler, recording the message of
+// every error as the reason of the outcome.
+type errorRecorder struct {
+	slog.Handler
+}
+
+func (h errorRecorder) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		result.fail(r.Message)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h errorRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return errorRecorder{h.Handler.WithAttrs(attrs)}
+}
+
+func (h errorRecorder) WithGroup(name string) slog.Handler {
+	return errorRecorder{h.Handler.WithGroup(name)}
+}
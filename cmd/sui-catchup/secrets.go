@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// secretFlags are the flags holding credentials, or URLs that are
+// credentials themselves such as Slack's. Each can also be read from a file
+// with its -<name>-file variant, or set from the environment as every flag
+// can be, so that it appears neither on the command line nor in the shell's
+// history.
+var secretFlags = []string{
+	"bearer-token",
+	"basic-auth",
+	"header",
+	"remote-write-bearer-token",
+	"remote-write-basic-auth",
+	"slack-webhook",
+	"webhook-url",
+	"telegram-bot-token",
+	"discord-bot-token",
+	"pagerduty-routing-key",
+	"grafana-token",
+	"influx-token",
+	"consul-token",
+	"otlp-headers",
+}
+
+func init() {
+	// The -<name>-file variant is in the group of -<name>.
+	for _, name := range secretFlags {
+		for _, g := range flagGroups {
+			if g.Lookup(name) != nil {
+				g.String(name+"-file", "", fmt.Sprintf("File holding the value of -%s, e.g. a mounted secret, rather than giving it on the command line", name))
+			}
+		}
+	}
+}
+
+// secrets are the values redacted from logs and every other output, longest
+// first so that a secret containing another is redacted whole.
+var secrets []string
+
+// minSecretLen is the length below which values are not redacted, as they
+// would mangle every log line sharing a few characters with them, e.g. the
+// "1" of an empty password left as a placeholder.
+const minSecretLen = 4
+
+// loadSecrets sets the secret flags of the command given by their
+// -<name>-file variants and collects the values to redact from logs: those
+// of the secret flags, their parts, and the passwords of URLs given to any
+// flag.
+func loadSecrets() error {
+	for _, name := range secretFlags {
+		f := flag.Lookup(name + "-file")
+		if f == nil || f.Value.String() == "" {
+			continue
+		}
+		path := f.Value.String()
+		if flag.Lookup(name).Value.String() != "" {
+			return fmt.Errorf("only one of -%s and -%s-file may be specified", name, name)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading -%s-file failed: %v", name, err)
+		}
+		values := []string{strings.TrimSpace(string(b))}
+		// A file of headers holds one per line.
+		if _, ok := flag.Lookup(name).Value.(*headerFlag); ok {
+			values = strings.FieldsFunc(values[0], func(r rune) bool { return r == '\n' })
+		}
+		for _, value := range values {
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value in -%s-file: %v", name, err)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	add := func(s string) {
+		if s = strings.TrimSpace(s); len(s) >= minSecretLen && !seen[s] {
+			seen[s] = true
+			secrets = append(secrets, s)
+		}
+	}
+	for _, name := range secretFlags {
+		f := flag.Lookup(name)
+		if f == nil {
+			continue
+		}
+		value := f.Value.String()
+		add(value)
+		// The password of user:password and the values of name=value
+		// and Name: value headers may be logged on their own.
+		for _, item := range strings.Split(value, ",") {
+			if i := strings.IndexAny(item, ":="); i >= 0 && !strings.Contains(item, "://") {
+				add(item[i+1:])
+			}
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		for _, item := range strings.Split(f.Value.String(), ",") {
+			if u, err := url.Parse(strings.TrimSpace(item)); err == nil && u.User != nil {
+				if password, ok := u.User.Password(); ok {
+					add(password)
+				}
+			}
+		}
+	})
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return nil
+}
+
+// redact replaces the secrets in s with "REDACTED".
+func redact(s string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "REDACTED")
+	}
+	return s
+}
+
+// redactHandler redacts the secrets from the messages and attributes of
+// records, as errors such as those of failed notifications may quote a
+// webhook URL or a request's credentials.
+type redactHandler struct {
+	slog.Handler
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	if len(secrets) == 0 {
+		return h.Handler.Handle(ctx, r)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return redactHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr redacts the secrets from the value of a, rendering errors and
+// other values printed as strings first.
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]any, len(attrs))
+		for i, attr := range attrs {
+			redacted[i] = redactAttr(attr)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, redact(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, redact(x.String()))
+		}
+	}
+	return a
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+func TestLoadSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "headers")
+	if err := os.WriteFile(file, []byte("X-Api-Key: key-from-file\nX-Team: ops\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(commandLine *flag.FlagSet) {
+		_ = flag.Set("header-file", "")
+		flag.CommandLine = commandLine
+		secrets, extra_headers, *basic_auth = nil, nil, ""
+	}(flag.CommandLine)
+	flag.CommandLine = newCommandFlags("wait", runCommandFlags)
+	if err := flag.CommandLine.Parse([]string{"-basic-auth", "ops:hunter2", "-header-file", file}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if len(extra_headers) != 2 {
+		t.Errorf("got headers %q, want one per line of -header-file", extra_headers)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"auth ops:hunter2 failed", "auth REDACTED failed"},
+		{"password hunter2", "password REDACTED"},
+		{"key key-from-file", "key REDACTED"},
+		// Too short to be redacted without mangling other words.
+		{"ops team", "ops team"},
+	}
+	for _, tt := range tests {
+		if got := redact(tt.in); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	addr := "http://alice:hunter22@" + srv.Listener.Addr().String() + "/metrics"
+
+	defer func(commandLine *flag.FlagSet, validator string) {
+		flag.CommandLine = commandLine
+		secrets, *validator_addr = nil, validator
+	}(flag.CommandLine, *validator_addr)
+	flag.CommandLine = newCommandFlags("wait", runCommandFlags)
+	if err := flag.CommandLine.Parse([]string{"-addr", addr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := catchup.New(catchup.Options{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scraped, err := w.Check(context.Background())
+	if err == nil {
+		t.Fatal("scraping succeeded, want an error")
+	}
+	// An error of the command's own quoting the URL whole.
+	own := scraped
+	own.Err = fmt.Errorf("dialing %s failed", addr)
+
+	for _, p := range []catchup.Progress{scraped, own} {
+		outputs := map[string]string{}
+
+		var d display
+		var status bytes.Buffer
+		d.printProgress(&status, p, false)
+		outputs["status line"] = status.String()
+
+		var summary bytes.Buffer
+		newSession().printOutcome(&summary, p.Err)
+		outputs["summary"] = summary.String()
+
+		dir := t.TempDir()
+		o := &outcome{start: time.Now()}
+		o.fail(p.Err.Error())
+		if err := o.write(filepath.Join(dir, "result.json"), exitScrapeFailed); err != nil {
+			t.Fatal(err)
+		}
+		e, err := openEventsFile(filepath.Join(dir, "events.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ev := newEvent(eventAlert, p, time.Now())
+		ev.Reason = p.Err.Error()
+		if err := e.record(ev); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.end(exitScrapeFailed, p); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"result.json", "events.jsonl"} {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs[name] = string(b)
+		}
+
+		var h health
+		h.set(p)
+		ready, statusJSON := httptest.NewRecorder(), httptest.NewRecorder()
+		probe(h.ready)(ready, httptest.NewRequest("GET", "/readyz", nil))
+		h.serveStatus(statusJSON, httptest.NewRequest("GET", "/status", nil))
+		outputs["/readyz"], outputs["/status"] = ready.Body.String(), statusJSON.Body.String()
+
+		outputs["fleet"] = fleetStatus(p, nil)
+
+		for name, out := range outputs {
+			if strings.Contains(out, "hunter22") {
+				t.Errorf("%s shows the password: %s", name, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// defaultServeAddr is where the serve command listens without -listen.
+const defaultServeAddr = ":8080"
+
+// shutdownTimeout bounds how long the serve command waits for requests in
+// flight once stopped.
+const shutdownTimeout = 5 * time.Second
+
+// health is the node's state as reported by the serve command.
+type health struct {
+	mu   sync.Mutex
+	p    catchup.Progress
+	seen bool // whether p is set
+	// last is the last successful scrape, and lastErr the last failed one,
+	// which are kept while the other kind of scrape follows.
+	last    catchup.Progress
+	lastErr catchup.Progress
+}
+
+func (h *health) set(p catchup.Progress) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.p = p
+	h.seen = true
+	if p.Err != nil {
+		h.lastErr = p
+	} else {
+		h.last = p
+	}
+}
+
+// nodeStatus is a node's entry in the /status response.
+type nodeStatus struct {
+	Node string            `json:"node"`
+	Tags map[string]string `json:"tags,omitempty"`
+	// ScrapedAt is the time of the last successful scrape, from which the
+	// watermarks below are.
+	ScrapedAt    *time.Time `json:"scraped_at,omitempty"`
+	Known        int64      `json:"known"`
+	Synced       int64      `json:"synced"`
+	Lag          int64      `json:"lag"`
+	ExecutionLag int64      `json:"execution_lag"`
+	Rate         float64    `json:"rate"`
+	SyncRate     float64    `json:"sync_rate"`
+	NetworkRate  float64    `json:"network_rate"`
+	ClosureRate  float64    `json:"closure_rate"`
+	TPS          float64    `json:"tps"`
+	ETASeconds   float64    `json:"eta_seconds"`
+	CaughtUp     bool       `json:"caught_up"`
+	// Healthy is whether -health-url succeeded, if set.
+	Healthy           *bool   `json:"healthy,omitempty"`
+	Ready             bool    `json:"ready"`
+	Live              bool    `json:"live"`
+	Reason            string  `json:"reason"`
+	StalledForSeconds float64 `json:"stalled_for_seconds"`
+	// FailedScrapes is the number of consecutive failed scrapes, and
+	// LastError the error of the last failed scrape, even if a successful
+	// one followed.
+	FailedScrapes int `json:"failed_scrapes"`
+	// Restarts counts the restarts of the node seen by its uptime.
+	Restarts int `json:"restarts"`
+	// ParseWarnings is the number of lines of the last scrape skipped
+	// with -tolerant-parsing.
+	ParseWarnings int `json:"parse_warnings"`
+	// Endpoint is the node's endpoint in use, unless reading from a
+	// Prometheus server.
+	Endpoint string `json:"endpoint,omitempty"`
+	// RemoteAddr is the address of the endpoint that answered.
+	RemoteAddr  string     `json:"remote_addr,omitempty"`
+	Version     string     `json:"version,omitempty"`
+	Validator   string     `json:"validator,omitempty"`
+	Network     string     `json:"network,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+func (h *health) status() nodeStatus {
+	ready, reason := h.ready()
+	live, _ := h.live()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := nodeStatus{Node: nodeName(), Tags: tagMap(), Ready: ready, Live: live, Reason: reason, FailedScrapes: h.p.Errors, Endpoint: h.p.Endpoint}
+	if p := h.last; !p.Time.IsZero() {
+		s.ScrapedAt = &p.Time
+		s.Known, s.Synced, s.Lag, s.ExecutionLag = int64(p.Known), int64(p.Synced), int64(p.Lag), int64(p.ExecutionLag)
+		s.Rate, s.ETASeconds, s.CaughtUp = p.Rate, p.ETA.Seconds(), p.CaughtUp
+		s.SyncRate, s.NetworkRate, s.ClosureRate, s.TPS = p.SyncRate, p.NetworkRate, p.ClosureRate, p.TPS
+		s.StalledForSeconds = p.StalledFor.Seconds()
+		s.Restarts, s.ParseWarnings = p.Restarts, p.ParseWarnings
+		s.Version, s.RemoteAddr = p.Version, p.RemoteAddr
+		s.Validator, s.Network = p.Identity.Validator, p.Identity.Network
+		if p.Health != nil {
+			s.Healthy = &p.Health.Healthy
+		}
+	}
+	if p := h.lastErr; p.Err != nil {
+		s.LastError = redact(p.Err.Error())
+		s.LastErrorAt = &p.Time
+	}
+	return s
+}
+
+// serveStatus answers /status with the state of the watched nodes as JSON.
+func (h *health) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]nodeStatus{"nodes": {h.status()}})
+}
+
+// ready reports whether the node is at most -lag-threshold checkpoints
+// behind according to the last scrape, with the reason if not.
+func (h *health) ready() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case !h.seen:
+		return false, "not scraped yet"
+	case h.p.Err != nil:
+		return false, "scraping failed: " + redact(h.p.Err.Error())
+	case h.p.Lag > float64(*lag_threshold):
+		return false, fmt.Sprintf("%d checkpoints behind", int64(h.p.Lag))
+	default:
+		return true, fmt.Sprintf("%d checkpoints behind", int64(h.p.Lag))
+	}
+}
+
+// live reports whether the node is making progress: it fails only once the
+// synced checkpoint has not advanced for -stall-timeout while behind, so that
+// a node that is still catching up is not restarted.
+func (h *health) live() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.seen && *stall_timeout > 0 && h.p.Lag > float64(*lag_threshold) && h.p.StalledFor >= *stall_timeout {
+		return false, fmt.Sprintf("synced checkpoint stuck at %d for %s", int64(h.p.Synced), formatETA(h.p.StalledFor))
+	}
+	return true, "ok"
+}
+
+// probe returns a handler answering 200 or 503 according to check.
+func probe(check func() (bool, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, reason := check()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = fmt.Fprintln(w, reason)
+	}
+}
+
+// runServe implements the serve command, which keeps watching the node and
+// serves /readyz and /livez probes, a JSON /status, a stream of it on
+// /events, a dashboard and sui-catchup's own metrics on -listen. Run as a
+// sidecar, it gates a fullnode pod's readiness on the node's actual sync
+// state.
+func runServe() int {
+	forward, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := watchAddr(forward)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+	// Stalls are reported by /livez instead of ending the watch.
+	watcher, err := newWatcher(node, 0)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitError
+	}
+
+	var consul *consulService
+	if *consul_addr != "" {
+		if consul, err = newConsulService(nodeName()); err != nil {
+			slog.Error(err.Error())
+			return exitError
+		}
+		// A node no longer watched should not keep its registration.
+		defer func() {
+			if err := consul.deregister(); err != nil {
+				slog.Warn(err.Error())
+			}
+		}()
+	}
+
+	addr := *listen_addr
+	if addr == "" {
+		addr = defaultServeAddr
+	}
+	metrics := newExporter()
+	var state health
+	events := newBroadcaster()
+	mux := statusMux(metrics, &state, events)
+	mux.Handle("/readyz", probe(state.ready))
+	mux.Handle("/livez", probe(state.live))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Requests are canceled with ctx, so that /events and gRPC Watch
+	// streams end rather than holding up the shutdown.
+	baseContext := func(net.Listener) context.Context { return ctx }
+	srv := &http.Server{Addr: addr, Handler: mux, BaseContext: baseContext}
+	servers := []*http.Server{srv}
+	var grpc *grpcHealth
+	if *grpc_listen != "" {
+		grpc = newGRPCHealth()
+		grpcSrv := grpc.server(*grpc_listen)
+		grpcSrv.BaseContext = baseContext
+		servers = append(servers, grpcSrv)
+	}
+	serveErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s *http.Server) {
+			serveErr <- s.ListenAndServe()
+		}(s)
+		defer s.Close()
+	}
+	if *haproxy_listen != "" {
+		agent, err := newHAProxyAgent(*haproxy_listen, &state)
+		if err != nil {
+			slog.Error(err.Error())
+			return exitError
+		}
+		go agent.serve()
+		defer agent.close()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- watcher.Follow(ctx)
+	}()
+
+	logw := &timestampWriter{w: os.Stdout}
+	_, _ = fmt.Fprintf(logw, "Serving /readyz, /livez, /status, /events and a dashboard on %s\n", addr)
+	if grpc != nil {
+		_, _ = fmt.Fprintf(logw, "Serving the gRPC health service on %s\n", *grpc_listen)
+	}
+	if *haproxy_listen != "" {
+		_, _ = fmt.Fprintf(logw, "Answering HAProxy agent checks on %s\n", *haproxy_listen)
+	}
+	var was bool
+	for {
+		select {
+		case err := <-serveErr:
+			stop()
+			slog.Error("Serving failed", "addr", addr, "err", err)
+			for range watcher.Events() {
+			}
+			<-done
+			return exitError
+		case p, ok := <-watcher.Events():
+			if !ok {
+				err := <-done
+				var scrape *catchup.ScrapeError
+				switch {
+				case err == context.Canceled:
+					// Being stopped is how a sidecar ends, not a failure.
+					shutdown(servers)
+					return exitCaughtUp
+				case errors.As(err, &scrape):
+					slog.Error(err.Error())
+					return exitScrapeFailed
+				default:
+					slog.Error(err.Error())
+					return exitError
+				}
+			}
+			metrics.update(p)
+			state.set(p)
+			status := state.status()
+			if grpc != nil {
+				grpc.set(status.Ready)
+			}
+			if consul != nil {
+				if err := consul.update(status.Ready, status.Reason); err != nil {
+					slog.Warn(err.Error())
+				}
+			}
+			if ready, reason := status.Ready, status.Reason; ready != was {
+				if ready {
+					_, _ = fmt.Fprintf(logw, "Ready, %s\n", reason)
+					events.publish("ready", status)
+				} else {
+					_, _ = fmt.Fprintf(logw, "Not ready, %s\n", reason)
+					events.publish("not_ready", status)
+				}
+				was = ready
+			}
+			events.publish("progress", status)
+		}
+	}
+}
+
+// shutdown stops servers gracefully, answering the requests in flight for up
+// to shutdownTimeout.
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Warn("Shutting down failed", "addr", s.Addr, "err", err)
+		}
+	}
+}
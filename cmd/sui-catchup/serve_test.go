@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+func TestProbes(t *testing.T) {
+	threshold, timeout := *lag_threshold, *stall_timeout
+	defer func() { *lag_threshold, *stall_timeout = threshold, timeout }()
+	*lag_threshold, *stall_timeout = 10, time.Minute
+
+	tests := []struct {
+		name                string
+		p                   *catchup.Progress // nil before the first scrape
+		readyCode, liveCode int
+		ready, live         string
+	}{
+		{"not scraped", nil, 503, 200, "not scraped yet", "ok"},
+		{"scrape failed", &catchup.Progress{Err: errors.New("connection refused")}, 503, 200, "scraping failed: connection refused", "ok"},
+		{"behind", &catchup.Progress{Lag: 50}, 503, 200, "50 checkpoints behind", "ok"},
+		{"caught up", &catchup.Progress{Lag: 5}, 200, 200, "5 checkpoints behind", "ok"},
+		{"stalled behind", &catchup.Progress{Lag: 50, Synced: 100, StalledFor: 2 * time.Minute}, 503, 503, "50 checkpoints behind", "synced checkpoint stuck at 100 for "},
+		{"stalled caught up", &catchup.Progress{Lag: 5, StalledFor: 2 * time.Minute}, 200, 200, "5 checkpoints behind", "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h health
+			if tt.p != nil {
+				h.set(*tt.p)
+			}
+			for _, p := range []struct {
+				check func() (bool, string)
+				code  int
+				body  string
+			}{
+				{h.ready, tt.readyCode, tt.ready},
+				{h.live, tt.liveCode, tt.live},
+			} {
+				rec := httptest.NewRecorder()
+				probe(p.check).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != p.code || !strings.HasPrefix(rec.Body.String(), p.body) {
+					t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), p.code, p.body)
+				}
+			}
+		})
+	}
+}
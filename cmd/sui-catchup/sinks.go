@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+// sink takes every Progress of the node run watches, e.g. to export it to a
+// monitoring system or record it. A sink that is also an io.Closer is closed
+// once watching ends.
+type sink interface {
+	update(p catchup.Progress) error
+}
+
+// sinkFactories open the sinks of the features enabled on the command line,
+// in the order registered.
+var sinkFactories []func(w *catchup.Watcher) (sink, error)
+
+// registerSink adds factory, which returns the sink of a feature for the
+// watcher, or nil if its flags leave it disabled. Features register from the
+// init function of their file, so that run does not need to know them.
+func registerSink(factory func(w *catchup.Watcher) (sink, error)) {
+	sinkFactories = append(sinkFactories, factory)
+}
+
+// sinks are the sinks open for a watcher.
+type sinks []sink
+
+// openSinks opens the sinks enabled on the command line for w, closing those
+// already open if one fails.
+func openSinks(w *catchup.Watcher) (sinks, error) {
+	var open sinks
+	for _, factory := range sinkFactories {
+		s, err := factory(w)
+		if err != nil {
+			open.close()
+			return nil, err
+		}
+		if s != nil {
+			open = append(open, s)
+		}
+	}
+	return open, nil
+}
+
+// update passes p to every sink, logging their errors: a sink failing does
+// not stop the watch.
+func (s sinks) update(p catchup.Progress) {
+	for _, sink := range s {
+		if err := sink.update(p); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+}
+
+// close closes the sinks that need it.
+func (s sinks) close() {
+	for _, sink := range s {
+		if c, ok := sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				slog.Error(err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+var slack_webhook = runFlags.String("slack-webhook", "", "Post to this Slack incoming webhook URL when the node catches up, stalls or falls behind")
+
+// slackNotifier posts events to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+func (s slackNotifier) notify(ctx context.Context, ev event) error {
+	emoji := ":white_check_mark:"
+	if ev.Kind != eventCaughtUp && ev.Kind != eventRecovered && ev.Kind != eventAlertResolved {
+		emoji = ":warning:"
+	}
+	if err := postJSON(ctx, s.url, nil, map[string]string{"text": emoji + " " + ev.String()}); err != nil {
+		return fmt.Errorf("slack: %v", err)
+	}
+	return nil
+}
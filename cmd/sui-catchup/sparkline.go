@@ -0,0 +1,47 @@
+package main
+
+var sparkline_size = runFlags.Int("sparkline", 30, "Show a sparkline of this many recent catch-up rates after the status line on a terminal (0 disables)")
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline keeps the most recent values and renders them as unicode bars
+// scaled between their minimum, or zero if lower, and their maximum.
+type sparkline struct {
+	values []float64
+	size   int
+}
+
+func newSparkline(size int) *sparkline {
+	return &sparkline{size: size}
+}
+
+func (s *sparkline) add(v float64) {
+	if len(s.values) == s.size {
+		s.values = s.values[1:]
+	}
+	s.values = append(s.values, v)
+}
+
+func (s *sparkline) String() string {
+	if len(s.values) < 2 {
+		return ""
+	}
+	min, max := 0.0, s.values[0]
+	for _, v := range s.values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	bars := make([]rune, len(s.values))
+	for i, v := range s.values {
+		n := 0
+		if max > min {
+			n = int((v - min) / (max - min) * float64(len(sparks)-1))
+		}
+		bars[i] = sparks[n]
+	}
+	return string(bars)
+}
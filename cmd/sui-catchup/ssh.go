@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	ssh_host        = transportFlags.String("ssh", "", "Tunnel requests to the -addr host over SSH through this jump host, as [user@]host[:port], authenticating with the SSH agent")
+	ssh_known_hosts = transportFlags.String("ssh-known-hosts", "~/.ssh/known_hosts", "known_hosts file to verify the SSH jump host's key with")
+)
+
+// sshTunnel dials connections through an SSH jump host, reconnecting when the
+// SSH connection is lost.
+type sshTunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// newSSHTunnel returns a tunnel through the jump host given as
+// [user@]host[:port]. It does not connect until the first dial.
+func newSSHTunnel(dest string) (*sshTunnel, error) {
+	user := os.Getenv("USER")
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		user, dest = dest[:i], dest[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(dest); err != nil {
+		dest = net.JoinHostPort(dest, "22")
+	}
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("-ssh requires an SSH agent, but SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SSH agent failed: %v", err)
+	}
+	hostKeys, err := knownhosts.New(expandHome(*ssh_known_hosts))
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH known hosts failed: %v", err)
+	}
+	return &sshTunnel{
+		addr: dest,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(conn).Signers)},
+			HostKeyCallback: hostKeys,
+		},
+	}, nil
+}
+
+// dial connects to address from the jump host.
+func (t *sshTunnel) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if t.client == nil {
+			client, err := t.connect(ctx)
+			if err != nil {
+				return nil, err
+			}
+			t.client = client
+		}
+		conn, err := t.client.Dial(network, address)
+		if err == nil {
+			return conn, nil
+		}
+		// The SSH connection may have been lost, so reconnect once before
+		// giving up.
+		t.client.Close()
+		t.client = nil
+		if attempt > 0 {
+			return nil, fmt.Errorf("dialing %s through SSH failed: %v", address, err)
+		}
+	}
+}
+
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SSH jump host failed: %v", err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to SSH jump host failed: %v", err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// expandHome replaces a leading ~/ in path with the home directory.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
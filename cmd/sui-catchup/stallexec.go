@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	on_stall_exec     = runFlags.String("on-stall-exec", "", "Shell command run when the synced checkpoint has not advanced for -on-stall-after, e.g. 'systemctl restart sui-node'")
+	on_stall_after    = runFlags.Duration("on-stall-after", 10*time.Minute, "How long the node must be stalled before -on-stall-exec runs")
+	on_stall_cooldown = runFlags.Duration("on-stall-cooldown", 15*time.Minute, "Least time between runs of -on-stall-exec, to give the node time to recover")
+	on_stall_max      = runFlags.Int("on-stall-max", 3, "Most times -on-stall-exec runs, or 0 for no limit")
+)
+
+// stallHook runs -on-stall-exec to kick a wedged node during unattended
+// catch-ups.
+type stallHook struct {
+	runs int
+	// ran is when the command last ran, zero if it never did.
+	ran  time.Time
+	done chan struct{}
+	gave bool
+}
+
+// update runs the command in the background if p has been stalled for
+// -on-stall-after, the last run is -on-stall-cooldown ago and has finished,
+// and it has run fewer than -on-stall-max times.
+func (h *stallHook) update(ctx context.Context, p catchup.Progress) {
+	if *on_stall_exec == "" || p.CaughtUp || p.StalledFor < *on_stall_after {
+		return
+	}
+	if !h.ran.IsZero() && time.Since(h.ran) < *on_stall_cooldown {
+		return
+	}
+	if h.done != nil {
+		select {
+		case <-h.done:
+		default:
+			return
+		}
+	}
+	if *on_stall_max > 0 && h.runs >= *on_stall_max {
+		if !h.gave {
+			slog.Warn("Node still stalled, but -on-stall-exec already ran the most times allowed", "runs", h.runs)
+			h.gave = true
+		}
+		return
+	}
+	h.runs++
+	h.ran = time.Now()
+	h.done = make(chan struct{})
+	slog.Warn("Node stalled, running -on-stall-exec", "command", *on_stall_exec, "stalled_for", formatETA(p.StalledFor), "run", h.runs)
+	go func(done chan struct{}, run int) {
+		defer close(done)
+		if err := runStallCommand(ctx, p); err != nil {
+			slog.Error(err.Error(), "run", run)
+		} else {
+			slog.Info("Ran -on-stall-exec", "command", *on_stall_exec, "run", run)
+		}
+	}(h.done, h.runs)
+}
+
+// runStallCommand runs -on-stall-exec with the shell, passing the stall in
+// SUI_CATCHUP_* environment variables.
+func runStallCommand(ctx context.Context, p catchup.Progress) error {
+	cmd := shellCommand(ctx, *on_stall_exec)
+	cmd.Env = append(os.Environ(),
+		"SUI_CATCHUP_NODE="+nodeName(),
+		fmt.Sprintf("SUI_CATCHUP_SYNCED=%d", int64(p.Synced)),
+		fmt.Sprintf("SUI_CATCHUP_LAG=%d", int64(p.Lag)),
+		fmt.Sprintf("SUI_CATCHUP_STALLED_SECONDS=%d", int64(p.StalledFor.Seconds())))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("-on-stall-exec failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// shellCommand returns command run by the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	statsd_addr   = runFlags.String("statsd-addr", "", "Send the lag, rate and caught-up state as StatsD gauges to this host:port over UDP on every interval")
+	statsd_prefix = runFlags.String("statsd-prefix", "sui_catchup", "Prefix of the StatsD gauge names")
+	statsd_tags   = runFlags.String("statsd-tags", "", "Comma-separated DogStatsD tags added to the StatsD gauges, e.g. node:validator-1,env:mainnet")
+)
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *statsd_addr == "" {
+			return nil, nil
+		}
+		return newStatsdSink(*statsd_addr, *statsd_prefix, append(splitList(*statsd_tags), joinTags(":")...))
+	})
+}
+
+// statsdSink sends the derived catch-up state as StatsD gauges, e.g.
+// sui_catchup.checkpoint_lag:1200|g.
+type statsdSink struct {
+	conn    net.Conn
+	prefix  string
+	tags    string // DogStatsD suffix, e.g. |#node:validator-1
+	lastErr string
+}
+
+func newStatsdSink(addr, prefix string, tags []string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -statsd-addr: %v", err)
+	}
+	s := &statsdSink{conn: conn, prefix: prefix}
+	if len(tags) > 0 {
+		s.tags = "|#" + strings.Join(tags, ",")
+	}
+	return s, nil
+}
+
+// update sends the gauges of p in a single packet. Like pusher.push, it
+// returns an error only when it differs from the previous update's.
+func (s *statsdSink) update(p catchup.Progress) error {
+	if p.Err != nil {
+		return nil
+	}
+	caughtUp := 0.0
+	if p.CaughtUp {
+		caughtUp = 1
+	}
+	var b bytes.Buffer
+	for _, g := range []struct {
+		name  string
+		value float64
+	}{
+		{"checkpoint_lag", p.Lag},
+		{"catchup_rate", p.Rate},
+		{"caught_up", caughtUp},
+	} {
+		// A signed value would be taken as a change to the gauge, so a
+		// negative one is sent as a change from 0.
+		if g.value < 0 {
+			_, _ = fmt.Fprintf(&b, "%s.%s:0|g%s\n", s.prefix, g.name, s.tags)
+		}
+		_, _ = fmt.Fprintf(&b, "%s.%s:%s|g%s\n", s.prefix, g.name, strconv.FormatFloat(g.value, 'f', -1, 64), s.tags)
+	}
+	_, err := s.conn.Write(b.Bytes())
+	if err == nil {
+		s.lastErr = ""
+		return nil
+	}
+	if err.Error() == s.lastErr {
+		return nil
+	}
+	s.lastErr = err.Error()
+	return fmt.Errorf("sending StatsD gauges failed: %v", err)
+}
+
+func (s *statsdSink) Close() error {
+	return s.conn.Close()
+}
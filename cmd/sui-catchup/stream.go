@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamBuffer is the number of events buffered per subscriber, beyond
+// which events are dropped for a subscriber that does not keep up.
+const streamBuffer = 16
+
+// streamKeepalive is how often a comment is sent to idle subscribers, so
+// that proxies do not close the connection.
+const streamKeepalive = 30 * time.Second
+
+// streamEvent is a Server-Sent Event.
+type streamEvent struct {
+	name string
+	data []byte
+}
+
+// broadcaster streams events to the subscribers of /events as Server-Sent
+// Events.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan streamEvent]bool
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subs: map[chan streamEvent]bool{}}
+}
+
+// publish sends v as JSON to every subscriber as an event of the given name.
+func (b *broadcaster) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub <- streamEvent{name, data}:
+		default:
+		}
+	}
+}
+
+func (b *broadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sub := make(chan streamEvent, streamBuffer)
+	b.mu.Lock()
+	b.subs[sub] = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, sub)
+		b.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev := <-sub:
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var summary_json = runFlags.String("summary-json", "", "Write a JSON summary of the session to this file on exit, or to standard output instead of the summary with -")
+
+// session accumulates what is reported in the summary printed on exit.
+type session struct {
+	start  time.Time
+	first  catchup.Progress // first successful scrape
+	last   catchup.Progress // last successful scrape
+	errors int              // failed scrapes
+	// skipped is how far the synced checkpoint went back or jumped
+	// ahead, which is not counted as synced.
+	skipped float64
+	// rates are the sync rates between successful scrapes, as averaged in
+	// the summary.
+	rates []float64
+}
+
+func newSession() *session {
+	return &session{start: time.Now()}
+}
+
+func (s *session) observe(p catchup.Progress) {
+	if p.Err != nil {
+		s.errors++
+		return
+	}
+	// Checkpoints are only counted from the end of a snapshot restore.
+	if s.first.Time.IsZero() || s.first.Snapshot != nil && !s.first.Snapshot.Done {
+		s.first = p
+	} else if p.Regressed || p.Jumped {
+		s.skipped += p.Synced - p.PreviousSynced
+	} else if dt := p.Time.Sub(s.last.Time).Seconds(); dt > 0 {
+		synced := p.Synced - s.last.Synced
+		if p.LagExpr != "" {
+			synced = s.last.Lag - p.Lag
+		}
+		s.rates = append(s.rates, synced/dt)
+	}
+	s.last = p
+}
+
+// synced returns the number of checkpoints synced during the session and
+// their average rate.
+func (s *session) synced() (synced, rate float64) {
+	synced = s.last.Synced - s.first.Synced - s.skipped
+	if s.last.LagExpr != "" {
+		// There are no watermarks, only the lag.
+		synced = s.first.Lag - s.last.Lag
+	}
+	if d := s.last.Time.Sub(s.first.Time); d > 0 {
+		rate = synced / d.Seconds()
+	}
+	return synced, rate
+}
+
+// print writes the summary, e.g.
+//
+//	Elapsed 2h11m, synced 118200 checkpoints (15/s on average), 0 checkpoints behind, 3 failed scrapes
+//	Rate min 2/s, median 15/s, p90 21/s, max 48/s
+//	Node restarted 2 times during this session
+func (s *session) print(w io.Writer) {
+	elapsed := time.Since(s.start)
+	if s.first.Time.IsZero() {
+		_, _ = fmt.Fprintf(w, "Elapsed %s, no successful scrapes, %d failed scrapes\n", formatETA(elapsed), s.errors)
+		return
+	}
+	synced, rate := s.synced()
+	_, _ = fmt.Fprintf(w, "Elapsed %s, synced %s checkpoints (%s/s on average), %s checkpoints behind, %d failed scrapes\n",
+		formatETA(elapsed), formatCount(synced), formatPerSecond(rate), formatCount(s.last.Lag), s.errors)
+	if len(s.rates) > 0 {
+		rates := append([]float64(nil), s.rates...)
+		sort.Float64s(rates)
+		_, _ = fmt.Fprintf(w, "Rate min %s/s, median %s/s, p90 %s/s, max %s/s\n",
+			formatPerSecond(rates[0]), formatPerSecond(percentile(rates, 0.5)), formatPerSecond(percentile(rates, 0.9)), formatPerSecond(rates[len(rates)-1]))
+	}
+	if n := s.last.Restarts; n > 0 {
+		_, _ = fmt.Fprintf(w, "Node restarted %d times during this session\n", n)
+	}
+}
+
+// printOutcome writes the single line printed by -quiet, e.g.
+//
+//	Caught up at checkpoint 208200 after 2h11m
+//	node did not catch up within 1h0m0s, 1200 checkpoints behind
+//
+// err is the error the watcher stopped with, if any.
+func (s *session) printOutcome(w io.Writer, err error) {
+	elapsed := formatETA(time.Since(s.start))
+	switch {
+	case err == nil:
+		_, _ = fmt.Fprintf(w, "Caught up at checkpoint %d after %s\n", int64(s.last.Synced), elapsed)
+	case s.first.Time.IsZero():
+		_, _ = fmt.Fprintf(w, "%s, no successful scrapes after %s\n", redact(err.Error()), elapsed)
+	default:
+		_, _ = fmt.Fprintf(w, "%s, %d checkpoints behind after %s\n", redact(err.Error()), int64(s.last.Lag), elapsed)
+	}
+}
+
+// summaryReport is the summary written by -summary-json.
+type summaryReport struct {
+	Node            string            `json:"node"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	Status          string            `json:"status"`
+	ExitCode        int               `json:"exit_code"`
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	// The checkpoint fields are omitted without a successful scrape.
+	StartCheckpoint *int64   `json:"start_checkpoint,omitempty"`
+	EndCheckpoint   *int64   `json:"end_checkpoint,omitempty"`
+	KnownCheckpoint *int64   `json:"known_checkpoint,omitempty"`
+	Synced          int64    `json:"synced"`
+	AverageRate     float64  `json:"average_rate"`
+	Lag             *int64   `json:"lag,omitempty"`
+	Errors          int      `json:"errors"`
+	Restarts        int      `json:"restarts"`
+	Rates           *rateMix `json:"rates,omitempty"`
+}
+
+// rateMix summarizes the sync rates between scrapes.
+type rateMix struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	Max    float64 `json:"max"`
+}
+
+// writeJSON writes the summary as a JSON document to -summary-json, or to
+// standard output if it is "-", for the command exiting with code.
+func (s *session) writeJSON(path string, code int) error {
+	end := time.Now()
+	r := summaryReport{
+		Node:            nodeName(),
+		Tags:            tagMap(),
+		Status:          exitStatus(code),
+		ExitCode:        code,
+		Start:           s.start,
+		End:             end,
+		DurationSeconds: end.Sub(s.start).Seconds(),
+		Errors:          s.errors,
+	}
+	if !s.first.Time.IsZero() {
+		start, end, known, lag := int64(s.first.Synced), int64(s.last.Synced), int64(s.last.Known), int64(s.last.Lag)
+		r.StartCheckpoint, r.EndCheckpoint, r.KnownCheckpoint, r.Lag = &start, &end, &known, &lag
+		synced, rate := s.synced()
+		r.Synced, r.AverageRate = int64(synced), rate
+		r.Restarts = s.last.Restarts
+	}
+	if len(s.rates) > 0 {
+		rates := append([]float64(nil), s.rates...)
+		sort.Float64s(rates)
+		r.Rates = &rateMix{rates[0], percentile(rates, 0.5), percentile(rates, 0.9), rates[len(rates)-1]}
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("writing -summary-json failed: %v", err)
+	}
+	return nil
+}
+
+// percentile returns the p-th quantile, between 0 and 1, of sorted values,
+// interpolating between the nearest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	i := int(rank)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (rank-float64(i))*(sorted[i+1]-sorted[i])
+}
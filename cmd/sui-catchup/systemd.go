@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		s, err := newSystemd()
+		if s == nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// systemd reports readiness and status to the service manager through the
+// sd_notify protocol when run as a Type=notify unit, so that the unit only
+// becomes active once the node has caught up and `systemctl status` shows the
+// progress.
+type systemd struct {
+	conn  *net.UnixConn
+	ready bool
+}
+
+// newSystemd connects to $NOTIFY_SOCKET, returning nil if it is unset.
+func newSystemd() (*systemd, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil, nil
+	}
+	if name[0] == '@' {
+		// Abstract namespace socket.
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to systemd notify socket failed: %v", err)
+	}
+	return &systemd{conn: conn}, nil
+}
+
+// update sends the status for p, and READY=1 once the node has caught up.
+func (s *systemd) update(p catchup.Progress) error {
+	var state string
+	switch {
+	case p.Err != nil:
+		state = fmt.Sprintf("STATUS=Error fetching metrics (attempt %d): %s", p.Errors, redact(p.Err.Error()))
+	case p.FellBehind:
+		state = fmt.Sprintf("STATUS=Fell behind, %d checkpoints behind (%s)", int64(p.Lag), formatRate(p.Rate, p.ETA))
+	case s.ready || p.CaughtUp:
+		state = fmt.Sprintf("STATUS=Caught up, %d checkpoints behind", int64(p.Lag))
+	default:
+		state = fmt.Sprintf("STATUS=Catching up, %d checkpoints behind (%s)", int64(p.Lag), formatRate(p.Rate, 0)+formatCompletion(p.ETA))
+	}
+	if p.CaughtUp && !s.ready {
+		s.ready = true
+		state = "READY=1\n" + state
+	}
+	if _, err := s.conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("notifying systemd failed: %v", err)
+	}
+	return nil
+}
+
+func (s *systemd) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	tag_hostname = reportFlags.Bool("tag-hostname", false, "Tag every metric, event and notification with host=<this machine's hostname>")
+	tag_region   = reportFlags.String("region", "", "Tag every metric, event and notification with region=<this>, e.g. eu-west-1")
+	tag_cluster  = reportFlags.String("cluster", "", "Tag every metric, event and notification with cluster=<this>, e.g. mainnet-rpc")
+	tag_pairs    tagFlag
+)
+
+func init() {
+	reportFlags.Var(&tag_pairs, "tag", "Tag every metric, event and notification with key=value, e.g. env=staging (repeatable)")
+}
+
+// tagName matches the names a tag can have, those of Prometheus labels, so
+// that every output accepts them.
+var tagName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// tag is a key=value pair describing where sui-catchup runs, so that the
+// outputs of many nodes can be told apart downstream.
+type tag struct {
+	name, value string
+}
+
+// tagFlag collects repeated -tag flags.
+type tagFlag []tag
+
+func (f *tagFlag) String() string {
+	var specs []string
+	for _, t := range *f {
+		specs = append(specs, t.name+"="+t.value)
+	}
+	return strings.Join(specs, ", ")
+}
+
+func (f *tagFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !tagName.MatchString(name) {
+		return fmt.Errorf("invalid tag %q, must be key=value with a key of letters, digits and underscores", value)
+	}
+	*f = append(*f, tag{name, strings.TrimSpace(v)})
+	return nil
+}
+
+// tags are the resolved tags, sorted by name.
+var tags []tag
+
+// resolveTags sets tags from -tag-hostname, -region, -cluster and -tag, the
+// latter overriding the former.
+func resolveTags() error {
+	byName := map[string]string{}
+	if *tag_hostname {
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("resolving -tag-hostname failed: %v", err)
+		}
+		byName["host"] = host
+	}
+	if *tag_region != "" {
+		byName["region"] = *tag_region
+	}
+	if *tag_cluster != "" {
+		byName["cluster"] = *tag_cluster
+	}
+	for _, t := range tag_pairs {
+		// The node and job already label every output, and names starting
+		// with __ are reserved by Prometheus.
+		if t.name == "node" || t.name == "job" || strings.HasPrefix(t.name, "__") {
+			return fmt.Errorf("invalid tag %q, the name is reserved", t.name)
+		}
+		byName[t.name] = t.value
+	}
+	tags = nil
+	for name, value := range byName {
+		tags = append(tags, tag{name, value})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].name < tags[j].name })
+	return nil
+}
+
+// tagMap returns the tags as a map, for JSON outputs, or nil without any.
+func tagMap() map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[t.name] = t.value
+	}
+	return m
+}
+
+// joinTags returns the tags as name, sep and value, e.g. region:eu-west-1 for
+// DogStatsD and Grafana with a colon.
+func joinTags(sep string) []string {
+	var list []string
+	for _, t := range tags {
+		list = append(list, t.name+sep+t.value)
+	}
+	return list
+}
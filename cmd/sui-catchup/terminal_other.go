@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableVirtualTerminal reports whether the terminal f writes to understands
+// ANSI escape sequences, which terminals outside Windows always do.
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}
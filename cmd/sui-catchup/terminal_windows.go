@@ -0,0 +1,32 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"github.com/mattn/go-isatty"
+	"golang.org/x/sys/windows"
+)
+
+// enableVirtualTerminal turns on the interpretation of ANSI escape sequences
+// by the console f writes to, available from Windows 10 on. It reports false
+// if the console does not support them, e.g. the legacy console of older
+// Windows versions, where colors and cursor movement would show up as
+// garbage.
+func enableVirtualTerminal(f *os.File) bool {
+	// MSYS2 and Cygwin terminals are pipes to a terminal emulator that
+	// understands escape sequences itself.
+	if isatty.IsCygwinTerminal(f.Fd()) {
+		return true
+	}
+	handle := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	ca_file              = transportFlags.String("ca-file", "", "PEM file with the CA certificates to verify the metrics endpoint and -fallback-addr with, other endpoints keeping the system's")
+	cert_file            = transportFlags.String("cert-file", "", "PEM file with a client certificate for mutual TLS")
+	key_file             = transportFlags.String("key-file", "", "PEM file with the client certificate's private key")
+	insecure_skip_verify = transportFlags.Bool("insecure-skip-verify", false, "Do not verify the TLS certificate of the metrics endpoint and -fallback-addr, other endpoints still being verified")
+	proxy_url            = transportFlags.String("proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL for all requests (default: from HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	keep_alive           = transportFlags.Bool("keep-alive", true, "Reuse connections between scrapes instead of dialing for every request, which saves a TLS handshake per scrape of a remote node (always off with -once)")
+	resolver             = transportFlags.String("resolver", "", "DNS server to resolve hostnames with as host:port, e.g. 10.0.0.2:53, instead of the system's")
+	re_resolve           = transportFlags.Bool("re-resolve", false, "Resolve the metrics hostname anew on every scrape, following DNS-based failover or a headless Kubernetes service, instead of keeping the connection alive")
+	redial_interval      = transportFlags.Duration("redial-interval", 5*time.Minute, "With -keep-alive, dial anew this often, e.g. to follow a load balancer or DNS change (0 reuses connections for as long as they stay open)")
+	eyeballs_delay       = transportFlags.Duration("happy-eyeballs-delay", 250*time.Millisecond, "For a hostname resolving to both IPv6 and IPv4 addresses, how long to wait for a connection on the first family before also dialing the other (0 uses Go's default of 300ms, a negative delay dials the families one after the other)")
+	max_request_rate     = transportFlags.Float64("max-request-rate", 0, "Most requests per second sent by all watchers together, e.g. of a fleet scraped through a shared proxy, delaying those beyond it (0 disables)")
+)
+
+// unixHost is the placeholder host that requests for a metrics endpoint on a
+// Unix domain socket are addressed to.
+const unixHost = "unix-socket.sui-catchup"
+
+// nodeDialer connects to the node the way its transport does, for its gRPC
+// API, which is spoken to over HTTP/2 rather than through the transport. wrap
+// adds the credentials and request limit of the transport.
+type nodeDialer struct {
+	dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	tlsConfig *tls.Config // nil for the defaults
+	wrap      func(http.RoundTripper) http.RoundTripper
+}
+
+// newTransport returns the transport used for all requests to the node and
+// to other endpoints, configured from the command line, the dialer of the
+// node, and the URL to scrape the node's metrics at through it. addr is the
+// metrics address as given on the command line; a unix:///path/to/socket
+// address is scraped at /metrics over that socket. fallbacks are the node's
+// further addresses, which share its TLS settings.
+func newTransport(addr string, fallbacks []string) (http.RoundTripper, nodeDialer, string, error) {
+	transport := catchup.DefaultTransport()
+	// A single scrape has no use for a connection afterwards, and only a
+	// new connection resolves the hostname again.
+	transport.DisableKeepAlives = !*keep_alive || *once || *re_resolve
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if *resolver != "" {
+		var err error
+		if dialer, err = newResolvingDialer(*resolver); err != nil {
+			return nil, nodeDialer{}, "", err
+		}
+	}
+	// Go races the addresses of the other IP family after FallbackDelay,
+	// as RFC 6555 does.
+	dialer.FallbackDelay = *eyeballs_delay
+	transport.DialContext = dialer.DialContext
+
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, nodeDialer{}, "", err
+	}
+
+	proxy, err := newProxy()
+	if err != nil {
+		return nil, nodeDialer{}, "", err
+	}
+
+	var socket string
+	if strings.HasPrefix(addr, "unix://") {
+		socket = strings.TrimPrefix(addr, "unix://")
+		addr = "http://" + unixHost + "/metrics"
+	}
+
+	var tunnel *sshTunnel
+	var node string // host:port of the node, if tunneled
+	if *ssh_host != "" {
+		if tunnel, err = newSSHTunnel(*ssh_host); err != nil {
+			return nil, nodeDialer{}, "", err
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, nodeDialer{}, "", fmt.Errorf("invalid metrics address %q: %v", addr, err)
+		}
+		node = net.JoinHostPort(u.Hostname(), portOf(u))
+	}
+
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if req.URL.Host == unixHost || (tunnel != nil && net.JoinHostPort(req.URL.Hostname(), portOf(req.URL)) == node) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		switch {
+		case socket != "" && address == unixHost+":80" && tunnel != nil:
+			return tunnel.dial(ctx, "unix", socket)
+		case socket != "" && address == unixHost+":80":
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		case tunnel != nil && address == node:
+			return tunnel.dial(ctx, network, address)
+		}
+		return dial(ctx, network, address)
+	}
+
+	hosts, err := nodeHosts(addr, fallbacks)
+	if err != nil {
+		return nil, nodeDialer{}, "", err
+	}
+	var rt http.RoundTripper = transport
+	if tlsConfig != nil {
+		// Only the node is verified with -ca-file or not at all, so that
+		// public endpoints such as the RPC tip, and the trusted endpoint
+		// checkpoints are verified against, keep the system's roots.
+		node := transport.Clone()
+		node.TLSClientConfig = tlsConfig
+		rt = &nodeTLSTransport{node: node, other: transport, hosts: hosts}
+	}
+
+	header, err := authHeader()
+	if err != nil {
+		return nil, nodeDialer{}, "", err
+	}
+	wrap := func(rt http.RoundTripper) http.RoundTripper {
+		rt = withAuth(rt, hosts, header)
+		if *max_request_rate > 0 {
+			rt = &limitedTransport{next: rt, limiter: requestLimiter()}
+		}
+		return rt
+	}
+	return wrap(rt), nodeDialer{dial: transport.DialContext, tlsConfig: tlsConfig, wrap: wrap}, addr, nil
+}
+
+// nodeHosts returns the hosts of the node's metrics address and its
+// fallbacks.
+func nodeHosts(addr string, fallbacks []string) (map[string]bool, error) {
+	hosts := map[string]bool{}
+	for _, a := range append([]string{addr}, fallbacks...) {
+		u, err := url.Parse(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics address %q: %v", a, err)
+		}
+		hosts[u.Host] = true
+	}
+	return hosts, nil
+}
+
+// nodeTLSTransport sends requests to the node's hosts with the TLS settings
+// of -ca-file, -cert-file and -insecure-skip-verify, and those to any other
+// host with the default ones.
+type nodeTLSTransport struct {
+	node, other *http.Transport
+	hosts       map[string]bool
+}
+
+func (t *nodeTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.hosts[req.URL.Host] {
+		return t.node.RoundTrip(req)
+	}
+	return t.other.RoundTrip(req)
+}
+
+func (t *nodeTLSTransport) CloseIdleConnections() {
+	t.node.CloseIdleConnections()
+	t.other.CloseIdleConnections()
+}
+
+// rateLimiter spaces events evenly at no more than a given rate.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is when the next event may happen.
+	next time.Time
+}
+
+// wait blocks until the next event may happen, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The -max-request-rate limiter is shared by every watcher, e.g. of a fleet.
+var (
+	limiterOnce sync.Once
+	limiter     *rateLimiter
+)
+
+func requestLimiter() *rateLimiter {
+	limiterOnce.Do(func() {
+		limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / *max_request_rate)}
+	})
+	return limiter
+}
+
+// limitedTransport delays requests beyond -max-request-rate.
+type limitedTransport struct {
+	next    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+func (t *limitedTransport) CloseIdleConnections() {
+	(&http.Client{Transport: t.next}).CloseIdleConnections()
+}
+
+// newResolvingDialer returns a dialer resolving hostnames with the DNS server
+// at addr, with the timeouts of http.DefaultTransport's.
+func newResolvingDialer(addr string) (*net.Dialer, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid -resolver %q, must be host:port", addr)
+	}
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}, nil
+}
+
+// portOf returns the port of u, or the default port of its scheme.
+func portOf(u *url.URL) string {
+	switch {
+	case u.Port() != "":
+		return u.Port()
+	case u.Scheme == "https":
+		return "443"
+	default:
+		return "80"
+	}
+}
+
+// newProxy returns the proxy function for -proxy, or one honoring the usual
+// environment variables if it is not given.
+func newProxy() (func(*http.Request) (*url.URL, error), error) {
+	if *proxy_url == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(*proxy_url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %v", *proxy_url, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	return http.ProxyURL(u), nil
+}
+
+// newTLSConfig returns the TLS settings of the node's endpoints, or nil if
+// none of -ca-file, -cert-file and -insecure-skip-verify is given.
+func newTLSConfig() (*tls.Config, error) {
+	if *ca_file == "" && *cert_file == "" && *key_file == "" && !*insecure_skip_verify {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: *insecure_skip_verify,
+	}
+	if *ca_file != "" {
+		pem, err := os.ReadFile(*ca_file)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file failed: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", *ca_file)
+		}
+	}
+	if (*cert_file == "") != (*key_file == "") {
+		return nil, errors.New("-cert-file and -key-file must be specified together")
+	}
+	if *cert_file != "" {
+		cert, err := tls.LoadX509KeyPair(*cert_file, *key_file)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate failed: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNodeTLSOnlyForNode(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	node := httptest.NewTLSServer(handler)
+	defer node.Close()
+	fallback := httptest.NewTLSServer(handler)
+	defer fallback.Close()
+	other := httptest.NewTLSServer(handler)
+	defer other.Close()
+
+	*insecure_skip_verify = true
+	defer func() { *insecure_skip_verify = false }()
+	transport, _, addr, err := newTransport(node.URL+"/metrics", []string{fallback.URL + "/metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := http.Client{Transport: transport}
+	tests := []struct {
+		url    string
+		verify bool
+	}{
+		{addr, false},
+		{fallback.URL + "/metrics", false},
+		// Other endpoints are still verified, which a self-signed
+		// certificate fails.
+		{other.URL, true},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(tt.url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if verified := err != nil; verified != tt.verify {
+			t.Errorf("GET %s: got error %v, want verification %v", tt.url, err, tt.verify)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var tui_mode = runFlags.Bool("tui", false, "Show a full-screen dashboard instead of the status line")
+
+// maxTUIErrors is how many recent scrape errors the dashboard keeps.
+const maxTUIErrors = 100
+
+// tui is the full-screen dashboard shown with -tui. It is safe for use by
+// the event loop while the application runs in its own goroutine.
+type tui struct {
+	app    *tview.Application
+	done   chan struct{}
+	nodes  *tview.Table
+	lag    *chart
+	rates  *chart
+	epoch  *tview.TextView
+	errors *tview.TextView
+	events *tview.TextView
+
+	// node is the -addr shown in the nodes table.
+	node string
+	// start is the first successful scrape, to show overall progress.
+	start catchup.Progress
+
+	mu     sync.Mutex
+	status []byte // last status written, printed once the dashboard closes
+
+	// prevLog is where errors and warnings went before the dashboard.
+	prevLog io.Writer
+}
+
+// newTUI takes over the terminal and starts the dashboard. quit is called
+// when the user presses q or Ctrl-C.
+func newTUI(node string, quit func()) (*tui, error) {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return nil, fmt.Errorf("starting dashboard failed: %v", err)
+	}
+	if err := screen.Init(); err != nil {
+		return nil, fmt.Errorf("starting dashboard failed: %v", err)
+	}
+
+	t := &tui{
+		app:    tview.NewApplication().SetScreen(screen),
+		done:   make(chan struct{}),
+		nodes:  tview.NewTable(),
+		lag:    newChart(),
+		rates:  newChart(),
+		epoch:  tview.NewTextView().SetDynamicColors(true),
+		errors: tview.NewTextView(),
+		events: tview.NewTextView(),
+		node:   node,
+	}
+	t.nodes.SetFixed(1, 0).SetBorder(true).SetTitle(" Nodes ")
+	for i, name := range []string{"NODE", "VERSION", "EPOCH", "KNOWN", "SYNCED", "LAG", "RATE", "ETA", "STATUS"} {
+		t.nodes.SetCell(0, i, tview.NewTableCell(name).SetTextColor(tcell.ColorYellow).SetExpansion(1))
+	}
+	t.lag.SetBorder(true).SetTitle(" Lag (checkpoints) ")
+	t.rates.SetBorder(true).SetTitle(" Rate (checkpoints/s) ")
+	t.epoch.SetBorder(true).SetTitle(" Progress ")
+	t.errors.SetMaxLines(maxTUIErrors).SetBorder(true).SetTitle(" Recent errors ")
+	t.events.SetBorder(true).SetTitle(" Events ")
+	for _, v := range []*tview.TextView{t.errors, t.events} {
+		v.SetChangedFunc(func() { t.app.Draw() })
+	}
+
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(t.nodes, 4, 0, false).
+		AddItem(tview.NewFlex().
+			AddItem(t.lag, 0, 1, false).
+			AddItem(t.rates, 0, 1, false), 0, 2, false).
+		AddItem(tview.NewFlex().
+			AddItem(t.epoch, 0, 1, false).
+			AddItem(t.errors, 0, 2, false), 0, 1, false).
+		AddItem(t.events, 6, 0, false)
+	t.app.SetRoot(root, true).SetInputCapture(func(ev *tcell.EventKey) *tcell.EventKey {
+		if ev.Key() == tcell.KeyCtrlC || ev.Rune() == 'q' {
+			quit()
+			return nil
+		}
+		return ev
+	})
+
+	go func() {
+		defer close(t.done)
+		if err := t.app.Run(); err != nil {
+			screen.Fini()
+		}
+	}()
+	return t, nil
+}
+
+// output returns an output writing log lines to the events pane.
+func (t *tui) output() *output {
+	// Errors and warnings, including failed scrapes, would garble the screen
+	// and are shown in the recent errors instead.
+	t.prevLog = logOutput.set(t.errors)
+	return &output{
+		status: writerFunc(t.setStatus),
+		log:    &timestampWriter{w: t.events},
+		stop:   t.stop,
+	}
+}
+
+func (t *tui) setStatus(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = append(t.status[:0], p...)
+	return len(p), nil
+}
+
+// stop closes the dashboard, restoring the terminal, and prints the last
+// status, which would otherwise be lost with the screen.
+func (t *tui) stop() {
+	t.app.Stop()
+	<-t.done
+	if t.prevLog != nil {
+		logOutput.set(t.prevLog)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = os.Stdout.Write(t.status)
+}
+
+// update shows p on the dashboard.
+func (t *tui) update(p catchup.Progress, in_sync bool) {
+	t.app.QueueUpdateDraw(func() {
+		if p.Err == nil {
+			if t.start.Time.IsZero() {
+				t.start = p
+			}
+			t.lag.add(p.Lag)
+			t.rates.add(p.InstantRate)
+		}
+
+		status := "catching up"
+		switch {
+		case p.Err != nil:
+			status = fmt.Sprintf("error (attempt %d)", p.Errors)
+		case p.FellBehind:
+			status = "fell behind"
+		case in_sync:
+			status = "in sync"
+		case p.CaughtUp:
+			status = "caught up"
+		}
+		eta := formatETA(p.ETA)
+		if p.ETA > 0 {
+			eta += " (" + formatClock(time.Now().Add(p.ETA)) + ")"
+		}
+		node := t.node
+		if id := formatIdentity(p.Identity); id != "" {
+			node += " (" + id + ")"
+		}
+		row := []string{
+			node,
+			p.Version,
+			strings.TrimSuffix(formatEpoch(p), ", "),
+			fmt.Sprint(int64(p.Known)),
+			fmt.Sprint(int64(p.Synced)),
+			fmt.Sprint(int64(p.Lag)),
+			fmt.Sprintf("%d/s", int64(p.Rate)),
+			eta,
+			status,
+		}
+		for i, text := range row {
+			t.nodes.SetCell(1, i, tview.NewTableCell(text).SetExpansion(1))
+		}
+
+		var b bytes.Buffer
+		if t.start.Known > t.start.Synced && p.Err == nil {
+			total := t.start.Known - t.start.Synced
+			done := p.Synced - t.start.Synced
+			_, _ = fmt.Fprintf(&b, "Checkpoints %d/%d\n%s\n\n", int64(done), int64(total), bar(done/total, 30))
+		}
+		if p.NetworkEpoch > 0 {
+			_, _ = fmt.Fprintf(&b, "Epoch %d of %d\n%s\n", int64(p.Epoch), int64(p.NetworkEpoch), bar(p.Epoch/p.NetworkEpoch, 30))
+		} else if p.Epoch > 0 {
+			_, _ = fmt.Fprintf(&b, "Epoch %d\n", int64(p.Epoch))
+		}
+		t.epoch.SetText(b.String())
+	})
+}
+
+// bar renders the fraction f, between 0 and 1, as a bar of the given width.
+func bar(f float64, width int) string {
+	if f < 0 {
+		f = 0
+	} else if f > 1 {
+		f = 1
+	}
+	n := int(f * float64(width))
+	return fmt.Sprintf("[green]%s[gray]%s[-] %d%%", strings.Repeat("█", n), strings.Repeat("░", width-n), int(f*100))
+}
+
+// chart is a primitive drawing recent values as vertical bars, newest on the
+// right, scaled between their minimum, or zero if lower, and their maximum.
+type chart struct {
+	*tview.Box
+	values []float64
+}
+
+func newChart() *chart {
+	return &chart{Box: tview.NewBox()}
+}
+
+// add appends v, keeping as many values as fit any reasonable terminal.
+func (c *chart) add(v float64) {
+	if len(c.values) == 1000 {
+		c.values = c.values[1:]
+	}
+	c.values = append(c.values, v)
+}
+
+func (c *chart) Draw(screen tcell.Screen) {
+	c.Box.DrawForSubclass(screen, c)
+	x, y, width, height := c.GetInnerRect()
+	if len(c.values) == 0 || width <= 0 || height <= 1 {
+		return
+	}
+	values := c.values
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	min, max := 0.0, values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	tview.Print(screen, fmt.Sprintf("max %d", int64(max)), x, y, width, tview.AlignLeft, tcell.ColorGray)
+	rows := height - 1
+	for i, v := range values {
+		// Height of the bar in eighths of a row.
+		eighths := rows * 8
+		if max > min {
+			eighths = int((v - min) / (max - min) * float64(rows*8))
+		}
+		col := x + width - len(values) + i
+		for r := 0; r < rows && eighths > 0; r++ {
+			n := eighths
+			if n > 8 {
+				n = 8
+			}
+			screen.SetContent(col, y+height-1-r, sparks[n-1], nil, tcell.StyleDefault.Foreground(tcell.ColorGreen))
+			eighths -= n
+		}
+	}
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+)
+
+var (
+	webhook_url           = runFlags.String("webhook-url", "", "POST lifecycle events to this URL")
+	webhook_template      = runFlags.String("webhook-template", "", "Go template for the webhook payload (default: a JSON object describing the event)")
+	webhook_template_file = runFlags.String("webhook-template-file", "", "Read the webhook payload template from this file")
+	webhook_content_type  = runFlags.String("webhook-content-type", "application/json", "Content type of the webhook payload")
+)
+
+// defaultWebhookTemplate renders an event as JSON.
+const defaultWebhookTemplate = `{"event":{{json .Kind}},"time":{{json .Time}},"node":{{json .Node}},{{with .Tags}}"tags":{{json .}},{{end}}"lag":{{.Lag}},` +
+	`"known":{{printf "%.0f" .Progress.Known}},"synced":{{printf "%.0f" .Progress.Synced}},` +
+	`"rate":{{printf "%.2f" .Progress.Rate}},"elapsed_seconds":{{printf "%.0f" .Elapsed.Seconds}},` +
+	`"message":{{json .String}}}`
+
+// webhookNotifier posts events rendered by a template, so arbitrary services
+// can receive them without a dedicated integration.
+type webhookNotifier struct {
+	url         string
+	contentType string
+	tmpl        *template.Template
+}
+
+// newWebhookNotifier returns a notifier for the webhook flags.
+func newWebhookNotifier() (*webhookNotifier, error) {
+	text := defaultWebhookTemplate
+	switch {
+	case *webhook_template != "" && *webhook_template_file != "":
+		return nil, fmt.Errorf("only one of -webhook-template and -webhook-template-file may be specified")
+	case *webhook_template != "":
+		text = *webhook_template
+	case *webhook_template_file != "":
+		b, err := os.ReadFile(*webhook_template_file)
+		if err != nil {
+			return nil, fmt.Errorf("reading webhook template failed: %v", err)
+		}
+		text = string(b)
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing webhook template failed: %v", err)
+	}
+	return &webhookNotifier{url: *webhook_url, contentType: *webhook_content_type, tmpl: tmpl}, nil
+}
+
+func (w *webhookNotifier) notify(ctx context.Context, ev event) error {
+	var body bytes.Buffer
+	if err := w.tmpl.Execute(&body, ev); err != nil {
+		return fmt.Errorf("webhook: rendering payload failed: %v", err)
+	}
+	if err := post(ctx, w.url, nil, w.contentType, body.Bytes()); err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	return nil
+}
+
+// toJSON is the template function json, which quotes values for JSON
+// payloads.
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/catchup"
+)
+
+var (
+	zabbix_server = runFlags.String("zabbix-server", "", "Send the lag, rate and status as trapper items to this Zabbix server or proxy, host[:port], on every interval")
+	zabbix_host   = runFlags.String("zabbix-host", "", "Host name of the items on -zabbix-server, as configured in Zabbix (default: -node-name)")
+	zabbix_prefix = runFlags.String("zabbix-key-prefix", "sui_catchup", "Prefix of the keys of the Zabbix items, e.g. sui_catchup.checkpoint_lag")
+)
+
+// zabbixTimeout bounds a request to the Zabbix server.
+const zabbixTimeout = 5 * time.Second
+
+// zabbixProcessed matches the counts in the info of a sender response, e.g.
+// "processed: 5; failed: 1; total: 6; seconds spent: 0.000055".
+var zabbixProcessed = regexp.MustCompile(`processed: (\d+); failed: (\d+); total: (\d+)`)
+
+func init() {
+	registerSink(func(*catchup.Watcher) (sink, error) {
+		if *zabbix_server == "" {
+			return nil, nil
+		}
+		return newZabbixSink(*zabbix_server, *zabbix_host, *zabbix_prefix), nil
+	})
+}
+
+// zabbixSink sends the derived catch-up state to a Zabbix server in the
+// sender protocol, a JSON request behind a ZBXD header, over a connection
+// per update as the server closes it after responding.
+type zabbixSink struct {
+	addr    string
+	host    string
+	prefix  string
+	lastErr string
+}
+
+func newZabbixSink(server, host, prefix string) *zabbixSink {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "10051")
+	}
+	if host == "" {
+		host = nodeName()
+	}
+	return &zabbixSink{addr: server, host: host, prefix: prefix}
+}
+
+// zabbixItem is a value of the sender protocol.
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+	NS    int    `json:"ns"`
+}
+
+// update sends the items of p. Like pusher.push, it returns an error only
+// when it differs from the previous update's.
+func (z *zabbixSink) update(p catchup.Progress) error {
+	err := z.send(p)
+	if err == nil {
+		z.lastErr = ""
+		return nil
+	}
+	if err.Error() == z.lastErr {
+		return nil
+	}
+	z.lastErr = err.Error()
+	return fmt.Errorf("sending to Zabbix failed: %v", err)
+}
+
+// items returns the items of p. A failed scrape only updates the status, so
+// that Zabbix' nodata() triggers see the numbers go stale.
+func (z *zabbixSink) items(p catchup.Progress) []zabbixItem {
+	at := p.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	item := func(key, value string) zabbixItem {
+		return zabbixItem{Host: z.host, Key: z.prefix + "." + key, Value: value, Clock: at.Unix(), NS: at.Nanosecond()}
+	}
+	number := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	status := "catching_up"
+	switch {
+	case p.Err != nil:
+		return []zabbixItem{item("status", "scrape_failed")}
+	case p.CaughtUp:
+		status = "caught_up"
+	case p.StalledFor >= 10**update_interval:
+		status = "stalled"
+	}
+	caughtUp := 0.0
+	if p.CaughtUp {
+		caughtUp = 1
+	}
+	return []zabbixItem{
+		item("status", status),
+		item("checkpoint_lag", number(p.Lag)),
+		item("execution_lag", number(p.ExecutionLag)),
+		item("catchup_rate", number(p.Rate)),
+		item("eta_seconds", number(p.ETA.Seconds())),
+		item("caught_up", number(caughtUp)),
+	}
+}
+
+func (z *zabbixSink) send(p catchup.Progress) error {
+	items := z.items(p)
+	body, err := json.Marshal(map[string]interface{}{"request": "sender data", "data": items, "clock": time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", z.addr, zabbixTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(zabbixTimeout))
+	if _, err := conn.Write(append(zabbixHeader(len(body)), body...)); err != nil {
+		return err
+	}
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("reading response failed: %v", err)
+	}
+	if string(header[:5]) != "ZBXD\x01" {
+		return errors.New("invalid response, not the Zabbix protocol")
+	}
+	n := binary.LittleEndian.Uint64(header[5:])
+	if n > 1<<20 {
+		return fmt.Errorf("invalid response of %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return fmt.Errorf("reading response failed: %v", err)
+	}
+	var resp struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return fmt.Errorf("decoding response failed: %v", err)
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("server responded %q: %s", resp.Response, resp.Info)
+	}
+	// Items the server does not know, e.g. for a mistyped host, are
+	// dropped with only their count in the info.
+	if m := zabbixProcessed.FindStringSubmatch(resp.Info); m != nil && m[2] != "0" {
+		return fmt.Errorf("%s of %s items failed, check that host %q has trapper items with the keys %s.*", m[2], m[3], z.host, z.prefix)
+	}
+	return nil
+}
+
+// zabbixHeader returns the header of a request of n bytes: the protocol,
+// its version and the length.
+func zabbixHeader(n int) []byte {
+	return binary.LittleEndian.AppendUint64([]byte("ZBXD\x01"), uint64(n))
+}
@@ -0,0 +1,450 @@
+// Package catchup watches a Sui node's Prometheus metrics and reports how far
+// the node is behind the network, so that programs can wait for a node to
+// catch up without shelling out to the sui-catchup binary.
+package catchup
+
+import (
+	"fmt"
+	"time"
+)
+
+// Default metric names exposed by sui-node. Other names used by past
+// releases are discovered automatically.
+const (
+	DefaultKnownMetric  = "highest_known_checkpoint"
+	DefaultSyncedMetric = "highest_synced_checkpoint"
+)
+
+// What a Watcher tracks, see Options.Track.
+const (
+	TrackCheckpoints  = "checkpoints"
+	TrackTransactions = "transactions"
+	TrackBoth         = "both"
+)
+
+// What a Watcher watches, see Options.Mode.
+const (
+	ModeNode            = "node"
+	ModeSnapshotRestore = "snapshot-restore"
+	ModeIndexer         = "indexer"
+	ModeGraphQL         = "graphql"
+	ModeArchive         = "archive"
+)
+
+// Where a Watcher reads the node's watermarks from, see Options.Source.
+const (
+	SourceMetrics = "metrics"
+	SourceGRPC    = "grpc"
+	SourceExec    = "exec"
+)
+
+// How the network tip is agreed on between several tip URLs, see
+// Options.TipQuorum.
+const (
+	TipMedian = "median"
+	TipMax    = "max"
+)
+
+// How the current value of a Pair compares to its target once reached, see
+// Pair.Op.
+const (
+	PairAtLeast = ">="
+	PairAbove   = ">"
+	PairAtMost  = "<="
+	PairBelow   = "<"
+)
+
+// Pair is a condition waited for besides catching up, see Options.Pairs:
+// an expression over the node's metrics reaching another, e.g. the synced
+// checkpoint reaching the pruning watermark plus a margin.
+type Pair struct {
+	// Name labels the pair in the output.
+	Name string
+
+	// Current and Target are expressions over the node's metrics, with the
+	// syntax of Options.LagExpr.
+	Current string
+	Target  string
+
+	// Op is how Current compares to Target once the condition is met, one
+	// of the Pair* constants. Defaults to PairAtLeast.
+	Op string
+}
+
+// PairProgress is the state of a Pair at a scrape.
+type PairProgress struct {
+	Name string
+	Op   string
+
+	// Gap is the distance left until the condition is met: Target -
+	// Current for PairAtLeast and PairAbove, Current - Target for the
+	// others, with Target and Current their values.
+	Gap
+
+	// Reached is set when the condition is met.
+	Reached bool
+
+	// Err is set if either expression could not be evaluated.
+	Err error
+}
+
+// Gap is the distance between a watermark and the target it is catching up
+// to, such as the executed and known transaction sequence numbers.
+type Gap struct {
+	Target  float64
+	Current float64
+
+	// Lag is Target - Current.
+	Lag float64
+
+	// Rate and InstantRate are the smoothed and unsmoothed rates at which
+	// Lag shrinks per second, and ETA the estimated time until it is closed
+	// at Rate, as for the checkpoint fields of Progress.
+	Rate        float64
+	InstantRate float64
+	ETA         time.Duration
+}
+
+// Observation is the watermarks of a node at some time, see Watcher.Seed.
+type Observation struct {
+	Time   time.Time
+	Known  float64
+	Synced float64
+}
+
+// Snapshot is the progress of a formal snapshot restore.
+type Snapshot struct {
+	// Partitions is the gap between the partitions of the snapshot and
+	// those downloaded so far.
+	Partitions Gap
+
+	// Objects and Bytes are the objects and bytes downloaded so far, and
+	// Throughput how many bytes per second are downloaded, smoothed like
+	// Progress.Rate.
+	Objects    float64
+	Bytes      float64
+	Throughput float64
+
+	// Done is set once every partition has been downloaded.
+	Done bool
+}
+
+// Process is the resource usage of the node's process, for telling whether a
+// slow catch-up is bound by CPU, memory or I/O.
+type Process struct {
+	// CPU is how many CPU cores the process keeps busy, by the growth of
+	// its CPU time smoothed like Progress.Rate, zero until the second
+	// scrape.
+	CPU float64
+
+	// RSS is the process' resident memory in bytes.
+	RSS float64
+
+	// OpenFDs is the number of open file descriptors and MaxFDs their
+	// limit, zero if not exposed.
+	OpenFDs float64
+	MaxFDs  float64
+
+	// QueueDepth is the number of tasks waiting in the Tokio runtime's
+	// global queue, only set when HasQueueDepth is.
+	QueueDepth    float64
+	HasQueueDepth bool
+}
+
+// Identity names the node by the labels of its uptime or build info metric,
+// for telling many watched nodes apart. Either field is empty when the node
+// does not expose it.
+type Identity struct {
+	// Validator is the value of a validator, validator_name or host label.
+	Validator string
+
+	// Network is the value of a network or chain label, or the network
+	// named by the chain_identifier label, e.g. mainnet.
+	Network string
+}
+
+// Progress is a single observation of the node's catch-up state. A Progress
+// with a non-nil Err reports a failed scrape; the checkpoint fields then hold
+// the values from the last successful scrape.
+type Progress struct {
+	Time time.Time
+
+	// Known is the highest checkpoint the node knows the network has
+	// produced, Synced is the highest checkpoint it has synced.
+	Known  float64
+	Synced float64
+
+	// Executed is the highest checkpoint the node has executed, and
+	// ExecutionLag is Known - Executed. Both are zero if the node does not
+	// expose its executed checkpoint.
+	Executed     float64
+	ExecutionLag float64
+
+	// Epoch is the node's current epoch and NetworkEpoch the network's, as
+	// reported by Options.TipURL or Options.ReferenceAddr. Either is zero
+	// when unknown.
+	Epoch        float64
+	NetworkEpoch float64
+
+	// EpochChanged is set when the node has moved to a later epoch since
+	// the previous observation. EpochDuration is then how long it spent in
+	// the epochs it left, from when it was seen entering them, or zero if
+	// it was already in them when watching started. Per-epoch timing tells
+	// how far a restore still has to go.
+	EpochChanged  bool
+	EpochDuration time.Duration
+
+	// Regressed is set when Synced went back since the previous successful
+	// observation, e.g. because the node restarted from an older snapshot
+	// or with its database wiped, and Jumped when it advanced faster than
+	// Options.MaxSyncRate, e.g. because it restored a formal snapshot.
+	// PreviousSynced is then the Synced it moved from. The rates start over
+	// from such an observation rather than averaging across it.
+	Regressed      bool
+	Jumped         bool
+	PreviousSynced float64
+
+	// Protocol is the highest protocol version the node's binary supports
+	// and NetworkProtocol the network's current one, as reported by
+	// Options.TipURL or Options.ReferenceAddr. Either is zero when unknown.
+	// A node whose Protocol is below NetworkProtocol cannot execute past the
+	// epoch that upgraded to it.
+	Protocol        float64
+	NetworkProtocol float64
+
+	// KnownMetric, SyncedMetric and ExecutedMetric are the names of the
+	// metrics the watermarks were read from. KnownMetric is empty when the
+	// network tip comes from elsewhere, ExecutedMetric when the node does not
+	// expose its executed checkpoint.
+	KnownMetric    string
+	SyncedMetric   string
+	ExecutedMetric string
+
+	// NotModified is set when the node, or a caching proxy in front of
+	// it, answered that its metrics page had not changed since the previous
+	// scrape, which was then not parsed again.
+	NotModified bool
+
+	// ParseWarnings is the number of lines of the node's metrics skipped
+	// by Options.TolerantParsing because they failed to parse.
+	ParseWarnings int
+
+	// Uptime is how long the node's process has been running, by its
+	// uptime or process start time metric, zero if it exposes neither.
+	// Restarted is set when the node restarted since the previous
+	// successful observation, and Restarts counts the restarts seen since
+	// watching started: a crash-looping node otherwise only looks like a
+	// slow, jittery catch-up.
+	Uptime    time.Duration
+	Restarted bool
+	Restarts  int
+
+	// Peers is the number of peers the node is connected to, and
+	// PeersMetric the name of the metric it was read from. PeersMetric is
+	// empty when the node does not expose its peer count.
+	Peers       float64
+	PeersMetric string
+
+	// DBSize is the size of the node's database in bytes and DBGrowth how
+	// many bytes per second it grows by, smoothed like Rate. DBSizeMetric is
+	// the name of the metric the size was read from, empty when the node
+	// does not expose it.
+	DBSize       float64
+	DBGrowth     float64
+	DBSizeMetric string
+
+	// Pruned is the highest checkpoint the node has pruned, Retained the
+	// number of checkpoints it still holds, Synced - Pruned, and
+	// RetainedGrowth how many checkpoints per second the retained window
+	// grows by, negative while it shrinks. PrunedObjects is the number of
+	// objects pruned, if exposed. PrunedMetric is the name of the metric
+	// Pruned was read from, empty when the node does not expose it.
+	Pruned         float64
+	Retained       float64
+	RetainedGrowth float64
+	PrunedObjects  float64
+	PrunedMetric   string
+
+	// FromArchive is set when the node fetched checkpoints from the archive
+	// fallback rather than its peers since the previous scrape.
+	// ArchiveMetric is the name of the metric that tells, empty when the
+	// node does not expose it.
+	FromArchive   bool
+	ArchiveMetric string
+
+	// LagExpr is Options.LagExpr. When it is set, Lag is its value and the
+	// checkpoint fields are zero.
+	LagExpr string
+
+	// Lag is Known - Synced, the state-sync lag.
+	Lag float64
+
+	// Rate is how many checkpoints per second the lag is shrinking by,
+	// smoothed over recent observations. It is negative when the node is
+	// falling behind. InstantRate is the unsmoothed rate since the previous
+	// observation.
+	Rate        float64
+	InstantRate float64
+
+	// SyncRate is how many checkpoints per second the node syncs, and
+	// NetworkRate how many the network produces, by the growth of Synced
+	// and Known smoothed over Options.RateSmoothing. Rate is roughly their
+	// difference: a node syncing at 30/s catches up at 25/s while the
+	// network is quiet at 5/s, but at only 5/s under load at 25/s. Both are
+	// zero with Options.LagExpr.
+	SyncRate    float64
+	NetworkRate float64
+
+	// TPS is how many transactions per second the node executes, by the
+	// growth of its executed transactions metric smoothed over
+	// Options.RateSmoothing, if it exposes one. Execution throughput shows
+	// whether the disk and CPU keep up. ExecutedTxMetric is that metric.
+	TPS              float64
+	ExecutedTxMetric string
+
+	// ClosureRate is SyncRate - NetworkRate, the net rate at which the node
+	// closes in on the tip. While it is negative, the node never catches
+	// up at its current speed.
+	ClosureRate float64
+
+	// ETA is the estimated time until the node has caught up at the current
+	// Rate, or zero if it is not catching up.
+	ETA time.Duration
+
+	// Transactions is the gap between the highest known and highest
+	// executed transaction, if transactions are tracked.
+	Transactions *Gap
+
+	// Execution is the gap between the synced and the executed checkpoint,
+	// if the node exposes the latter. State sync and execution can diverge
+	// widely, e.g. after a restore, so it has its own rate and ETA.
+	Execution *Gap
+
+	// Snapshot is the progress of the formal snapshot restore preceding
+	// the checkpoint catch-up in ModeSnapshotRestore, while the node exposes
+	// it.
+	Snapshot *Snapshot
+
+	// Consensus is the gap between the highest consensus round the
+	// validator has received and the last round it has committed, if
+	// Options.Consensus is set.
+	Consensus *Gap
+
+	// Pairs are the states of Options.Pairs, in order.
+	Pairs []PairProgress
+
+	// Process is the resource usage of the node's process, if it exposes
+	// the standard process metrics.
+	Process *Process
+
+	// Version is the version of the node's binary, from the version label
+	// of its uptime or build info metric, if it exposes it.
+	Version string
+
+	// Identity is who the node is, as far as its metrics tell.
+	Identity Identity
+
+	// Health is the result of probing Options.HealthURL, if set.
+	Health *Health
+
+	// Verification is the comparison of the node's latest checkpoint with
+	// the trusted endpoint, once the node has caught up with
+	// Options.VerifyURL set.
+	Verification *Verification
+
+	// CaughtUp is set once the node has synced everything it knows about,
+	// give or take Options.CaughtUpLag, is healthy with
+	// Options.RequireHealthy and is on the trusted endpoint's chain with
+	// Options.VerifyURL.
+	CaughtUp bool
+
+	// FellBehind is set when the node had caught up earlier but its lag now
+	// exceeds Options.BehindThreshold.
+	FellBehind bool
+
+	// Stalled is set when Synced has not advanced for Options.StallTimeout.
+	// StalledFor is how long Synced has not advanced.
+	Stalled    bool
+	StalledFor time.Duration
+
+	// AtEpochEnd is set while Synced has not advanced and is the last
+	// checkpoint of its epoch, as told by Options.TipURL or
+	// Options.VerifyURL. A node stuck there cannot start the next epoch,
+	// typically for lack of its committee or of support for its protocol
+	// version.
+	AtEpochEnd bool
+
+	// ScrapeDuration is how long the scrape took, including any requests to
+	// the tip or reference endpoints.
+	ScrapeDuration time.Duration
+
+	// Endpoint is the node's endpoint that was scraped: Options.Addr, or
+	// one of Options.FallbackAddrs after failing over to it, without any
+	// password. It is empty when reading from Options.PrometheusURL.
+	Endpoint string
+
+	// RemoteAddr is the IP address and port that answered the last
+	// successful scrape of Endpoint, telling which of the addresses of a
+	// hostname resolving to several served it.
+	RemoteAddr string
+
+	// Err is the scrape error, if any, and Errors the number of consecutive
+	// failed scrapes including this one. RetryAt is when the next scrape is
+	// attempted after backing off.
+	Err     error
+	Errors  int
+	RetryAt time.Time
+}
+
+// StallError is returned by Watcher.Wait when the synced checkpoint stopped
+// advancing for longer than Options.StallTimeout.
+type StallError struct {
+	Synced float64
+	Known  float64
+	For    time.Duration
+	// AtEpochEnd is Progress.AtEpochEnd, and Epoch the epoch Synced ends.
+	AtEpochEnd bool
+	Epoch      float64
+}
+
+func (e *StallError) Error() string {
+	if e.AtEpochEnd {
+		return fmt.Sprintf("node stalled at the end of epoch %d: synced checkpoint stuck at %d, the epoch's last, for %s, %d checkpoints behind",
+			int64(e.Epoch), int64(e.Synced), e.For.Round(time.Second), int64(e.Known-e.Synced))
+	}
+	return fmt.Sprintf("node stalled: synced checkpoint stuck at %d for %s, %d checkpoints behind",
+		int64(e.Synced), e.For.Round(time.Second), int64(e.Known-e.Synced))
+}
+
+// ScrapeError is returned by Watcher.Wait when Options.MaxErrors consecutive
+// scrapes failed. Err is the last scrape's error.
+type ScrapeError struct {
+	Errors int
+	Err    error
+}
+
+func (e *ScrapeError) Error() string {
+	return fmt.Sprintf("giving up after %d failed scrapes: %v", e.Errors, e.Err)
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfterError is the error of a scrape rejected with 429 Too Many
+// Requests, or with any status and a Retry-After header, by the node or a
+// proxy in front of it. After is how long the response asked to wait, zero
+// if it did not say; the next scrape is then attempted no sooner instead of
+// after the usual backoff.
+type RetryAfterError struct {
+	URL    string
+	Status string
+	After  time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.After > 0 {
+		return fmt.Sprintf("GET request for URL %q returned HTTP status %s, retry after %s", e.URL, e.Status, e.After)
+	}
+	return fmt.Sprintf("GET request for URL %q returned HTTP status %s", e.URL, e.Status)
+}
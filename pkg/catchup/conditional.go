@@ -0,0 +1,51 @@
+package catchup
+
+import (
+	"net/http"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pageCache holds the validators a URL last answered with and the families
+// of that page, so that scrapes ask for it conditionally and skip parsing it
+// again when it has not changed. Many watchers polling through a shared
+// caching proxy then cost it little more than one.
+type pageCache struct {
+	etag, lastModified string
+	families           map[string]*dto.MetricFamily
+	warnings           int
+}
+
+// update records the page last read, with the families parsed from it, or
+// forgets it if it failed to parse or carried no validators.
+func (c *pageCache) update(header http.Header, families map[string]*dto.MetricFamily, warnings int, err error) {
+	c.etag, c.lastModified = header.Get("ETag"), header.Get("Last-Modified")
+	c.families, c.warnings = nil, 0
+	if err == nil && (c.etag != "" || c.lastModified != "") {
+		c.families, c.warnings = cloneFamilies(families), warnings
+	}
+}
+
+// pageCache returns the cache of the pages of addr.
+func (w *Watcher) pageCache(addr string) *pageCache {
+	if w.pages == nil {
+		w.pages = map[string]*pageCache{}
+	}
+	c := w.pages[addr]
+	if c == nil {
+		c = &pageCache{}
+		w.pages[addr] = c
+	}
+	return c
+}
+
+// cloneFamilies copies families as far as they are modified once returned:
+// the map, to which patterns add, and the series of each family, which
+// selecting series replaces.
+func cloneFamilies(families map[string]*dto.MetricFamily) map[string]*dto.MetricFamily {
+	clone := make(map[string]*dto.MetricFamily, len(families))
+	for name, f := range families {
+		clone[name] = &dto.MetricFamily{Name: f.Name, Help: f.Help, Type: f.Type, Metric: append([]*dto.Metric(nil), f.Metric...)}
+	}
+	return clone
+}
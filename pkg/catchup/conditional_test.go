@@ -0,0 +1,72 @@
+package catchup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageCacheUpdate(t *testing.T) {
+	families := parsePage(t, testPage)
+	tests := []struct {
+		name   string
+		header http.Header
+		err    error
+		cached bool
+	}{
+		{"etag", http.Header{"Etag": {`"v1"`}}, nil, true},
+		{"last modified", http.Header{"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, nil, true},
+		{"no validators", http.Header{}, nil, false},
+		{"parse error", http.Header{"Etag": {`"v1"`}}, errors.New("bad page"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := pageCache{families: families}
+			c.update(tt.header, families, 2, tt.err)
+			if cached := c.families != nil; cached != tt.cached {
+				t.Fatalf("got cached %v, want %v", cached, tt.cached)
+			}
+			if tt.cached && c.warnings != 2 {
+				t.Errorf("got %d warnings, want 2", c.warnings)
+			}
+		})
+	}
+}
+
+func TestFetchNotModified(t *testing.T) {
+	var requests, modified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		modified++
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer server.Close()
+
+	var cache pageCache
+	for i := 0; i < 3; i++ {
+		p := metricsParser{cache: &cache}
+		families, err := p.fetch(context.Background(), server.URL, http.DefaultTransport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.notModified != (i > 0) {
+			t.Errorf("scrape %d: got not modified %v", i, p.notModified)
+		}
+		if got := value(families, "highest_known_checkpoint"); got != 1200 {
+			t.Errorf("scrape %d: got known %v, want 1200", i, got)
+		}
+		// What is returned must not change the cached page.
+		delete(families, "highest_known_checkpoint")
+		families["current_epoch"].Metric = nil
+	}
+	if requests != 3 || modified != 1 {
+		t.Errorf("got %d requests of which %d served the page, want 3 and 1", requests, modified)
+	}
+}
@@ -0,0 +1,283 @@
+package catchup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Names under which sui-node releases have exposed the checkpoint and
+// consensus watermarks, in order of preference.
+var (
+	knownAliases = []string{
+		DefaultKnownMetric,
+		"state_sync_highest_known_checkpoint",
+	}
+	syncedAliases = []string{
+		DefaultSyncedMetric,
+		"state_sync_highest_synced_checkpoint",
+		"last_executed_checkpoint",
+		"checkpoint_executor_last_executed_checkpoint",
+	}
+	// sui-indexer exposes the tip of the fullnode it reads from and the
+	// latest checkpoint it has indexed.
+	indexerKnownAliases = []string{
+		"latest_fullnode_checkpoint_sequence_number",
+	}
+	indexerSyncedAliases = []string{
+		"latest_tx_checkpoint_sequence_number",
+		"latest_indexer_checkpoint_sequence_number",
+		"latest_indexer_object_checkpoint_sequence_number",
+	}
+	// The archival writer of archival fullnodes exposes the last checkpoint
+	// it has uploaded to the archive's object store.
+	archiveUploadedAliases = []string{
+		"latest_checkpoint_archived",
+		"archive_latest_checkpoint_uploaded",
+		"last_uploaded_checkpoint",
+	}
+	executedAliases = []string{
+		"highest_executed_checkpoint",
+		"last_executed_checkpoint",
+		"checkpoint_executor_last_executed_checkpoint",
+	}
+	epochAliases = []string{
+		"current_epoch",
+		"epoch",
+	}
+	knownTxAliases = []string{
+		"highest_known_transaction",
+		"network_total_transactions",
+	}
+	executedTxAliases = []string{
+		"highest_executed_transaction",
+		"total_transactions_executed",
+	}
+	receivedRoundAliases = []string{
+		"highest_received_round",
+		"highest_accepted_round",
+	}
+	committedRoundAliases = []string{
+		"last_committed_round",
+		"last_committed_leader_round",
+	}
+	dbSizeAliases = []string{
+		"rocksdb_total_sst_files_size",
+		"rocksdb_live_sst_files_size",
+	}
+	prunedAliases = []string{
+		"last_pruned_checkpoint",
+		"last_pruned_effects_checkpoint",
+	}
+	prunedObjectsAliases = []string{
+		"num_pruned_objects",
+	}
+	archiveAliases = []string{
+		"state_sync_archive_checkpoints_synced",
+		"archive_checkpoints_synced",
+		"checkpoints_synced_from_archive",
+	}
+	snapshotPartitionsAliases = []string{
+		"snapshot_restore_partitions_total",
+		"formal_snapshot_partitions_total",
+	}
+	snapshotDownloadedAliases = []string{
+		"snapshot_restore_partitions_downloaded",
+		"formal_snapshot_partitions_downloaded",
+	}
+	snapshotObjectsAliases = []string{
+		"snapshot_restore_objects_downloaded",
+		"formal_snapshot_objects_downloaded",
+	}
+	snapshotBytesAliases = []string{
+		"snapshot_restore_bytes_downloaded",
+		"formal_snapshot_bytes_downloaded",
+	}
+	peersAliases = []string{
+		"network_peers",
+		"connected_peers",
+	}
+	// The highest protocol version the binary supports, which must be at
+	// least the network's current one, and the current one.
+	supportedProtocolAliases = []string{
+		"max_supported_protocol_version",
+		"supported_protocol_version_max",
+	}
+	protocolAliases = []string{
+		"current_protocol_version",
+	}
+	// The process metrics of the Prometheus client libraries, and the
+	// depth of the Tokio runtime's global queue where it is exported.
+	processCPUAliases = []string{
+		"process_cpu_seconds_total",
+	}
+	processRSSAliases = []string{
+		"process_resident_memory_bytes",
+	}
+	processFDsAliases = []string{
+		"process_open_fds",
+	}
+	processMaxFDsAliases = []string{
+		"process_max_fds",
+	}
+	queueDepthAliases = []string{
+		"tokio_global_queue_depth",
+		"tokio_runtime_global_queue_depth",
+	}
+	// How long the node's process has been running, in seconds, and
+	// failing that when it started, in seconds since the Unix epoch.
+	uptimeAliases = []string{
+		"uptime",
+	}
+	startTimeAliases = []string{
+		"process_start_time_seconds",
+	}
+	// The binary's version is a label of these.
+	versionAliases = []string{
+		"uptime",
+		"build_info",
+	}
+)
+
+// metricName matches the names of Prometheus metrics. Names that do not
+// match it are taken as regular expressions.
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// metricPatterns compiles those of names that are regular expressions rather
+// than metric names, keyed by the expression.
+func metricPatterns(names ...string) (map[string]*regexp.Regexp, error) {
+	patterns := map[string]*regexp.Regexp{}
+	for _, name := range names {
+		if name == "" || metricName.MatchString(name) {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + name + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric name pattern %q: %v", name, err)
+		}
+		patterns[name] = re
+	}
+	return patterns, nil
+}
+
+// matchPatterns adds a family to families under each of patterns, holding
+// the highest value of the metrics whose names it matches, so that it
+// resolves as the name of a metric would.
+func matchPatterns(families map[string]*dto.MetricFamily, patterns map[string]*regexp.Regexp) {
+	matched := map[string]float64{}
+	for pattern, re := range patterns {
+		for name := range families {
+			if !re.MatchString(name) {
+				continue
+			}
+			v, err := maxGaugeValue(families, name)
+			if err != nil {
+				continue
+			}
+			if highest, ok := matched[pattern]; !ok || v > highest {
+				matched[pattern] = v
+			}
+		}
+	}
+	for pattern, v := range matched {
+		families[pattern] = gaugeFamily(pattern, v)
+	}
+}
+
+// discoverMetric returns the first of aliases present in families. Failing an
+// exact match, a family whose name ends in "_" plus an alias is accepted, so
+// that namespaced metrics such as "sui_highest_synced_checkpoint" are found.
+// An alias of a single word, such as "epoch", is only matched exactly, as
+// too many unrelated metrics end in it.
+func discoverMetric(families map[string]*dto.MetricFamily, aliases []string) (string, error) {
+	for _, alias := range aliases {
+		if _, ok := families[alias]; ok {
+			return alias, nil
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, alias := range aliases {
+		if !strings.Contains(alias, "_") {
+			continue
+		}
+		for _, name := range names {
+			if strings.HasSuffix(name, "_"+alias) {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("none of the metrics %s found", strings.Join(aliases, ", "))
+}
+
+// resolveMetric returns name if it is set and otherwise discovers the metric
+// among aliases, caching the result in *name.
+func resolveMetric(families map[string]*dto.MetricFamily, name *string, aliases []string) (string, error) {
+	if *name != "" {
+		return *name, nil
+	}
+	found, err := discoverMetric(families, aliases)
+	if err != nil {
+		return "", err
+	}
+	*name = found
+	return found, nil
+}
+
+// Labels naming the node, which operators add to tell their validators
+// apart, in order of preference.
+var (
+	validatorLabels = []string{"validator", "validator_name", "host"}
+	networkLabels   = []string{"network", "chain"}
+)
+
+// chainNames names the networks by the chain identifier sui-node labels its
+// uptime with.
+var chainNames = map[string]string{
+	"35834a8a": "mainnet",
+	"4c78adac": "testnet",
+}
+
+// labelValue returns the value of the first of labels on the first series of
+// the first of aliases that has it, or "" if there is none.
+func labelValue(families map[string]*dto.MetricFamily, aliases []string, labels ...string) string {
+	name, err := discoverMetric(families, aliases)
+	if err != nil {
+		return ""
+	}
+	for _, label := range labels {
+		for _, m := range families[name].GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label && l.GetValue() != "" {
+					return l.GetValue()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// identity reads who the node is from the labels of the metrics it labels
+// with its version.
+func identity(families map[string]*dto.MetricFamily) Identity {
+	id := Identity{
+		Validator: labelValue(families, versionAliases, validatorLabels...),
+		Network:   labelValue(families, versionAliases, networkLabels...),
+	}
+	if id.Network == "" {
+		if chain := labelValue(families, versionAliases, "chain_identifier"); chain != "" {
+			id.Network = chainNames[chain]
+			if id.Network == "" {
+				id.Network = chain
+			}
+		}
+	}
+	return id
+}
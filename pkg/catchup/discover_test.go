@@ -0,0 +1,37 @@
+package catchup
+
+import "testing"
+
+func TestDiscoverMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		aliases []string
+		want    string
+	}{
+		{"exact", "current_epoch 1\nepoch 2\n", epochAliases, "current_epoch"},
+		{"exact single word", "epoch 2\n", epochAliases, "epoch"},
+		{"namespaced", "sui_current_epoch 1\n", epochAliases, "sui_current_epoch"},
+		{"single word not suffix matched", "safe_mode_epoch 1\n", epochAliases, ""},
+		{"namespaced protocol", "sui_current_protocol_version 40\n", protocolAliases, "sui_current_protocol_version"},
+		{"supported protocol not current", "max_supported_protocol_version 41\n", protocolAliases, ""},
+		{"supported protocol", "max_supported_protocol_version 41\ncurrent_protocol_version 40\n", supportedProtocolAliases, "max_supported_protocol_version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discoverMetric(parsePage(t, tt.page), tt.aliases)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("got %q, want no match", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package catchup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runExec runs command and returns the values it printed, either as a JSON
+// object such as {"known": 120, "synced": 100}, or as name=value lines.
+// fetchExec reads known, synced, executed and epoch, so that a command may
+// print more for its own use, such as non-numeric JSON fields.
+func runExec(ctx context.Context, command []string) (map[string]float64, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("running %q failed: %v: %s", command[0], err, msg)
+		}
+		return nil, fmt.Errorf("running %q failed: %v", command[0], err)
+	}
+	out := bytes.TrimSpace(stdout.Bytes())
+	if bytes.HasPrefix(out, []byte("{")) {
+		var raw map[string]any
+		if err := json.Unmarshal(out, &raw); err != nil {
+			return nil, fmt.Errorf("parsing the output of %q failed: %v", command[0], err)
+		}
+		values := map[string]float64{}
+		for name, value := range raw {
+			if v, ok := value.(float64); ok {
+				values[name] = v
+			}
+		}
+		return values, nil
+	}
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("parsing the output of %q failed: invalid line %q, must be name=value", command[0], line)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the output of %q failed: invalid value of %s: %v", command[0], strings.TrimSpace(name), err)
+		}
+		values[strings.TrimSpace(name)] = v
+	}
+	return values, scanner.Err()
+}
+
+// fetchExec reads the node's watermarks from the output of Options.Command,
+// and the network tip from Options.TipURL or Options.ReferenceAddr if set,
+// read at the same time.
+func (w *Watcher) fetchExec(ctx context.Context) (sample, error) {
+	var s sample
+	tip := w.startTip(ctx)
+	ran := time.Now()
+	values, err := runExec(ctx, w.opts.Command)
+	if err != nil {
+		return s, err
+	}
+	synced, ok := values["synced"]
+	if !ok {
+		return s, fmt.Errorf("%q printed no synced checkpoint", w.opts.Command[0])
+	}
+	s.synced = synced
+	s.executed, s.hasExecuted = values["executed"]
+	s.epoch = values["epoch"]
+	if tip != nil {
+		if err = w.applyTip(&s, <-tip, ran.Add(time.Since(ran)/2)); err != nil {
+			return s, err
+		}
+	} else if s.known, ok = values["known"]; !ok {
+		return s, fmt.Errorf("%q printed no known checkpoint", w.opts.Command[0])
+	}
+	s.hasCheckpoints = true
+	return s, nil
+}
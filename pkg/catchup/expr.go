@@ -0,0 +1,306 @@
+package catchup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// expr is a parsed arithmetic expression over scraped metrics, see
+// Options.LagExpr.
+type expr interface {
+	eval(families map[string]*dto.MetricFamily) (float64, error)
+	// metrics appends the names of the metrics the expression reads.
+	metrics(names []string) []string
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(map[string]*dto.MetricFamily) (float64, error) { return float64(e), nil }
+func (e numberExpr) metrics(names []string) []string                    { return names }
+
+// labelMatcher selects series by the value of a label, as in PromQL's
+// `name{label="value"}` and `name{label!="value"}`.
+type labelMatcher struct {
+	name, value string
+	negate      bool
+}
+
+// metricExpr is the value of the single series of a metric matching its
+// label matchers.
+type metricExpr struct {
+	name     string
+	matchers []labelMatcher
+}
+
+func (e metricExpr) eval(families map[string]*dto.MetricFamily) (float64, error) {
+	f, ok := families[e.name]
+	if !ok || len(f.GetMetric()) == 0 {
+		return 0, fmt.Errorf("metric %q not found", e.name)
+	}
+	var found []*dto.Metric
+	for _, m := range f.GetMetric() {
+		if e.matches(m) {
+			found = append(found, m)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return 0, fmt.Errorf("no series of metric %q match %s", e.name, e.selector())
+	case 1:
+		return seriesValue(f, found[0])
+	default:
+		return 0, fmt.Errorf("%d series of metric %q match %s, select one with labels", len(found), e.name, e.selector())
+	}
+}
+
+func (e metricExpr) matches(m *dto.Metric) bool {
+	for _, lm := range e.matchers {
+		var value string
+		for _, l := range m.GetLabel() {
+			if l.GetName() == lm.name {
+				value = l.GetValue()
+			}
+		}
+		if (value == lm.value) == lm.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (e metricExpr) selector() string {
+	parts := make([]string, len(e.matchers))
+	for i, lm := range e.matchers {
+		op := "="
+		if lm.negate {
+			op = "!="
+		}
+		parts[i] = lm.name + op + strconv.Quote(lm.value)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (e metricExpr) metrics(names []string) []string { return append(names, e.name) }
+
+type negExpr struct{ x expr }
+
+func (e negExpr) eval(families map[string]*dto.MetricFamily) (float64, error) {
+	v, err := e.x.eval(families)
+	return -v, err
+}
+
+func (e negExpr) metrics(names []string) []string { return e.x.metrics(names) }
+
+type binaryExpr struct {
+	op   byte
+	x, y expr
+}
+
+func (e binaryExpr) eval(families map[string]*dto.MetricFamily) (float64, error) {
+	x, err := e.x.eval(families)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.y.eval(families)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	default:
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	}
+}
+
+func (e binaryExpr) metrics(names []string) []string { return e.y.metrics(e.x.metrics(names)) }
+
+// parseExpr parses an expression of metric names with optional label
+// matchers, numbers, the operators + - * / and parentheses, e.g.
+// `highest_known_checkpoint - last_executed_checkpoint{pipeline="main"}`.
+func parseExpr(s string) (expr, error) {
+	p := exprParser{s: s}
+	e, err := p.sum()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at offset %d", s, p.s[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+// exprParser is a recursive descent parser for parseExpr.
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or zero at the end of the input.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *exprParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.unexpected(fmt.Sprintf("%q", c))
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) unexpected(want string) error {
+	if p.pos == len(p.s) {
+		return fmt.Errorf("expected %s at end of input", want)
+	}
+	return fmt.Errorf("expected %s at offset %d", want, p.pos)
+}
+
+// sum parses terms joined by + and -.
+func (p *exprParser) sum() (expr, error) {
+	x, err := p.product()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.s[p.pos]
+		p.pos++
+		var y expr
+		if y, err = p.product(); err == nil {
+			x = binaryExpr{op, x, y}
+		}
+	}
+	return x, err
+}
+
+// product parses factors joined by * and /.
+func (p *exprParser) product() (expr, error) {
+	x, err := p.unary()
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op := p.s[p.pos]
+		p.pos++
+		var y expr
+		if y, err = p.unary(); err == nil {
+			x = binaryExpr{op, x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.unary()
+		return negExpr{x}, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (expr, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		x, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(')')
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && strings.IndexByte("0123456789.eE", p.s[p.pos]) >= 0 {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return numberExpr(v), nil
+	case isNameStart(c):
+		e := metricExpr{name: p.name()}
+		if p.peek() == '{' {
+			p.pos++
+			var err error
+			if e.matchers, err = p.matchers(); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	default:
+		return nil, p.unexpected("a metric, number or (")
+	}
+}
+
+// matchers parses the label matchers following a metric name up to the
+// closing brace.
+func (p *exprParser) matchers() ([]labelMatcher, error) {
+	var matchers []labelMatcher
+	for p.peek() != '}' {
+		if !isNameStart(p.peek()) {
+			return nil, p.unexpected("a label name")
+		}
+		lm := labelMatcher{name: p.name()}
+		if p.peek() == '!' {
+			lm.negate = true
+			p.pos++
+		}
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		if p.peek() != '"' {
+			return nil, p.unexpected("a quoted label value")
+		}
+		end := p.pos + 1
+		for end < len(p.s) && p.s[end] != '"' {
+			if p.s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.s) {
+			return nil, fmt.Errorf("unterminated label value at offset %d", p.pos)
+		}
+		value, err := strconv.Unquote(p.s[p.pos : end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid label value at offset %d", p.pos)
+		}
+		lm.value = value
+		p.pos = end + 1
+		matchers = append(matchers, lm)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	return matchers, p.expect('}')
+}
+
+// name consumes a metric or label name, which starts at p.pos.
+func (p *exprParser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) && (isNameStart(p.s[p.pos]) || p.s[p.pos] >= '0' && p.s[p.pos] <= '9') {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':'
+}
@@ -0,0 +1,105 @@
+package catchup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// parsePage parses page in the Prometheus text format.
+func parsePage(t *testing.T, page string) map[string]*dto.MetricFamily {
+	t.Helper()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+const exprPage = `highest_known_checkpoint 1200
+last_executed_checkpoint 1000
+watermark{pipeline="main"} 900
+watermark{pipeline="backfill"} 500
+zero 0
+`
+
+func TestExpr(t *testing.T) {
+	families := parsePage(t, exprPage)
+	tests := []struct {
+		expr    string
+		want    float64
+		metrics []string
+	}{
+		{"42", 42, nil},
+		{"1.5e3", 1500, nil},
+		{"highest_known_checkpoint - last_executed_checkpoint", 200, []string{"highest_known_checkpoint", "last_executed_checkpoint"}},
+		{"1 + 2 * 3", 7, nil},
+		{"(1 + 2) * 3", 9, nil},
+		{"10 - 4 - 3", 3, nil},
+		{"12 / 3 / 2", 2, nil},
+		{"-last_executed_checkpoint + 1", -999, []string{"last_executed_checkpoint"}},
+		{"--5", 5, nil},
+		{`highest_known_checkpoint - watermark{pipeline="main"}`, 300, []string{"highest_known_checkpoint", "watermark"}},
+		{`watermark{pipeline!="main"}`, 500, []string{"watermark"}},
+		{`watermark{ pipeline = "backfill" , }`, 500, []string{"watermark"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := parseExpr(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.eval(families)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if metrics := e.metrics(nil); !reflect.DeepEqual(metrics, tt.metrics) {
+				t.Errorf("got metrics %v, want %v", metrics, tt.metrics)
+			}
+		})
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	families := parsePage(t, exprPage)
+	tests := []struct {
+		expr, parseErr, evalErr string
+	}{
+		{expr: "", parseErr: "expected a metric, number or ("},
+		{expr: "1 +", parseErr: "expected a metric, number or ("},
+		{expr: "(1 + 2", parseErr: "expected ')'"},
+		{expr: "1 2", parseErr: `unexpected "2"`},
+		{expr: "1..2", parseErr: "invalid number"},
+		{expr: `watermark{pipeline="main}`, parseErr: "unterminated label value"},
+		{expr: `watermark{pipeline=main}`, parseErr: "a quoted label value"},
+		{expr: `watermark{="main"}`, parseErr: "a label name"},
+		{expr: "missing_metric", evalErr: `metric "missing_metric" not found`},
+		{expr: "watermark", evalErr: "2 series of metric"},
+		{expr: `watermark{pipeline="other"}`, evalErr: "no series of metric"},
+		{expr: "1 / zero", evalErr: "division by zero"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := parseExpr(tt.expr)
+			if tt.parseErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.parseErr) {
+					t.Fatalf("got parse error %v, want one containing %q", err, tt.parseErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.eval(families); err == nil || !strings.Contains(err.Error(), tt.evalErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.evalErr)
+			}
+		})
+	}
+}
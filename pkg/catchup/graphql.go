@@ -0,0 +1,93 @@
+package catchup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// availableRangeQuery asks a Sui GraphQL service for the range of
+// checkpoints it serves data for.
+const availableRangeQuery = `{ availableRange { first { sequenceNumber } last { sequenceNumber } } }`
+
+// graphqlRange returns the first and last checkpoints a Sui GraphQL service
+// at url serves data for.
+func graphqlRange(ctx context.Context, url string, transport http.RoundTripper) (first, last float64, err error) {
+	body, err := json.Marshal(map[string]string{"query": availableRangeQuery})
+	if err != nil {
+		return 0, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, fmt.Errorf("creating POST request for URL %q failed: %v", redactURL(url), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("executing POST request for URL %q failed: %v", redactURL(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("POST request for URL %q returned HTTP status %s", redactURL(url), resp.Status)
+	}
+
+	type checkpoint struct {
+		SequenceNumber *float64 `json:"sequenceNumber"`
+	}
+	var r struct {
+		Data struct {
+			AvailableRange struct {
+				First checkpoint `json:"first"`
+				Last  checkpoint `json:"last"`
+			} `json:"availableRange"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return 0, 0, fmt.Errorf("decoding availableRange response from %q failed: %v", redactURL(url), err)
+	}
+	if len(r.Errors) > 0 {
+		msgs := make([]string, len(r.Errors))
+		for i, e := range r.Errors {
+			msgs[i] = e.Message
+		}
+		return 0, 0, fmt.Errorf("availableRange query on %q failed: %s", redactURL(url), strings.Join(msgs, "; "))
+	}
+	rng := r.Data.AvailableRange
+	if rng.Last.SequenceNumber == nil {
+		return 0, 0, fmt.Errorf("availableRange query on %q returned no checkpoints", redactURL(url))
+	}
+	if rng.First.SequenceNumber != nil {
+		first = *rng.First.SequenceNumber
+	}
+	return first, *rng.Last.SequenceNumber, nil
+}
+
+// fetchGraphQL reads the last checkpoint a GraphQL service serves as the
+// synced checkpoint, and the network tip from Options.TipURL or
+// Options.ReferenceAddr, read at the same time.
+func (w *Watcher) fetchGraphQL(ctx context.Context) (sample, error) {
+	var s sample
+	tip := w.startTip(ctx)
+	scraped := time.Now()
+	err := w.failover(ctx, func(addr string) error {
+		var err error
+		_, s.synced, err = graphqlRange(ctx, addr, w.nodeTransport)
+		return err
+	})
+	if err != nil {
+		return s, err
+	}
+	if err = w.applyTip(&s, <-tip, scraped.Add(time.Since(scraped)/2)); err != nil {
+		return s, err
+	}
+	s.hasCheckpoints = true
+	return s, nil
+}
@@ -0,0 +1,148 @@
+package catchup
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// serviceInfoMethod is the gRPC method of sui-node returning its chain, epoch
+// and highest checkpoint.
+const serviceInfoMethod = "/sui.rpc.v2.LedgerService/GetServiceInfo"
+
+// Field numbers of the GetServiceInfoResponse message.
+const (
+	serviceInfoEpoch      = 3
+	serviceInfoCheckpoint = 4
+)
+
+// grpcTransport returns an HTTP/2 transport for gRPC requests to url, which
+// dials with dial, e.g. through a tunnel, and verifies TLS with config, nil
+// for the defaults. Plain http:// URLs are spoken to in HTTP/2 without TLS,
+// as gRPC servers expect.
+func grpcTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error), config *tls.Config, url string) http.RoundTripper {
+	plain := strings.HasPrefix(url, "http://")
+	return &http2.Transport{
+		AllowHTTP:       plain,
+		TLSClientConfig: config,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil || plain {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, cfg)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+}
+
+// grpcServiceInfo calls GetServiceInfo on the sui-node gRPC endpoint at url
+// through a transport returned by grpcTransport, and returns the node's
+// highest checkpoint and epoch.
+func grpcServiceInfo(ctx context.Context, url string, transport http.RoundTripper) (checkpoint, epoch float64, err error) {
+	// An empty request is a frame header only: not compressed, 0 bytes.
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(url, "/")+serviceInfoMethod, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("creating gRPC request for URL %q failed: %v", redactURL(url), err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("executing gRPC request for URL %q failed: %v", redactURL(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("gRPC request for URL %q returned HTTP status %s", redactURL(url), resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading gRPC response from %q failed: %v", redactURL(url), err)
+	}
+	// Errors come in the trailers, or in the headers of a response without
+	// a body.
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return 0, 0, fmt.Errorf("GetServiceInfo on %q failed: %s (code %s)", redactURL(url), message, status)
+	}
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return 0, 0, fmt.Errorf("invalid GetServiceInfo response from %q", redactURL(url))
+	}
+	var hasCheckpoint bool
+	for msg := body[5:]; len(msg) > 0; {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return 0, 0, fmt.Errorf("decoding GetServiceInfo response from %q failed: %v", redactURL(url), protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		if typ == protowire.VarintType && (num == serviceInfoEpoch || num == serviceInfoCheckpoint) {
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return 0, 0, fmt.Errorf("decoding GetServiceInfo response from %q failed: %v", redactURL(url), protowire.ParseError(n))
+			}
+			msg = msg[n:]
+			if num == serviceInfoEpoch {
+				epoch = float64(v)
+			} else {
+				checkpoint, hasCheckpoint = float64(v), true
+			}
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+			return 0, 0, fmt.Errorf("decoding GetServiceInfo response from %q failed: %v", redactURL(url), protowire.ParseError(n))
+		}
+		msg = msg[n:]
+	}
+	if !hasCheckpoint {
+		return 0, 0, fmt.Errorf("GetServiceInfo on %q returned no checkpoint", redactURL(url))
+	}
+	return checkpoint, epoch, nil
+}
+
+// fetchGRPC reads the node's highest checkpoint from its gRPC API as the
+// synced checkpoint, and the network tip from Options.TipURL or
+// Options.ReferenceAddr, read at the same time.
+func (w *Watcher) fetchGRPC(ctx context.Context) (sample, error) {
+	var s sample
+	tip := w.startTip(ctx)
+	scraped := time.Now()
+	err := w.failover(ctx, func(addr string) error {
+		var err error
+		t, ok := w.grpcTransports[addr]
+		if !ok {
+			t = grpcTransport(w.opts.GRPCDialContext, w.opts.GRPCTLSConfig, addr)
+			if w.opts.WrapGRPCTransport != nil {
+				t = w.opts.WrapGRPCTransport(t)
+			}
+			w.grpcTransports[addr] = t
+		}
+		s.synced, s.epoch, err = grpcServiceInfo(ctx, addr, t)
+		return err
+	})
+	if err != nil {
+		return s, err
+	}
+	if err = w.applyTip(&s, <-tip, scraped.Add(time.Since(scraped)/2)); err != nil {
+		return s, err
+	}
+	s.hasCheckpoints = true
+	return s, nil
+}
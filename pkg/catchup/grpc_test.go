@@ -0,0 +1,46 @@
+package catchup
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// wrappedTransport hides the *http.Transport it sends requests through, as
+// one adding credentials does.
+type wrappedTransport struct{ http.RoundTripper }
+
+func TestGRPCDialer(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) { return nil, nil }
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{"default transport", Options{}, ""},
+		{"wrapped transport", Options{Transport: wrappedTransport{DefaultTransport()}}, "needs a dialer"},
+		{"wrapped transport and dialer", Options{Transport: wrappedTransport{DefaultTransport()}, GRPCDialContext: dial}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Addr = "http://localhost:9000"
+			tt.opts.TipURL = "https://fullnode.mainnet.sui.io:443"
+			tt.opts.Source = SourceGRPC
+			w, err := New(tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.opts.GRPCDialContext == nil {
+				t.Error("no gRPC dialer")
+			}
+		})
+	}
+}
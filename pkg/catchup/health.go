@@ -0,0 +1,56 @@
+package catchup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health is the result of probing whether the node serves requests, see
+// Options.HealthURL.
+type Health struct {
+	// Healthy is set when the probe succeeded, and Err is why it failed
+	// otherwise.
+	Healthy bool
+	Err     error
+	// Latency is how long the probe took.
+	Latency time.Duration
+}
+
+// checkHealth probes Options.HealthURL: a tcp://host:port address is
+// connected to, and an HTTP URL must answer a GET with a 2xx status.
+func (w *Watcher) checkHealth(ctx context.Context) *Health {
+	start := time.Now()
+	var err error
+	if addr := strings.TrimPrefix(w.opts.HealthURL, "tcp://"); addr != w.opts.HealthURL {
+		var conn net.Conn
+		var d net.Dialer
+		if conn, err = d.DialContext(ctx, "tcp", addr); err == nil {
+			conn.Close()
+		}
+	} else {
+		err = getHealth(ctx, w.opts.HealthURL, w.opts.Transport)
+	}
+	return &Health{Healthy: err == nil, Err: err, Latency: time.Since(start)}
+}
+
+func getHealth(ctx context.Context, url string, transport http.RoundTripper) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,784 @@
+package catchup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultTransport returns a new transport with the settings a Watcher uses
+// when Options.Transport is nil, for callers that want to adjust them. Its
+// connections are kept alive between scrapes, saving a TCP and TLS handshake
+// every interval; Options.RedialInterval bounds how long one is reused.
+func DefaultTransport() *http.Transport {
+	// Start with the DefaultTransport for sane defaults.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Timeout early if the server doesn't even return the headers.
+	transport.ResponseHeaderTimeout = time.Minute
+	return transport
+}
+
+// acceptHeader asks for the protobuf format, which is the cheapest to decode,
+// then the text formats, as Prometheus itself does.
+const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,` +
+	`text/plain;version=0.0.4;q=0.5,application/openmetrics-text;version=1.0.0;q=0.4,*/*;q=0.1`
+
+// sample holds the watermarks read by a single scrape.
+type sample struct {
+	known, synced float64
+
+	// executed is only set when hasExecuted is.
+	executed    float64
+	hasExecuted bool
+
+	// epoch is the node's current epoch and networkEpoch the network's,
+	// either zero when unknown.
+	epoch, networkEpoch float64
+
+	// protocol is the highest protocol version the node supports and
+	// networkProtocol the network's current one, either zero when unknown.
+	protocol, networkProtocol float64
+
+	// hasCheckpoints is set when the checkpoint watermarks were read.
+	hasCheckpoints bool
+
+	// exprLag is the value of Options.LagExpr, if set.
+	exprLag float64
+
+	// pairs are the values of Options.Pairs.
+	pairs []pairValue
+
+	// process is only set when hasProcess is, and cpuSeconds when hasCPU
+	// is.
+	process    Process
+	hasProcess bool
+	cpuSeconds float64
+	hasCPU     bool
+
+	// knownTx and executedTx are only set when hasTx is, except that
+	// executedTx is also read for the TPS when hasExecutedTx is.
+	knownTx, executedTx float64
+	hasTx               bool
+	hasExecutedTx       bool
+
+	// receivedRound and committedRound are only set when hasRounds is.
+	receivedRound, committedRound float64
+	hasRounds                     bool
+
+	// dbSize is only set when hasDBSize is.
+	dbSize    float64
+	hasDBSize bool
+
+	// pruned and prunedObjects are only set when hasPruned is.
+	pruned, prunedObjects float64
+	hasPruned             bool
+
+	// archived is only set when hasArchived is.
+	archived    float64
+	hasArchived bool
+
+	// partitions, downloaded, objects and bytes are the snapshot restore
+	// progress, only set when hasSnapshot is.
+	partitions, downloaded, objects, bytes float64
+	hasSnapshot                            bool
+
+	// version is the node's version, if it exposes it.
+	version  string
+	identity Identity
+
+	// uptime is how long the node's process has been running in seconds,
+	// only set when hasUptime is.
+	uptime    float64
+	hasUptime bool
+
+	// peers is only set when hasPeers is.
+	peers    float64
+	hasPeers bool
+
+	// remoteAddr is the address of the node that answered, if known.
+	remoteAddr string
+
+	// parseWarnings is the number of lines of the node's metrics skipped
+	// by Options.TolerantParsing.
+	parseWarnings int
+
+	// notModified is set when the node's page had not changed since the
+	// previous scrape.
+	notModified bool
+}
+
+// fetch scrapes the node and returns its watermarks.
+func (w *Watcher) fetch(ctx context.Context) (sample, error) {
+	if w.opts.Mode == ModeGraphQL {
+		return w.fetchGraphQL(ctx)
+	}
+	if w.opts.Source == SourceGRPC {
+		return w.fetchGRPC(ctx)
+	}
+	if w.opts.Source == SourceExec {
+		return w.fetchExec(ctx)
+	}
+	var s sample
+	var families map[string]*dto.MetricFamily
+	var err error
+	tip := w.startTip(ctx)
+	scraped := time.Now()
+	if w.opts.PrometheusURL != "" {
+		families, err = w.queryMetricFamilies(ctx)
+	} else {
+		// The connection tells which address of the node answered.
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+			s.remoteAddr = info.Conn.RemoteAddr().String()
+		}}
+		err = w.failover(ctx, func(addr string) error {
+			var err error
+			parser := w.parser()
+			parser.cache = w.pageCache(addr)
+			families, err = parser.fetch(httptrace.WithClientTrace(ctx, trace), addr, w.nodeTransport)
+			s.parseWarnings, s.notModified = parser.warnings, parser.notModified
+			if err == nil && parser.watched != nil && !parser.stopped {
+				w.expected = parser.found
+			}
+			return err
+		})
+	}
+	if err != nil {
+		return s, err
+	}
+	at := scraped.Add(time.Since(scraped) / 2)
+	if w.opts.Inspect != nil {
+		w.opts.Inspect(families)
+	}
+	selectSeries(families, w.opts.Labels)
+	matchPatterns(families, w.patterns)
+	s.pairs = w.evalPairs(families)
+	s.version = labelValue(families, versionAliases, "version")
+	s.identity = identity(families)
+	s.protocol, _ = optionalValue(families, &w.protocolMetric, supportedProtocolAliases)
+	s.peers, s.hasPeers = optionalValue(families, &w.peersMetric, peersAliases)
+	s.archived, s.hasArchived = optionalValue(families, &w.archiveMetric, archiveAliases)
+	s.pruned, s.hasPruned = optionalValue(families, &w.prunedMetric, prunedAliases)
+	if s.hasPruned {
+		s.prunedObjects, _ = optionalValue(families, &w.prunedObjMetric, prunedObjectsAliases)
+	}
+	// The database is reported per column family.
+	if name, err := resolveMetric(families, &w.dbSizeMetric, dbSizeAliases); err == nil {
+		s.dbSize, err = sumGaugeValue(families, name)
+		s.hasDBSize = err == nil
+	}
+	if w.opts.Mode == ModeSnapshotRestore {
+		w.fetchSnapshot(families, &s)
+	}
+	w.fetchProcess(families, &s)
+	w.fetchUptime(families, &s, scraped)
+	// The checkpoint watermarks may not be exposed before a snapshot has
+	// been restored.
+	restoring := s.hasSnapshot && (s.partitions == 0 || s.downloaded < s.partitions)
+	if w.lagExpr != nil {
+		if s.exprLag, err = w.lagExpr.eval(families); err != nil {
+			return s, fmt.Errorf("evaluating the lag expression failed: %v", err)
+		}
+		s.epoch, _ = optionalValue(families, &w.epochMetric, epochAliases)
+	} else if err := w.fetchCheckpoints(families, &s, tip, at); err != nil && w.opts.Track != TrackTransactions && !restoring {
+		return s, err
+	}
+	if w.opts.Track != TrackCheckpoints {
+		if err := w.fetchTransactions(families, &s); err != nil {
+			return s, err
+		}
+		s.hasExecutedTx = true
+	} else {
+		s.executedTx, s.hasExecutedTx = optionalValue(families, &w.executedTxMetric, executedTxAliases)
+	}
+	if w.opts.Consensus {
+		if err := w.fetchRounds(families, &s); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// fetchCheckpoints reads the checkpoint watermarks into s, the node having
+// been scraped at the given time. The known checkpoint comes from the tip
+// read by startTip instead of the node when Options.TipURL or
+// Options.ReferenceAddr is set.
+func (w *Watcher) fetchCheckpoints(families map[string]*dto.MetricFamily, s *sample, tip <-chan tipResult, at time.Time) error {
+	known, synced := knownAliases, syncedAliases
+	if w.opts.Mode == ModeIndexer {
+		known, synced = indexerKnownAliases, indexerSyncedAliases
+	}
+	if w.opts.Mode == ModeArchive {
+		known, synced = executedAliases, archiveUploadedAliases
+	}
+	syncedName, err := resolveMetric(families, &w.syncedMetric, synced)
+	if err != nil {
+		return err
+	}
+	if s.synced, err = gaugeValue(families, syncedName); err != nil {
+		return err
+	}
+	if tip != nil {
+		err = w.applyTip(s, <-tip, at)
+	} else {
+		var knownName string
+		if knownName, err = resolveMetric(families, &w.knownMetric, known); err == nil {
+			s.known, err = gaugeValue(families, knownName)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.epoch, _ = optionalValue(families, &w.epochMetric, epochAliases)
+
+	// Not every release exposes the executed checkpoint, so it is only an
+	// error to miss it when it is required.
+	executedName, err := resolveMetric(families, &w.executedMetric, executedAliases)
+	if err == nil {
+		s.executed, err = gaugeValue(families, executedName)
+		s.hasExecuted = err == nil
+	}
+	if err != nil && w.opts.RequireExecuted {
+		return err
+	}
+	s.hasCheckpoints = true
+	return nil
+}
+
+// fetchTransactions reads the transaction watermarks into s.
+func (w *Watcher) fetchTransactions(families map[string]*dto.MetricFamily, s *sample) error {
+	knownName, err := resolveMetric(families, &w.knownTxMetric, knownTxAliases)
+	if err != nil {
+		return err
+	}
+	executedName, err := resolveMetric(families, &w.executedTxMetric, executedTxAliases)
+	if err != nil {
+		return err
+	}
+	if s.knownTx, err = gaugeValue(families, knownName); err != nil {
+		return err
+	}
+	if s.executedTx, err = gaugeValue(families, executedName); err != nil {
+		return err
+	}
+	s.hasTx = true
+	return nil
+}
+
+// fetchSnapshot reads the snapshot restore progress into s, if the node
+// exposes it. A node that has finished restoring may not.
+func (w *Watcher) fetchSnapshot(families map[string]*dto.MetricFamily, s *sample) {
+	var ok bool
+	if s.partitions, ok = optionalValue(families, &w.snapshot.partitions, snapshotPartitionsAliases); !ok {
+		return
+	}
+	if s.downloaded, ok = optionalValue(families, &w.snapshot.downloaded, snapshotDownloadedAliases); !ok {
+		return
+	}
+	s.objects, _ = optionalValue(families, &w.snapshot.objects, snapshotObjectsAliases)
+	s.bytes, _ = optionalValue(families, &w.snapshot.bytes, snapshotBytesAliases)
+	s.hasSnapshot = true
+}
+
+// fetchProcess reads the resource usage of the node's process into s, if it
+// exposes it.
+func (w *Watcher) fetchProcess(families map[string]*dto.MetricFamily, s *sample) {
+	var hasRSS bool
+	s.cpuSeconds, s.hasCPU = optionalValue(families, &w.process.cpu, processCPUAliases)
+	s.process.RSS, hasRSS = optionalValue(families, &w.process.rss, processRSSAliases)
+	if !s.hasCPU && !hasRSS {
+		return
+	}
+	s.hasProcess = true
+	s.process.OpenFDs, _ = optionalValue(families, &w.process.fds, processFDsAliases)
+	s.process.MaxFDs, _ = optionalValue(families, &w.process.maxFDs, processMaxFDsAliases)
+	s.process.QueueDepth, s.process.HasQueueDepth = optionalValue(families, &w.process.queue, queueDepthAliases)
+}
+
+// fetchUptime reads how long the node's process has been running into s,
+// from its uptime or else the start time of its process scraped at the given
+// time, if it exposes either.
+func (w *Watcher) fetchUptime(families map[string]*dto.MetricFamily, s *sample, at time.Time) {
+	if uptime, ok := optionalValue(families, &w.uptimeMetric, uptimeAliases); ok {
+		s.uptime, s.hasUptime = uptime, true
+		return
+	}
+	if start, ok := optionalValue(families, &w.startTimeMetric, startTimeAliases); ok && start > 0 {
+		s.uptime, s.hasUptime = math.Max(0, float64(at.UnixNano())/1e9-start), true
+	}
+}
+
+// fetchRounds reads the consensus rounds into s. Rounds are reported per
+// authority by some releases, so the highest received by any counts.
+func (w *Watcher) fetchRounds(families map[string]*dto.MetricFamily, s *sample) error {
+	receivedName, err := resolveMetric(families, &w.receivedMetric, receivedRoundAliases)
+	if err != nil {
+		return err
+	}
+	committedName, err := resolveMetric(families, &w.committedMetric, committedRoundAliases)
+	if err != nil {
+		return err
+	}
+	if s.receivedRound, err = maxGaugeValue(families, receivedName); err != nil {
+		return err
+	}
+	if s.committedRound, err = gaugeValue(families, committedName); err != nil {
+		return err
+	}
+	s.hasRounds = true
+	return nil
+}
+
+// tipResult is the network tip read by startTip, at the midpoint of its
+// requests.
+type tipResult struct {
+	s   sample
+	at  time.Time
+	err error
+}
+
+// startTip reads the network tip from Options.TipURL or Options.ReferenceAddr
+// in the background, while the node is scraped, or returns nil if neither is
+// set.
+func (w *Watcher) startTip(ctx context.Context) <-chan tipResult {
+	if w.opts.TipURL == "" && w.opts.ReferenceAddr == "" {
+		return nil
+	}
+	c := make(chan tipResult, 1)
+	go func() {
+		start := time.Now()
+		var r tipResult
+		if w.opts.TipURL != "" {
+			r.err = w.fetchTip(ctx, &r.s)
+		} else {
+			r.err = w.fetchReference(ctx, &r.s)
+		}
+		r.at = start.Add(time.Since(start) / 2)
+		c <- r
+	}()
+	return c
+}
+
+// applyTip sets the network tip of s from r, moved to the time the node was
+// scraped at by the rate the tip grows at. A slow scrape of either side then
+// does not add the checkpoints produced in the meantime to the lag.
+func (w *Watcher) applyTip(s *sample, r tipResult, at time.Time) error {
+	if r.err != nil {
+		return r.err
+	}
+	rate := w.tipGrowth.update(r.s.known, r.at)
+	if rate < 0 {
+		rate = 0
+	}
+	s.known = math.Round(r.s.known + rate*at.Sub(r.at).Seconds())
+	s.networkEpoch, s.networkProtocol = r.s.networkEpoch, r.s.networkProtocol
+	return nil
+}
+
+// fetchReference reads the synced checkpoint of the reference node into
+// s.known and, if exposed, its current epoch and protocol version into
+// s.networkEpoch and s.networkProtocol.
+func (w *Watcher) fetchReference(ctx context.Context, s *sample) error {
+	// The reference's families are not those expected of the node.
+	parser := w.parser()
+	parser.expected = nil
+	families, err := parser.fetch(ctx, w.opts.ReferenceAddr, w.opts.Transport)
+	if err != nil {
+		return fmt.Errorf("reference node: %v", err)
+	}
+	matchPatterns(families, w.patterns)
+	// The reference may run a different release, so discover its metric
+	// names independently of the local node's.
+	name := w.opts.SyncedMetric
+	if _, err := resolveMetric(families, &name, syncedAliases); err != nil {
+		return fmt.Errorf("reference node: %v", err)
+	}
+	if s.known, err = gaugeValue(families, name); err != nil {
+		return fmt.Errorf("reference node: %v", err)
+	}
+	name = w.opts.EpochMetric
+	s.networkEpoch, _ = optionalValue(families, &name, epochAliases)
+	name = ""
+	s.networkProtocol, _ = optionalValue(families, &name, protocolAliases)
+	return nil
+}
+
+// metricsParser decodes metrics pages, strictly, or if tolerant skipping the
+// lines of the text formats that fail to parse and counting them in
+// warnings.
+type metricsParser struct {
+	tolerant bool
+	warnings int
+
+	// watched, if set, restricts the text formats to the families it
+	// accepts, which are picked out line by line without parsing the
+	// others, and reading stops once all of expected have been read. found
+	// are the families read, and stopped is set if reading stopped early.
+	watched  func(name string) bool
+	expected map[string]bool
+	found    map[string]bool
+	stopped  bool
+
+	// cache, if set, holds the last page of the URL for conditional
+	// requests, and notModified is set if it was reused.
+	cache       *pageCache
+	notModified bool
+}
+
+// fetch retrieves metrics from the provided URL and decodes them into
+// MetricFamily proto messages keyed by name.
+func (p *metricsParser) fetch(ctx context.Context, url string, transport http.RoundTripper) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating GET request for URL %q failed: %v", redactURL(url), err)
+	}
+	req.Header.Add("Accept", acceptHeader)
+	// Metrics pages run to hundreds of kilobytes. http.Transport would ask
+	// for gzip by itself, but not every Options.Transport is one.
+	req.Header.Add("Accept-Encoding", "gzip")
+	if c := p.cache; c != nil && c.families != nil {
+		if c.etag != "" {
+			req.Header.Set("If-None-Match", c.etag)
+		}
+		if c.lastModified != "" {
+			req.Header.Set("If-Modified-Since", c.lastModified)
+		}
+	}
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing GET request for URL %q failed: %v", redactURL(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && p.cache != nil && p.cache.families != nil {
+		p.notModified = true
+		p.warnings = p.cache.warnings
+		return cloneFamilies(p.cache.families), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		after, ok := retryAfter(resp.Header, time.Now())
+		if ok || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &RetryAfterError{URL: redactURL(url), Status: resp.Status, After: after}
+		}
+		return nil, fmt.Errorf("GET request for URL %q returned HTTP status %s", redactURL(url), resp.Status)
+	}
+	body := io.Reader(resp.Body)
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing response from URL %q failed: %v", redactURL(url), err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+	families, err := p.parseResponse(resp.Header, body)
+	if p.stopped {
+		// Reading the rest without parsing it keeps the connection for
+		// the next scrape.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+	}
+	if p.cache != nil {
+		p.cache.update(resp.Header, families, p.warnings, err)
+	}
+	return families, err
+}
+
+// redactURL returns rawURL with any password in its user info replaced by
+// "xxxxx", for errors and Progress that may end up in logs and on screens.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// FetchMetrics scrapes the metrics page at url through transport, or
+// DefaultTransport if nil, and returns its metric families keyed by name,
+// e.g. to compare them between nodes.
+func FetchMetrics(ctx context.Context, url string, transport http.RoundTripper) (map[string]*dto.MetricFamily, error) {
+	if transport == nil {
+		transport = DefaultTransport()
+	}
+	return (&metricsParser{}).fetch(ctx, url, transport)
+}
+
+// ParseMetrics decodes a metrics response body as FetchMetrics does, in the
+// format and encoding given by its header, e.g. of a recorded response.
+// Without a Content-Type, the body is read in the Prometheus text format.
+func ParseMetrics(header http.Header, body io.Reader) (map[string]*dto.MetricFamily, error) {
+	if strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing metrics failed: %v", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+	return (&metricsParser{}).parseResponse(header, body)
+}
+
+// parseResponse decodes a metrics response body in the format given by its
+// Content-Type, assuming the Prometheus text format if it is missing or
+// unknown.
+func (p *metricsParser) parseResponse(header http.Header, body io.Reader) (map[string]*dto.MetricFamily, error) {
+	mediatype, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch {
+	case mediatype == expfmt.ProtoType && params["encoding"] != "text" && params["encoding"] != "compact-text":
+		return parseProto(body)
+	case mediatype == expfmt.OpenMetricsType:
+		return p.parseOpenMetrics(body)
+	default:
+		return p.parseReader(body)
+	}
+}
+
+// parseProto decodes the length-delimited protobuf exposition format.
+func parseProto(in io.Reader) (map[string]*dto.MetricFamily, error) {
+	dec := expfmt.NewDecoder(in, expfmt.FmtProtoDelim)
+	families := map[string]*dto.MetricFamily{}
+	for {
+		var f dto.MetricFamily
+		if err := dec.Decode(&f); err == io.EOF {
+			return families, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading protobuf format failed: %v", err)
+		}
+		families[f.GetName()] = &f
+	}
+}
+
+// parseOpenMetrics decodes the OpenMetrics text format by rewriting it into
+// the Prometheus text format, which differs mostly in what a watcher does not
+// need: units, exemplars, timestamps in seconds and the types the Prometheus
+// format lacks, which become untyped.
+func (p *metricsParser) parseOpenMetrics(in io.Reader) (map[string]*dto.MetricFamily, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "# EOF", strings.HasPrefix(line, "# UNIT "):
+			continue
+		case strings.HasPrefix(line, "# TYPE "):
+			fields := strings.Fields(line)
+			if len(fields) == 4 {
+				switch fields[3] {
+				case "counter", "gauge", "histogram", "summary":
+				default:
+					line = strings.Join(fields[:3], " ") + " untyped"
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			line = openMetricsSample(line)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading OpenMetrics format failed: %v", err)
+	}
+	return p.parseReader(&out)
+}
+
+// openMetricsSample strips the exemplar and timestamp from an OpenMetrics
+// sample line, leaving the name, labels and value.
+func openMetricsSample(line string) string {
+	if i := strings.Index(line, " # {"); i >= 0 {
+		line = line[:i]
+	}
+	// The value follows the labels, which may contain spaces.
+	start := 0
+	if i := strings.LastIndex(line, "}"); i >= 0 {
+		start = i + 1
+	}
+	fields := strings.Fields(line[start:])
+	if start == 0 && len(fields) > 2 {
+		return fields[0] + " " + fields[1]
+	}
+	if start > 0 && len(fields) > 1 {
+		return line[:start] + " " + fields[0]
+	}
+	return line
+}
+
+// parseReader decodes the Prometheus text format.
+func (p *metricsParser) parseReader(in io.Reader) (map[string]*dto.MetricFamily, error) {
+	if p.watched != nil {
+		page, err := p.scan(in)
+		if err != nil {
+			return nil, err
+		}
+		in = bytes.NewReader(page)
+	}
+	if !p.tolerant {
+		var parser expfmt.TextParser
+		metricFamilies, err := parser.TextToMetricFamilies(in)
+		if err != nil {
+			return nil, fmt.Errorf("reading text format failed: %v", err)
+		}
+		return metricFamilies, nil
+	}
+	// The page is parsed again in parts if it fails as a whole.
+	page, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("reading text format failed: %v", err)
+	}
+	var parser expfmt.TextParser
+	metricFamilies, err := parser.TextToMetricFamilies(bytes.NewReader(page))
+	if err == nil {
+		return metricFamilies, nil
+	}
+	metricFamilies, skipped := parseTolerant(page)
+	if len(metricFamilies) == 0 {
+		return nil, fmt.Errorf("reading text format failed: %v", err)
+	}
+	p.warnings += skipped
+	return metricFamilies, nil
+}
+
+// gaugeValue returns the value of the single series of the named gauge.
+// Counters and untyped metrics are read too, as some watermarks are exposed
+// as such, and histograms and summaries by their sum. A family of several
+// series is an error listing them, as picking one would be arbitrary.
+func gaugeValue(families map[string]*dto.MetricFamily, name string) (float64, error) {
+	f, ok := families[name]
+	if !ok || len(f.GetMetric()) == 0 {
+		return 0, fmt.Errorf("metric %q not found", name)
+	}
+	if series := f.GetMetric(); len(series) > 1 {
+		sets := make([]string, len(series))
+		for i, m := range series {
+			sets[i] = labelSet(m)
+		}
+		return 0, fmt.Errorf("metric %q has %d series, %s; select one by its labels", name, len(series), strings.Join(sets, ", "))
+	}
+	return seriesValue(f, f.GetMetric()[0])
+}
+
+// selectSeries drops the series of every family that do not carry the
+// labels of selector. Only the labels a family uses select its series, so
+// that a selector for, e.g., per-store watermarks leaves unlabeled metrics
+// alone.
+func selectSeries(families map[string]*dto.MetricFamily, selector map[string]string) {
+	if len(selector) == 0 {
+		return
+	}
+	for _, f := range families {
+		used := map[string]bool{}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if _, ok := selector[l.GetName()]; ok {
+					used[l.GetName()] = true
+				}
+			}
+		}
+		if len(used) == 0 {
+			continue
+		}
+		var kept []*dto.Metric
+		for _, m := range f.GetMetric() {
+			matches := 0
+			for _, l := range m.GetLabel() {
+				if used[l.GetName()] && l.GetValue() == selector[l.GetName()] {
+					matches++
+				}
+			}
+			if matches == len(used) {
+				kept = append(kept, m)
+			}
+		}
+		f.Metric = kept
+	}
+}
+
+// labelSet formats the labels of a series, e.g. {store="perpetual"}.
+func labelSet(m *dto.Metric) string {
+	parts := make([]string, len(m.GetLabel()))
+	for i, l := range m.GetLabel() {
+		parts[i] = l.GetName() + "=" + strconv.Quote(l.GetValue())
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// seriesValue returns the value of a series of family f: that of a gauge,
+// counter or untyped series, or the sum of the observations of a histogram
+// or summary. Any other type is an error rather than a value of zero, which
+// would pass for a watermark.
+func seriesValue(f *dto.MetricFamily, m *dto.Metric) (float64, error) {
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue(), nil
+	case m.Counter != nil:
+		return m.GetCounter().GetValue(), nil
+	case m.Untyped != nil:
+		return m.GetUntyped().GetValue(), nil
+	case m.Histogram != nil:
+		return m.GetHistogram().GetSampleSum(), nil
+	case m.Summary != nil:
+		return m.GetSummary().GetSampleSum(), nil
+	default:
+		return 0, fmt.Errorf("metric %q of type %s has no value to read", f.GetName(), strings.ToLower(f.GetType().String()))
+	}
+}
+
+// maxGaugeValue returns the highest value of any series of the named gauge.
+func maxGaugeValue(families map[string]*dto.MetricFamily, name string) (float64, error) {
+	f, ok := families[name]
+	if !ok || len(f.GetMetric()) == 0 {
+		return 0, fmt.Errorf("metric %q not found", name)
+	}
+	v := math.Inf(-1)
+	for _, m := range f.GetMetric() {
+		g, err := seriesValue(f, m)
+		if err != nil {
+			return 0, err
+		}
+		v = math.Max(v, g)
+	}
+	return v, nil
+}
+
+// sumGaugeValue returns the sum of every series of the named gauge.
+func sumGaugeValue(families map[string]*dto.MetricFamily, name string) (float64, error) {
+	f, ok := families[name]
+	if !ok || len(f.GetMetric()) == 0 {
+		return 0, fmt.Errorf("metric %q not found", name)
+	}
+	var sum float64
+	for _, m := range f.GetMetric() {
+		v, err := seriesValue(f, m)
+		if err != nil {
+			return 0, err
+		}
+		sum += v
+	}
+	return sum, nil
+}
+
+// optionalValue is like gaugeValue for a metric resolved as by resolveMetric,
+// but reports whether the metric was found instead of failing.
+func optionalValue(families map[string]*dto.MetricFamily, name *string, aliases []string) (float64, bool) {
+	found, err := resolveMetric(families, name, aliases)
+	if err != nil {
+		return 0, false
+	}
+	v, err := gaugeValue(families, found)
+	return v, err == nil
+}
@@ -0,0 +1,186 @@
+package catchup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const testPage = `# TYPE highest_known_checkpoint gauge
+highest_known_checkpoint 1200
+# TYPE highest_synced_checkpoint gauge
+highest_synced_checkpoint 1000
+# TYPE current_epoch gauge
+current_epoch{network="mainnet"} 42
+`
+
+// protoPage returns page in the length-delimited protobuf format.
+func protoPage(t *testing.T, page string) []byte {
+	t.Helper()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtProtoDelim)
+	for _, f := range families {
+		if err := enc.Encode(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// value returns the value of the single series of the named family, or -1.
+func value(families map[string]*dto.MetricFamily, name string) float64 {
+	v, err := gaugeValue(families, name)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+func TestParseMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+		want        map[string]float64
+		wantErr     bool
+	}{
+		{
+			name: "text without content type",
+			body: []byte(testPage),
+			want: map[string]float64{"highest_known_checkpoint": 1200, "highest_synced_checkpoint": 1000, "current_epoch": 42},
+		},
+		{
+			name:        "text",
+			contentType: "text/plain; version=0.0.4",
+			body:        []byte(testPage),
+			want:        map[string]float64{"highest_synced_checkpoint": 1000},
+		},
+		{
+			name:        "gzipped text",
+			contentType: "text/plain; version=0.0.4",
+			encoding:    "gzip",
+			body:        gzipped(t, []byte(testPage)),
+			want:        map[string]float64{"highest_known_checkpoint": 1200},
+		},
+		{
+			name:        "protobuf",
+			contentType: "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited",
+			body:        protoPage(t, testPage),
+			want:        map[string]float64{"highest_known_checkpoint": 1200, "current_epoch": 42},
+		},
+		{
+			name:        "OpenMetrics",
+			contentType: "application/openmetrics-text; version=1.0.0",
+			body: []byte(`# TYPE highest_synced_checkpoint gauge
+# UNIT highest_synced_checkpoint checkpoints
+highest_synced_checkpoint 1000 1700000000.123
+# TYPE checkpoints_synced counter
+checkpoints_synced_total{peer="a b"} 7 # {trace_id="abc"} 1 1700000000
+# TYPE build info
+build_info{version="1.2.3"} 1
+# EOF
+`),
+			want: map[string]float64{"highest_synced_checkpoint": 1000, "checkpoints_synced_total": 7, "build_info": 1},
+		},
+		{
+			name:    "malformed text",
+			body:    []byte("highest_synced_checkpoint one thousand\n"),
+			wantErr: true,
+		},
+		{
+			name:        "malformed protobuf",
+			contentType: "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited",
+			body:        []byte{0x05, 0xff},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.contentType != "" {
+				header.Set("Content-Type", tt.contentType)
+			}
+			if tt.encoding != "" {
+				header.Set("Content-Encoding", tt.encoding)
+			}
+			families, err := ParseMetrics(header, bytes.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := value(families, name); got != want {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenMetricsSample(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"up 1", "up 1"},
+		{"up 1 1700000000", "up 1"},
+		{`up{job="a"} 1`, `up{job="a"} 1`},
+		{`up{job="a b"} 1 1700000000`, `up{job="a b"} 1`},
+		{`requests_total 7 # {trace_id="abc"} 1 1700000000`, "requests_total 7"},
+		{`requests_total{code="200"} 7 1700000000 # {trace_id="abc"} 1`, `requests_total{code="200"} 7`},
+	}
+	for _, tt := range tests {
+		if got := openMetricsSample(tt.line); got != tt.want {
+			t.Errorf("openMetricsSample(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFetchMetricsNegotiates(t *testing.T) {
+	var accept, acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept, acceptEncoding = r.Header.Get("Accept"), r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipped(t, protoPage(t, testPage)))
+	}))
+	defer server.Close()
+
+	families, err := FetchMetrics(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(accept, "application/vnd.google.protobuf") {
+		t.Errorf("Accept %q does not prefer protobuf", accept)
+	}
+	if acceptEncoding != "gzip" {
+		t.Errorf("Accept-Encoding %q, want gzip", acceptEncoding)
+	}
+	if got := value(families, "highest_synced_checkpoint"); got != 1000 {
+		t.Errorf("got %v, want 1000", got)
+	}
+}
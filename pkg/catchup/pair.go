@@ -0,0 +1,100 @@
+package catchup
+
+import (
+	"fmt"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pairWatch is a parsed Options.Pairs entry and the rate of its gap.
+type pairWatch struct {
+	Pair
+	current, target expr
+	gap             gapTracker
+}
+
+// pairValue is the value of both expressions of a pair at a scrape.
+type pairValue struct {
+	current, target float64
+	err             error
+}
+
+// newPairWatches parses the pairs of opts, whose other fields must have their
+// defaults applied.
+func newPairWatches(opts Options) ([]*pairWatch, error) {
+	var pairs []*pairWatch
+	for _, p := range opts.Pairs {
+		switch p.Op {
+		case "":
+			p.Op = PairAtLeast
+		case PairAtLeast, PairAbove, PairAtMost, PairBelow:
+		default:
+			return nil, fmt.Errorf("unknown operator %q of pair %q", p.Op, p.Name)
+		}
+		current, err := parseExpr(p.Current)
+		if err != nil {
+			return nil, fmt.Errorf("invalid current value of pair %q: %v", p.Name, err)
+		}
+		target, err := parseExpr(p.Target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target of pair %q: %v", p.Name, err)
+		}
+		pairs = append(pairs, &pairWatch{Pair: p, current: current, target: target, gap: newGapTracker(opts)})
+	}
+	return pairs, nil
+}
+
+// evalPairs evaluates the pairs against the metric families of a scrape.
+func (w *Watcher) evalPairs(families map[string]*dto.MetricFamily) []pairValue {
+	values := make([]pairValue, len(w.pairs))
+	for i, pw := range w.pairs {
+		v := &values[i]
+		if v.current, v.err = pw.current.eval(families); v.err != nil {
+			continue
+		}
+		v.target, v.err = pw.target.eval(families)
+	}
+	return values
+}
+
+// updatePairs derives the states of the pairs from their values at a scrape,
+// as returned by evalPairs.
+func (w *Watcher) updatePairs(values []pairValue, at time.Time) []PairProgress {
+	var progress []PairProgress
+	for i, pw := range w.pairs {
+		pp := PairProgress{Name: pw.Name, Op: pw.Op}
+		v := values[i]
+		if v.err != nil {
+			pp.Err = v.err
+			progress = append(progress, pp)
+			continue
+		}
+		switch pw.Op {
+		case PairAtLeast, PairAbove:
+			pp.Gap = pw.gap.update(v.target, v.current, at)
+		default:
+			// A value falling towards its target closes the gap too.
+			pp.Gap = pw.gap.update(v.current, v.target, at)
+			pp.Target, pp.Current = v.target, v.current
+		}
+		switch pw.Op {
+		case PairAtLeast, PairAtMost:
+			pp.Reached = pp.Lag <= 0
+		default:
+			pp.Reached = pp.Lag < 0
+		}
+		progress = append(progress, pp)
+	}
+	return progress
+}
+
+// pairsReached reports whether the conditions of all pairs are met.
+func pairsReached(pairs []PairProgress) bool {
+	for _, pp := range pairs {
+		if !pp.Reached {
+			return false
+		}
+	}
+	return true
+}
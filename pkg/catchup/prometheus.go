@@ -0,0 +1,179 @@
+package catchup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+type promResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]interface{}    `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// watchedNames returns the names of every metric the Watcher may read: those
+// configured or already discovered, and every alias.
+func (w *Watcher) watchedNames() []string {
+	seen := map[string]bool{}
+	var names []string
+	add := func(list ...string) {
+		for _, name := range list {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	add(w.knownMetric, w.syncedMetric, w.executedMetric, w.epochMetric, w.knownTxMetric, w.executedTxMetric, w.peersMetric,
+		w.receivedMetric, w.committedMetric, w.dbSizeMetric, w.prunedMetric, w.prunedObjMetric, w.archiveMetric,
+		w.snapshot.partitions, w.snapshot.downloaded, w.snapshot.objects, w.snapshot.bytes,
+		w.process.cpu, w.process.rss, w.process.fds, w.process.maxFDs, w.process.queue, w.uptimeMetric, w.startTimeMetric,
+		w.protocolMetric)
+	for _, aliases := range [][]string{
+		knownAliases, syncedAliases, indexerKnownAliases, indexerSyncedAliases, archiveUploadedAliases,
+		executedAliases, epochAliases, knownTxAliases, executedTxAliases,
+		peersAliases, receivedRoundAliases, committedRoundAliases, dbSizeAliases,
+		prunedAliases, prunedObjectsAliases, archiveAliases,
+		snapshotPartitionsAliases, snapshotDownloadedAliases, snapshotObjectsAliases, snapshotBytesAliases,
+		processCPUAliases, processRSSAliases, processFDsAliases, processMaxFDsAliases, queueDepthAliases,
+		uptimeAliases, startTimeAliases, versionAliases, supportedProtocolAliases, protocolAliases,
+	} {
+		add(aliases...)
+	}
+	if w.lagExpr != nil {
+		add(w.lagExpr.metrics(nil)...)
+	}
+	for _, pw := range w.pairs {
+		add(pw.current.metrics(pw.target.metrics(nil))...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// queryMetricFamilies reads the watched metrics from the instant query API
+// of the Prometheus server at Options.PrometheusURL instead of scraping the
+// node, restricted to the series matching Options.QuerySelector. The result
+// is keyed by name as for fetchMetricFamilies, with every series as a gauge.
+func (w *Watcher) queryMetricFamilies(ctx context.Context) (map[string]*dto.MetricFamily, error) {
+	names := w.watchedNames()
+	for i, name := range names {
+		if w.patterns[name] == nil {
+			names[i] = regexp.QuoteMeta(name)
+		} else {
+			names[i] = "(?:" + name + ")"
+		}
+	}
+	// Also match namespaced names, as discoverMetric does.
+	matchers := []string{"__name__=~" + strconv.Quote("(.*_)?("+strings.Join(names, "|")+")")}
+	if sel := strings.Trim(strings.TrimSpace(w.opts.QuerySelector), "{}"); sel != "" {
+		matchers = append(matchers, sel)
+	}
+	query := "{" + strings.Join(matchers, ",") + "}"
+	return queryPrometheus(ctx, w.opts.PrometheusURL, w.opts.Transport, query, w.opts.ReplicaLabels)
+}
+
+// queryPrometheus runs an instant query against the Prometheus-compatible
+// HTTP API at base, e.g. of Prometheus, Thanos Query or VictoriaMetrics, and
+// returns the resulting series as gauge families keyed by name. Series that
+// differ only in the given replica labels, as from HA pairs of Prometheus
+// servers, are merged by taking the highest value. It fails if a name still
+// has several series, as the selector is then ambiguous.
+func queryPrometheus(ctx context.Context, base string, transport http.RoundTripper, query string, replicaLabels []string) (map[string]*dto.MetricFamily, error) {
+	params := url.Values{"query": {query}}
+	if len(replicaLabels) > 0 {
+		// Have Thanos deduplicate replicas itself; other servers ignore
+		// these parameters.
+		params.Set("dedup", "true")
+		params["replicaLabels[]"] = replicaLabels
+	}
+	u := strings.TrimSuffix(base, "/") + "/api/v1/query?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating GET request for URL %q failed: %v", redactURL(base), err)
+	}
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying Prometheus at %q failed: %v", redactURL(base), err)
+	}
+	defer resp.Body.Close()
+	var r promResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decoding Prometheus response from %q failed (HTTP status %s): %v", redactURL(base), resp.Status, err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("querying Prometheus at %q failed: %s", redactURL(base), r.Error)
+	}
+	if r.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("querying Prometheus at %q returned a %s instead of a vector", redactURL(base), r.Data.ResultType)
+	}
+
+	ignored := map[string]bool{"__name__": true}
+	for _, l := range replicaLabels {
+		ignored[l] = true
+	}
+	families := map[string]*dto.MetricFamily{}
+	seriesOf := map[string]string{} // name to the labels of its series
+	for _, series := range r.Data.Result {
+		name := series.Metric["__name__"]
+		s, _ := series.Value[1].(string)
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of %s from Prometheus: %v", s, name, err)
+		}
+		labels := seriesLabels(series.Metric, ignored)
+		if seen, ok := seriesOf[name]; ok {
+			if seen != labels {
+				return nil, fmt.Errorf("several series of %s match the query selector, e.g. %s and %s; make it match a single node", name, seen, labels)
+			}
+			if f := families[name]; v > f.GetMetric()[0].GetGauge().GetValue() {
+				families[name] = gaugeFamily(name, v)
+			}
+			continue
+		}
+		seriesOf[name] = labels
+		families[name] = gaugeFamily(name, v)
+	}
+	return families, nil
+}
+
+// seriesLabels formats the labels of a series other than the ignored ones,
+// e.g. {cluster="eu",instance="node-1:9184"}.
+func seriesLabels(metric map[string]string, ignored map[string]bool) string {
+	names := make([]string, 0, len(metric))
+	for name := range metric {
+		if !ignored[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + "=" + strconv.Quote(metric[name])
+	}
+	return "{" + strings.Join(names, ",") + "}"
+}
+
+// gaugeFamily returns a family holding a single gauge with value v.
+func gaugeFamily(name string, v float64) *dto.MetricFamily {
+	typ := dto.MetricType_GAUGE
+	return &dto.MetricFamily{
+		Name:   &name,
+		Type:   &typ,
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: &v}}},
+	}
+}
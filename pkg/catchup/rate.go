@@ -0,0 +1,234 @@
+package catchup
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// How a Watcher estimates rates, see Options.RateAlgorithm.
+const (
+	RateEWMA          = "ewma"
+	RateWindowAverage = "window"
+	RateMedian        = "median"
+	RateRegression    = "regression"
+)
+
+// DefaultRateSamples is the number of samples RateMedian and RateRegression
+// estimate the rate over unless Options.RateSamples says otherwise.
+const DefaultRateSamples = 30
+
+// ewma is an exponentially weighted moving average over irregularly spaced
+// samples. Each sample is weighted by how much time it covers, so the
+// smoothing does not depend on the scrape interval.
+type ewma struct {
+	tau   time.Duration
+	value float64
+	init  bool
+}
+
+func (e *ewma) add(sample float64, dt time.Duration) float64 {
+	if !e.init {
+		e.value = sample
+		e.init = true
+		return e.value
+	}
+	alpha := 1 - math.Exp(-dt.Seconds()/e.tau.Seconds())
+	e.value += alpha * (sample - e.value)
+	return e.value
+}
+
+// eta estimates how long it takes to close lag at rate units per second. It
+// returns zero when the lag is not shrinking.
+func eta(lag, rate float64) time.Duration {
+	if lag <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(lag / rate * float64(time.Second))
+}
+
+// growthTracker derives a smoothed growth rate per second of a value from
+// successive samples.
+type growthTracker struct {
+	rate ewma
+	last float64
+	at   time.Time
+}
+
+func newGrowthTracker(smoothing time.Duration) growthTracker {
+	return growthTracker{rate: ewma{tau: smoothing}}
+}
+
+// update records a sample taken at the given time and returns the smoothed
+// growth rate, zero until there are two samples.
+func (t *growthTracker) update(v float64, at time.Time) float64 {
+	var rate float64
+	if dt := at.Sub(t.at); !t.at.IsZero() && dt > 0 {
+		rate = t.rate.add((v-t.last)/dt.Seconds(), dt)
+	}
+	t.at = at
+	t.last = v
+	return rate
+}
+
+// lagSample is a lag observed at a point in time.
+type lagSample struct {
+	at  time.Time
+	lag float64
+}
+
+// lagWindow is a ring buffer of the most recent lag samples.
+type lagWindow struct {
+	samples []lagSample
+	next    int // where the next sample goes
+	full    bool
+}
+
+func newLagWindow(size int) *lagWindow {
+	return &lagWindow{samples: make([]lagSample, size)}
+}
+
+func (w *lagWindow) add(s lagSample) {
+	w.samples[w.next] = s
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// since returns the oldest sample taken at or after t other than the latest,
+// or the one before the latest if there is none. It reports false if there
+// is only one sample.
+func (w *lagWindow) since(t time.Time) (lagSample, bool) {
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	if n < 2 {
+		return lagSample{}, false
+	}
+	oldest := 0
+	if w.full {
+		oldest = w.next
+	}
+	for i := 0; i < n-2; i++ {
+		if s := w.samples[(oldest+i)%len(w.samples)]; !s.at.Before(t) {
+			return s, true
+		}
+	}
+	return w.samples[(oldest+n-2)%len(w.samples)], true
+}
+
+// ordered returns the samples from the oldest to the latest.
+func (w *lagWindow) ordered() []lagSample {
+	if !w.full {
+		return w.samples[:w.next]
+	}
+	return append(append([]lagSample(nil), w.samples[w.next:]...), w.samples[:w.next]...)
+}
+
+// medianRate returns the median of the rates at which the lag shrank between
+// successive samples, which ignores the outliers of bursty progress.
+func medianRate(samples []lagSample) float64 {
+	var rates []float64
+	for i := 1; i < len(samples); i++ {
+		if dt := samples[i].at.Sub(samples[i-1].at); dt > 0 {
+			rates = append(rates, (samples[i-1].lag-samples[i].lag)/dt.Seconds())
+		}
+	}
+	if len(rates) == 0 {
+		return 0
+	}
+	sort.Float64s(rates)
+	if n := len(rates); n%2 == 0 {
+		return (rates[n/2-1] + rates[n/2]) / 2
+	}
+	return rates[len(rates)/2]
+}
+
+// regressionRate returns the rate at which the lag shrinks according to a
+// least-squares fit of a line through the samples, which follows steady
+// progress closely while averaging over its noise.
+func regressionRate(samples []lagSample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sumT, sumLag float64
+	for _, s := range samples {
+		sumT += s.at.Sub(samples[0].at).Seconds()
+		sumLag += s.lag
+	}
+	n := float64(len(samples))
+	meanT, meanLag := sumT/n, sumLag/n
+	var cov, variance float64
+	for _, s := range samples {
+		dt := s.at.Sub(samples[0].at).Seconds() - meanT
+		cov += dt * (s.lag - meanLag)
+		variance += dt * dt
+	}
+	if variance == 0 {
+		return 0
+	}
+	return -cov / variance
+}
+
+// gapTracker derives a smoothed closing rate for a Gap from successive
+// samples with one of the rate algorithms: as a moving average, over the
+// samples taken during a window, or as the median of or a line fitted
+// through the last samples.
+type gapTracker struct {
+	algorithm string
+
+	rate ewma
+	last Gap
+	at   time.Time // when last was sampled
+
+	window  time.Duration
+	samples *lagWindow
+}
+
+// newGapTracker returns a tracker estimating the rate with
+// Options.RateAlgorithm, which New has validated.
+func newGapTracker(opts Options) gapTracker {
+	t := gapTracker{algorithm: opts.RateAlgorithm, rate: ewma{tau: opts.RateSmoothing}, window: opts.RateWindow}
+	switch t.algorithm {
+	case RateWindowAverage:
+		// Enough room for a sample every interval, plus the one before the
+		// window started.
+		t.samples = newLagWindow(int(t.window/opts.Interval) + 2)
+	case RateMedian, RateRegression:
+		t.samples = newLagWindow(opts.RateSamples)
+	}
+	return t
+}
+
+// update records a sample taken at the given time. Rates are computed over
+// the actual time between samples rather than the nominal interval, which
+// scrape latency and backoff after errors both stretch.
+func (t *gapTracker) update(target, current float64, at time.Time) Gap {
+	g := Gap{Target: target, Current: current, Lag: target - current}
+	if dt := at.Sub(t.at); !t.at.IsZero() && dt > 0 {
+		g.InstantRate = (t.last.Lag - g.Lag) / dt.Seconds()
+		g.Rate = t.rate.add(g.InstantRate, dt)
+	}
+	if t.samples != nil {
+		t.samples.add(lagSample{at, g.Lag})
+	}
+	switch t.algorithm {
+	case RateWindowAverage:
+		g.Rate = 0
+		if old, ok := t.samples.since(at.Add(-t.window)); ok {
+			if dt := at.Sub(old.at); dt > 0 {
+				g.Rate = (old.lag - g.Lag) / dt.Seconds()
+			}
+		}
+	case RateMedian:
+		g.Rate = medianRate(t.samples.ordered())
+	case RateRegression:
+		g.Rate = regressionRate(t.samples.ordered())
+	}
+	g.ETA = eta(g.Lag, g.Rate)
+	t.at = at
+	t.last = g
+	return g
+}
@@ -0,0 +1,210 @@
+package catchup
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []float64
+		dt      time.Duration
+		want    float64
+	}{
+		{"first sample", []float64{10}, time.Second, 10},
+		{"constant", []float64{5, 5, 5}, time.Second, 5},
+		// One time constant moves the average 1-1/e of the way.
+		{"one time constant", []float64{0, 100}, 10 * time.Second, 100 * (1 - math.Exp(-1))},
+		{"long after", []float64{0, 100}, time.Hour, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := ewma{tau: 10 * time.Second}
+			var got float64
+			for _, s := range tt.samples {
+				got = e.add(s, tt.dt)
+			}
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestETA(t *testing.T) {
+	tests := []struct {
+		lag, rate float64
+		want      time.Duration
+	}{
+		{100, 10, 10 * time.Second},
+		{1, 4, 250 * time.Millisecond},
+		{0, 10, 0},
+		{-5, 10, 0},
+		{100, 0, 0},
+		{100, -1, 0},
+	}
+	for _, tt := range tests {
+		if got := eta(tt.lag, tt.rate); got != tt.want {
+			t.Errorf("eta(%v, %v) = %v, want %v", tt.lag, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestGrowthTracker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	g := newGrowthTracker(time.Millisecond)
+	if got := g.update(100, start); got != 0 {
+		t.Errorf("first sample: got %v, want 0", got)
+	}
+	if got := g.update(100, start); got != 0 {
+		t.Errorf("same time: got %v, want 0", got)
+	}
+	// A smoothing far shorter than the interval follows the latest rate.
+	if got := g.update(150, start.Add(10*time.Second)); math.Abs(got-5) > 1e-6 {
+		t.Errorf("growing: got %v, want 5", got)
+	}
+	if got := g.update(140, start.Add(20*time.Second)); math.Abs(got+1) > 1e-6 {
+		t.Errorf("shrinking: got %v, want -1", got)
+	}
+}
+
+func TestGapTrackerEWMA(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tr := newGapTracker(Options{RateAlgorithm: RateEWMA, RateSmoothing: time.Millisecond, Interval: time.Second})
+	steps := []struct {
+		target, current float64
+		at              time.Duration
+		lag, rate       float64
+		eta             time.Duration
+	}{
+		{1000, 0, 0, 1000, 0, 0},
+		// Rates are per second of the actual time between samples.
+		{1000, 100, 2 * time.Second, 900, 50, 18 * time.Second},
+		{1100, 300, 4 * time.Second, 800, 50, 16 * time.Second},
+		// A growing lag has no ETA.
+		{1300, 300, 5 * time.Second, 1000, -200, 0},
+	}
+	for i, s := range steps {
+		g := tr.update(s.target, s.current, start.Add(s.at))
+		if g.Lag != s.lag || math.Abs(g.Rate-s.rate) > 1e-6 || g.ETA != s.eta {
+			t.Errorf("step %d: got lag %v, rate %v, ETA %v, want %v, %v, %v", i, g.Lag, g.Rate, g.ETA, s.lag, s.rate, s.eta)
+		}
+	}
+}
+
+// lagSamples returns samples of lags taken a second apart.
+func lagSamples(lags ...float64) []lagSample {
+	start := time.Unix(1000, 0)
+	samples := make([]lagSample, len(lags))
+	for i, lag := range lags {
+		samples[i] = lagSample{start.Add(time.Duration(i) * time.Second), lag}
+	}
+	return samples
+}
+
+func TestMedianRate(t *testing.T) {
+	tests := []struct {
+		name string
+		lags []float64
+		want float64
+	}{
+		{"no samples", nil, 0},
+		{"one sample", []float64{100}, 0},
+		{"steady", []float64{100, 90, 80, 70}, 10},
+		{"burst ignored", []float64{100, 90, 80, 0, -10}, 10},
+		{"even count", []float64{100, 90, 70, 50, 40}, 15},
+		{"growing", []float64{100, 110, 120}, -10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medianRate(lagSamples(tt.lags...)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegressionRate(t *testing.T) {
+	tests := []struct {
+		name string
+		lags []float64
+		want float64
+	}{
+		{"no samples", nil, 0},
+		{"one sample", []float64{100}, 0},
+		{"steady", []float64{100, 90, 80, 70}, 10},
+		{"noisy", []float64{100, 92, 78, 70}, 10.4},
+		{"growing", []float64{100, 110, 120}, -10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := regressionRate(lagSamples(tt.lags...)); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLagWindow(t *testing.T) {
+	w := newLagWindow(3)
+	if _, ok := w.since(time.Time{}); ok {
+		t.Error("empty window: got a sample")
+	}
+	samples := lagSamples(100, 90, 80, 70)
+	for _, s := range samples {
+		w.add(s)
+	}
+	if got := w.ordered(); len(got) != 3 || got[0] != samples[1] || got[2] != samples[3] {
+		t.Errorf("ordered: got %v, want the last 3 samples", got)
+	}
+	tests := []struct {
+		since time.Time
+		want  lagSample
+	}{
+		{samples[0].at, samples[1]},
+		{samples[2].at, samples[2]},
+		// The latest sample is never returned.
+		{samples[3].at, samples[2]},
+	}
+	for _, tt := range tests {
+		if got, ok := w.since(tt.since); !ok || got != tt.want {
+			t.Errorf("since(%v) = %v, %v, want %v", tt.since, got, ok, tt.want)
+		}
+	}
+}
+
+func TestGapTrackerAlgorithms(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		lags      []float64
+		want      float64
+	}{
+		// The window covers the last 2 seconds only.
+		{RateWindowAverage, []float64{100, 50, 40, 30}, 10},
+		{RateMedian, []float64{100, 50, 40, 30, 20}, 10},
+		{RateRegression, []float64{100, 90, 80, 70}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			tracker := newGapTracker(Options{
+				RateAlgorithm: tt.algorithm,
+				RateSmoothing: time.Minute,
+				RateWindow:    2 * time.Second,
+				RateSamples:   DefaultRateSamples,
+				Interval:      time.Second,
+			})
+			var g Gap
+			for _, s := range lagSamples(tt.lags...) {
+				g = tracker.update(1000, 1000-s.lag, s.at)
+			}
+			if math.Abs(g.Rate-tt.want) > 1e-9 {
+				t.Errorf("got rate %v, want %v", g.Rate, tt.want)
+			}
+			if want := time.Duration(g.Lag / tt.want * float64(time.Second)); g.ETA != want {
+				t.Errorf("got ETA %v, want %v", g.ETA, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package catchup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryStatus are the HTTP status codes retried by default: those of a
+// proxy in front of a node that is booting or restarting.
+var DefaultRetryStatus = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// retryDelay is the wait before the first retry of a request within a
+// scrape, doubled for every further retry.
+const retryDelay = 250 * time.Millisecond
+
+// retryTransport retries requests to the node that fail to connect, time out
+// or return one of a set of HTTP status codes, within a single scrape.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	status  map[int]bool
+	timeout time.Duration
+}
+
+// newRetryTransport returns base retrying requests as given by opts, or base
+// itself if opts neither retries nor bounds attempts.
+func newRetryTransport(base http.RoundTripper, opts Options) http.RoundTripper {
+	if opts.Retries == 0 && opts.AttemptTimeout <= 0 {
+		return base
+	}
+	t := &retryTransport{base: base, retries: opts.Retries, status: map[int]bool{}, timeout: opts.AttemptTimeout}
+	for _, code := range opts.RetryStatus {
+		t.status[code] = true
+	}
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := req.Context(), context.CancelFunc(func() {})
+		if t.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		}
+		r := req.Clone(ctx)
+		// A request body can only be sent again if it can be recreated.
+		retry := attempt < t.retries && (req.Body == nil || req.GetBody != nil)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			r.Body = body
+		}
+		resp, err := t.base.RoundTrip(r)
+		// A response asking to retry later is left to the watcher to
+		// honor rather than retried on schedule.
+		if err == nil && !(retry && t.status[resp.StatusCode] && resp.Header.Get("Retry-After") == "") {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if err == nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			err = fmt.Errorf("HTTP status %s", resp.Status)
+		} else if errors.Is(ctx.Err(), context.DeadlineExceeded) && req.Context().Err() == nil {
+			err = fmt.Errorf("attempt timed out after %v: %w", t.timeout, err)
+		}
+		cancel()
+		if !retry || req.Context().Err() != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(retryDelay << attempt)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%v, not retried: %w", err, req.Context().Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAfter returns how long the Retry-After header of a response received
+// at now asks to wait, in seconds or until an HTTP date, and whether it is
+// set.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, true
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (t *retryTransport) CloseIdleConnections() {
+	(&http.Client{Transport: t.base}).CloseIdleConnections()
+}
+
+// cancelBody releases the context of an attempt once its response has been
+// read.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
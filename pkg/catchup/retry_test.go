@@ -0,0 +1,144 @@
+package catchup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      int
+		statuses     []int // returned by successive attempts, the last one repeated
+		retryAfter   string
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{"success", 2, []int{200}, "", 200, 1},
+		{"retried status", 2, []int{503, 502, 200}, "", 200, 3},
+		// The last attempt's response is returned as is.
+		{"retries exhausted", 1, []int{503}, "", 503, 2},
+		{"status not retried", 2, []int{500, 200}, "", 500, 1},
+		{"retry after left to the watcher", 2, []int{503, 200}, "5", 503, 1},
+		{"no retries", 0, []int{503, 200}, "", 503, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&attempts, 1))
+				status := tt.statuses[min(n, len(tt.statuses))-1]
+				if status != 200 && tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			transport := newRetryTransport(http.DefaultTransport, Options{Retries: tt.retries, RetryStatus: DefaultRetryStatus})
+			resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransportAttemptTimeout(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := newRetryTransport(http.DefaultTransport, Options{Retries: 1, AttemptTimeout: 50 * time.Millisecond})
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+
+	// Without a retry left, the timeout is reported as such.
+	atomic.StoreInt32(&attempts, 0)
+	transport = newRetryTransport(http.DefaultTransport, Options{AttemptTimeout: 50 * time.Millisecond})
+	_, err = (&http.Client{Transport: transport}).Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "attempt timed out") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want an attempt timeout", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-1", 0, true},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.value != "" {
+			header.Set("Retry-After", tt.value)
+		}
+		got, ok := retryAfter(header, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFetchMetricsStatus(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		wantRetry  bool
+	}{
+		{http.StatusInternalServerError, "", false},
+		{http.StatusTooManyRequests, "", true},
+		{http.StatusServiceUnavailable, "5", true},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.retryAfter != "" {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(tt.status)
+		}))
+		_, err := FetchMetrics(context.Background(), server.URL, nil)
+		server.Close()
+		if err == nil {
+			t.Errorf("status %d: got no error", tt.status)
+			continue
+		}
+		if _, ok := err.(*RetryAfterError); ok != tt.wantRetry {
+			t.Errorf("status %d: got %v, want a RetryAfterError %v", tt.status, err, tt.wantRetry)
+		}
+	}
+}
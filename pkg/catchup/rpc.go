@@ -0,0 +1,167 @@
+package catchup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// callRPC invokes a Sui JSON-RPC method and decodes its result into result.
+func callRPC(ctx context.Context, url string, transport http.RoundTripper, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating POST request for URL %q failed: %v", redactURL(url), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing POST request for URL %q failed: %v", redactURL(url), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST request for URL %q returned HTTP status %s", redactURL(url), resp.Status)
+	}
+	var r rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("decoding %s response from %q failed: %v", method, redactURL(url), err)
+	}
+	if r.Error != nil {
+		return fmt.Errorf("%s on %q failed: %s (code %d)", method, redactURL(url), r.Error.Message, r.Error.Code)
+	}
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		return fmt.Errorf("decoding %s result from %q failed: %v", method, redactURL(url), err)
+	}
+	return nil
+}
+
+// latestCheckpoint returns the latest checkpoint sequence number reported by
+// a Sui JSON-RPC endpoint. Sequence numbers are encoded as decimal strings.
+func latestCheckpoint(ctx context.Context, url string, transport http.RoundTripper) (float64, error) {
+	var seq string
+	if err := callRPC(ctx, url, transport, "sui_getLatestCheckpointSequenceNumber", &seq); err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint sequence number %q from %q", seq, redactURL(url))
+	}
+	return float64(n), nil
+}
+
+// latestSystemState returns the network's current epoch and protocol version
+// as reported by a Sui JSON-RPC endpoint.
+func latestSystemState(ctx context.Context, url string, transport http.RoundTripper) (epoch, protocolVersion float64, err error) {
+	var state struct {
+		Epoch           string `json:"epoch"`
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := callRPC(ctx, url, transport, "suix_getLatestSuiSystemState", &state); err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(state.Epoch, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid epoch %q from %q", state.Epoch, redactURL(url))
+	}
+	v, err := strconv.ParseUint(state.ProtocolVersion, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid protocol version %q from %q", state.ProtocolVersion, redactURL(url))
+	}
+	return float64(n), float64(v), nil
+}
+
+// atEpochEnd reports whether checkpoint seq is the last of its epoch, asking
+// Options.TipURL or Options.VerifyURL once per checkpoint. It returns false if
+// neither is set or the endpoint cannot tell.
+func (w *Watcher) atEpochEnd(ctx context.Context, seq float64) bool {
+	url := w.opts.TipURL
+	if url == "" {
+		url = w.opts.VerifyURL
+	}
+	if url == "" {
+		return false
+	}
+	if seq != w.endChecked {
+		var checkpoint struct {
+			EndOfEpochData json.RawMessage `json:"endOfEpochData"`
+		}
+		err := callRPC(ctx, url, w.opts.Transport, "sui_getCheckpoint", &checkpoint, strconv.FormatUint(uint64(seq), 10))
+		if err != nil {
+			return false
+		}
+		w.endChecked = seq
+		w.endsEpoch = len(checkpoint.EndOfEpochData) != 0 && string(checkpoint.EndOfEpochData) != "null"
+	}
+	return w.endsEpoch
+}
+
+// fetchTip reads the network tip, epoch and protocol version from
+// Options.TipURL and Options.TipURLs into s, agreeing on each by
+// Options.TipQuorum. It fails only if no endpoint reports a checkpoint.
+func (w *Watcher) fetchTip(ctx context.Context, s *sample) error {
+	var first error
+	var checkpoints, epochs, protocols []float64
+	for _, url := range append([]string{w.opts.TipURL}, w.opts.TipURLs...) {
+		seq, err := latestCheckpoint(ctx, url, w.opts.Transport)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		checkpoints = append(checkpoints, seq)
+		// The epoch is informational, so failing to get it is no reason
+		// to discard the checkpoint.
+		if epoch, protocol, err := latestSystemState(ctx, url, w.opts.Transport); err == nil {
+			epochs = append(epochs, epoch)
+			protocols = append(protocols, protocol)
+		}
+	}
+	if len(checkpoints) == 0 {
+		return first
+	}
+	s.known = quorum(checkpoints, w.opts.TipQuorum)
+	s.networkEpoch = quorum(epochs, w.opts.TipQuorum)
+	s.networkProtocol = quorum(protocols, w.opts.TipQuorum)
+	return nil
+}
+
+// quorum returns the median of values, the higher of the middle two for an
+// even number of them, or their maximum with TipMax. It returns 0 if there
+// are no values.
+func quorum(values []float64, how string) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	if how == TipMax {
+		return values[len(values)-1]
+	}
+	return values[len(values)/2]
+}
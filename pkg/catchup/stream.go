@@ -0,0 +1,103 @@
+package catchup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// fullScanEvery is how often, in scrapes, a page is read to the end even
+// though every family expected has been read, so that the families a node
+// starts to expose later, e.g. once it restores a snapshot, are found.
+const fullScanEvery = 60
+
+// maxDrain bounds how much of a page left unread is drained to reuse the
+// connection, rather than closing it.
+const maxDrain = 16 << 20
+
+// scan reads the lines of the families p watches from in, a page of the
+// Prometheus text format, skipping the others without parsing them, and
+// stops after the last of the expected families. sui-node's pages run to
+// megabytes, of which a watcher reads a few dozen lines.
+func (p *metricsParser) scan(in io.Reader) ([]byte, error) {
+	p.found = map[string]bool{}
+	remaining := len(p.expected)
+	var out bytes.Buffer
+	var family string
+	var keep bool
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// Families are contiguous, so once another starts the one before
+		// has been read whole.
+		if name := lineFamily(line); name != "" && (family == "" || !sameFamily(family, name, line)) {
+			if len(p.expected) > 0 && remaining == 0 {
+				p.stopped = true
+				break
+			}
+			family, keep = name, p.watched(name)
+			if keep && !p.found[name] {
+				p.found[name] = true
+				if p.expected[name] {
+					remaining--
+				}
+			}
+		}
+		if keep {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading text format failed: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// watches reports whether name is that of a family the Watcher may read,
+// or a namespaced one discoverMetric would take for it.
+func (w *Watcher) watches(name string) bool {
+	if w.watchSet == nil {
+		w.watchSet = map[string]bool{}
+		for _, n := range w.watchedNames() {
+			if w.patterns[n] == nil {
+				w.watchSet[n] = true
+			}
+		}
+	}
+	if w.watchSet[name] {
+		return true
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '_' && w.watchSet[name[i+1:]] {
+			return true
+		}
+	}
+	for _, re := range w.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parser returns the parser of the node's pages, which reads only the
+// watched families unless Options.ParseAll or Options.Inspect needs them
+// all, and stops early after those found by the last full read.
+func (w *Watcher) parser() metricsParser {
+	p := metricsParser{tolerant: w.opts.TolerantParsing}
+	if w.opts.ParseAll || w.opts.Inspect != nil {
+		return p
+	}
+	p.watched = w.watches
+	if w.scrape%fullScanEvery != 0 {
+		p.expected = w.expected
+	}
+	return p
+}
@@ -0,0 +1,125 @@
+package catchup
+
+import (
+	"strings"
+	"testing"
+)
+
+const streamPage = `# TYPE a gauge
+a 1
+# TYPE highest_known_checkpoint gauge
+highest_known_checkpoint 1200
+# TYPE b histogram
+b_bucket{le="+Inf"} 1
+b_sum 2
+b_count 1
+# TYPE highest_synced_checkpoint gauge
+highest_synced_checkpoint 1000
+# TYPE c gauge
+c 3
+`
+
+func TestScan(t *testing.T) {
+	watched := map[string]bool{"highest_known_checkpoint": true, "highest_synced_checkpoint": true, "b": true}
+	tests := []struct {
+		name     string
+		expected []string
+		want     string
+		stopped  bool
+	}{
+		{
+			name: "full read",
+			want: "# TYPE highest_known_checkpoint gauge\nhighest_known_checkpoint 1200\n" +
+				"# TYPE b histogram\nb_bucket{le=\"+Inf\"} 1\nb_sum 2\nb_count 1\n" +
+				"# TYPE highest_synced_checkpoint gauge\nhighest_synced_checkpoint 1000\n",
+		},
+		{
+			name:     "stops after the expected families",
+			expected: []string{"highest_known_checkpoint", "b"},
+			want: "# TYPE highest_known_checkpoint gauge\nhighest_known_checkpoint 1200\n" +
+				"# TYPE b histogram\nb_bucket{le=\"+Inf\"} 1\nb_sum 2\nb_count 1\n",
+			stopped: true,
+		},
+		{
+			name:     "expected family missing",
+			expected: []string{"highest_known_checkpoint", "missing"},
+			want: "# TYPE highest_known_checkpoint gauge\nhighest_known_checkpoint 1200\n" +
+				"# TYPE b histogram\nb_bucket{le=\"+Inf\"} 1\nb_sum 2\nb_count 1\n" +
+				"# TYPE highest_synced_checkpoint gauge\nhighest_synced_checkpoint 1000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := metricsParser{watched: func(name string) bool { return watched[name] }}
+			if tt.expected != nil {
+				p.expected = map[string]bool{}
+				for _, name := range tt.expected {
+					p.expected[name] = true
+				}
+			}
+			got, err := p.scan(strings.NewReader(streamPage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+			if p.stopped != tt.stopped {
+				t.Errorf("got stopped %v, want %v", p.stopped, tt.stopped)
+			}
+			if !p.found["highest_known_checkpoint"] || p.found["a"] {
+				t.Errorf("got found %v", p.found)
+			}
+		})
+	}
+}
+
+func TestWatches(t *testing.T) {
+	w, err := New(Options{Addr: "http://localhost:9184/metrics", SyncedMetric: "custom_synced"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"highest_known_checkpoint", true},
+		{"custom_synced", true},
+		// Namespaced, as discoverMetric finds it.
+		{"sui_highest_known_checkpoint", true},
+		{"current_epoch", true},
+		{"highest_known_checkpoint_total", false},
+		{"unrelated_metric", false},
+	}
+	for _, tt := range tests {
+		if got := w.watches(tt.name); got != tt.want {
+			t.Errorf("watches(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParserFullScan(t *testing.T) {
+	w, err := New(Options{Addr: "http://localhost:9184/metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.expected = map[string]bool{"highest_known_checkpoint": true}
+	for _, tt := range []struct {
+		scrape       int
+		wantExpected bool
+	}{
+		{1, true},
+		{fullScanEvery, false},
+		{fullScanEvery + 1, true},
+	} {
+		w.scrape = tt.scrape
+		if p := w.parser(); (p.expected != nil) != tt.wantExpected {
+			t.Errorf("scrape %d: got expected %v", tt.scrape, p.expected)
+		}
+	}
+
+	w.opts.ParseAll = true
+	if p := w.parser(); p.watched != nil {
+		t.Error("ParseAll: got a restricted parser")
+	}
+}
@@ -0,0 +1,135 @@
+package catchup
+
+import (
+	"bytes"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// parseTolerant parses a page of the Prometheus text format that fails to
+// parse as a whole family by family, skipping the lines that fail to parse
+// on their own, so that one malformed line, e.g. of a metric with a
+// mis-escaped label, does not lose the checkpoint watermarks. It returns the
+// families parsed and the number of lines skipped. A family whose lines
+// only fail together, e.g. because of a duplicate series, is skipped whole.
+func parseTolerant(page []byte) (map[string]*dto.MetricFamily, int) {
+	families := map[string]*dto.MetricFamily{}
+	skipped := 0
+	for _, block := range familyBlocks(page) {
+		parsed, n := parseBlock(block)
+		skipped += n
+		for name, f := range parsed {
+			prev, ok := families[name]
+			switch {
+			case !ok:
+				families[name] = f
+			case prev.GetType() == f.GetType():
+				// A family split in two is not contiguous, which the
+				// text format does not allow either.
+				prev.Metric = append(prev.Metric, f.Metric...)
+			default:
+				skipped += len(f.Metric)
+			}
+		}
+	}
+	return families, skipped
+}
+
+// familyBlocks splits a page of the text format into the lines of each
+// family, its HELP and TYPE comments and its samples, which for histograms
+// and summaries have the _bucket, _sum and _count suffixes.
+func familyBlocks(page []byte) [][]string {
+	var blocks [][]string
+	var family string
+	for _, line := range strings.Split(string(page), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name := lineFamily(line)
+		if name == "" {
+			// Other comments go with the family they are in.
+			if len(blocks) > 0 {
+				blocks[len(blocks)-1] = append(blocks[len(blocks)-1], line)
+			}
+			continue
+		}
+		if len(blocks) == 0 || !sameFamily(family, name, line) {
+			blocks = append(blocks, nil)
+			family = name
+		}
+		blocks[len(blocks)-1] = append(blocks[len(blocks)-1], line)
+	}
+	return blocks
+}
+
+// lineFamily returns the metric named by a HELP or TYPE comment or a sample
+// line, or "" for other comments.
+func lineFamily(line string) string {
+	if strings.HasPrefix(line, "#") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && (fields[1] == "HELP" || fields[1] == "TYPE") {
+			return fields[2]
+		}
+		return ""
+	}
+	if i := strings.IndexAny(line, "{ \t"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// sameFamily reports whether a line naming metric name belongs to family.
+func sameFamily(family, name, line string) bool {
+	if name == family {
+		return true
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if name == family+suffix && !strings.HasPrefix(line, "#") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBlock parses the lines of a family, leaving out those that fail to
+// parse on their own along with its comments, and returns the families
+// parsed and the number of lines left out.
+func parseBlock(lines []string) (map[string]*dto.MetricFamily, int) {
+	if families, err := parseLines(lines); err == nil {
+		return families, 0
+	}
+	var comments, samples []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			if _, err := parseLines([]string{line}); err == nil {
+				comments = append(comments, line)
+			}
+			continue
+		}
+		samples = append(samples, line)
+	}
+	good := append([]string(nil), comments...)
+	for _, line := range samples {
+		if _, err := parseLines(append(comments[:len(comments):len(comments)], line)); err == nil {
+			good = append(good, line)
+		}
+	}
+	families, err := parseLines(good)
+	if err != nil {
+		return nil, len(samples)
+	}
+	return families, len(lines) - len(good)
+}
+
+func parseLines(lines []string) (map[string]*dto.MetricFamily, error) {
+	var b bytes.Buffer
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(&b)
+}
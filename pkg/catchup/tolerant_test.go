@@ -0,0 +1,147 @@
+package catchup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTolerant(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		want    map[string]float64
+		missing []string
+		skipped int
+	}{
+		{
+			name: "valid",
+			page: testPage,
+			want: map[string]float64{"highest_known_checkpoint": 1200, "highest_synced_checkpoint": 1000},
+		},
+		{
+			name: "malformed line",
+			page: `# TYPE highest_synced_checkpoint gauge
+highest_synced_checkpoint 1000
+# TYPE peers gauge
+peers{peer="a\q"} 1
+peers{peer="b"} 2
+`,
+			want:    map[string]float64{"highest_synced_checkpoint": 1000, "peers": 2},
+			skipped: 1,
+		},
+		{
+			name: "malformed value",
+			page: `highest_known_checkpoint 1200
+highest_synced_checkpoint one thousand
+`,
+			want:    map[string]float64{"highest_known_checkpoint": 1200},
+			missing: []string{"highest_synced_checkpoint"},
+			skipped: 1,
+		},
+		{
+			name: "lines failing together",
+			page: `highest_known_checkpoint 1200
+# TYPE up gauge
+up{job="a"} 1
+# TYPE up counter
+up{job="b"} 2
+`,
+			want:    map[string]float64{"highest_known_checkpoint": 1200},
+			missing: []string{"up"},
+			skipped: 2,
+		},
+		{
+			name: "histogram",
+			page: `# TYPE latency histogram
+latency_bucket{le="1"} 1
+latency_bucket{le="+Inf"} 2
+latency_sum 3
+latency_count 2
+latency_bogus{ 1
+highest_synced_checkpoint 1000
+`,
+			want:    map[string]float64{"highest_synced_checkpoint": 1000},
+			skipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families, skipped := parseTolerant([]byte(tt.page))
+			if skipped != tt.skipped {
+				t.Errorf("got %d lines skipped, want %d", skipped, tt.skipped)
+			}
+			for name, want := range tt.want {
+				if got := value(families, name); got != want {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}
+			for _, name := range tt.missing {
+				if _, ok := families[name]; ok {
+					t.Errorf("%s: got a family, want none", name)
+				}
+			}
+		})
+	}
+}
+
+func TestFamilyBlocks(t *testing.T) {
+	page := `# HELP a_total Counts.
+# TYPE a_total counter
+a_total 1
+# a stray comment
+# TYPE lat histogram
+lat_bucket{le="+Inf"} 1
+lat_sum 2
+lat_count 1
+# TYPE lat_count gauge
+lat_count 5
+`
+	want := [][]string{
+		{"# HELP a_total Counts.", "# TYPE a_total counter", "a_total 1", "# a stray comment"},
+		{"# TYPE lat histogram", `lat_bucket{le="+Inf"} 1`, "lat_sum 2", "lat_count 1"},
+		{"# TYPE lat_count gauge", "lat_count 5"},
+	}
+	if got := familyBlocks([]byte(page)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLineFamily(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"# HELP up Whether up.", "up"},
+		{"# TYPE up gauge", "up"},
+		{"# just a comment", ""},
+		{"#", ""},
+		{`up{job="a"} 1`, "up"},
+		{"up 1", "up"},
+		{"up\t1", "up"},
+		{"up", "up"},
+	}
+	for _, tt := range tests {
+		if got := lineFamily(tt.line); got != tt.want {
+			t.Errorf("lineFamily(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSameFamily(t *testing.T) {
+	tests := []struct {
+		family, name, line string
+		want               bool
+	}{
+		{"lat", "lat", "lat 1", true},
+		{"lat", "lat_bucket", `lat_bucket{le="1"} 1`, true},
+		{"lat", "lat_sum", "lat_sum 1", true},
+		{"lat", "lat_count", "lat_count 1", true},
+		// A family of its own named like a suffix.
+		{"lat", "lat_count", "# TYPE lat_count gauge", false},
+		{"lat", "latency", "latency 1", false},
+	}
+	for _, tt := range tests {
+		if got := sameFamily(tt.family, tt.name, tt.line); got != tt.want {
+			t.Errorf("sameFamily(%q, %q, %q) = %v, want %v", tt.family, tt.name, tt.line, got, tt.want)
+		}
+	}
+}
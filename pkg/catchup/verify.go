@@ -0,0 +1,56 @@
+package catchup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Verification is the result of comparing the node's latest checkpoint with
+// the same checkpoint on Options.TrustedURL, see Options.VerifyURL.
+type Verification struct {
+	// Checkpoint is the node's latest checkpoint, Digest its digest on the
+	// node and TrustedDigest its digest on the trusted endpoint.
+	Checkpoint    float64
+	Digest        string
+	TrustedDigest string
+
+	// Verified is set when both digests are the same, and Err is why they
+	// could not be compared otherwise.
+	Verified bool
+	Err      error
+}
+
+// verify compares the digest of the latest checkpoint on Options.VerifyURL
+// with that on Options.TrustedURL. Numbers alone cannot tell a node on a fork
+// from one on the canonical chain.
+func (w *Watcher) verify(ctx context.Context) *Verification {
+	v := &Verification{}
+	if v.Checkpoint, v.Err = latestCheckpoint(ctx, w.opts.VerifyURL, w.opts.Transport); v.Err != nil {
+		return v
+	}
+	if v.Digest, v.Err = checkpointDigest(ctx, w.opts.VerifyURL, w.opts.Transport, v.Checkpoint); v.Err != nil {
+		return v
+	}
+	if v.TrustedDigest, v.Err = checkpointDigest(ctx, w.opts.TrustedURL, w.opts.Transport, v.Checkpoint); v.Err != nil {
+		return v
+	}
+	v.Verified = v.Digest == v.TrustedDigest
+	return v
+}
+
+// checkpointDigest returns the digest of a checkpoint as reported by a Sui
+// JSON-RPC endpoint.
+func checkpointDigest(ctx context.Context, url string, transport http.RoundTripper, seq float64) (string, error) {
+	var checkpoint struct {
+		Digest string `json:"digest"`
+	}
+	if err := callRPC(ctx, url, transport, "sui_getCheckpoint", &checkpoint, strconv.FormatUint(uint64(seq), 10)); err != nil {
+		return "", err
+	}
+	if checkpoint.Digest == "" {
+		return "", fmt.Errorf("no digest for checkpoint %d from %q", uint64(seq), redactURL(url))
+	}
+	return checkpoint.Digest, nil
+}
@@ -0,0 +1,1021 @@
+package catchup
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Options configures a Watcher.
+type Options struct {
+	// Addr is the URL of the node's Prometheus metrics endpoint.
+	Addr string
+
+	// FallbackAddrs are other URLs of the same node's endpoint, e.g. its pod
+	// IP and an ingress besides localhost. When the endpoint in use fails,
+	// the others are tried in order, Addr first, and the first one that
+	// works is used from then on.
+	FallbackAddrs []string
+
+	// PrometheusURL, if set, is the base URL of a Prometheus server whose
+	// query API the watermarks are read from instead of scraping Addr.
+	// QuerySelector picks the node's series, e.g. `instance="node-1:9184"`,
+	// and must match a single series of each metric.
+	PrometheusURL string
+	QuerySelector string
+
+	// ReplicaLabels are the labels that tell apart the replicas of an HA
+	// pair of Prometheus servers behind PrometheusURL, e.g. "replica" for
+	// Thanos. Series differing only in them count as the same series.
+	ReplicaLabels []string
+
+	// Interval is how often the endpoint is scraped. Defaults to one second.
+	Interval time.Duration
+
+	// MaxInterval, if greater than Interval, makes the interval adaptive:
+	// scrapes are spaced by a hundredth of the ETA, between Interval and
+	// MaxInterval. A node far behind is then not scraped needlessly often,
+	// while its final convergence is still seen every Interval.
+	MaxInterval time.Duration
+
+	// KnownMetric and SyncedMetric are the names of the gauges holding the
+	// highest known and highest synced checkpoints. When empty, the names are
+	// discovered on the first scrape among those used by sui-node releases.
+	//
+	// This and every other metric name option may also be a regular
+	// expression matching whole names, such as
+	// `highest_(known|verified)_checkpoint`, in which case the highest value
+	// of the metrics it matches is read. This copes with renames and
+	// namespace prefixes without knowing the exact name.
+	KnownMetric  string
+	SyncedMetric string
+
+	// LagExpr, if set, defines the lag as an expression over the node's
+	// metrics instead of the distance between KnownMetric and SyncedMetric,
+	// e.g. `highest_known_checkpoint - last_executed_checkpoint`. It
+	// supports numbers, + - * / and parentheses, and metrics with PromQL
+	// label matchers such as `name{label="value"}`, each of which must pick
+	// a single series.
+	LagExpr string
+
+	// Pairs are further conditions to wait for, each tracked with its own
+	// gap and rate. The node has caught up only once every one is met.
+	// They need the node's metrics, so are not supported with ModeGraphQL,
+	// SourceGRPC or SourceExec.
+	Pairs []Pair
+
+	// PairsOnly makes the node count as caught up once every one of Pairs
+	// is met, whatever its lag, e.g. to wait for it to pass an epoch
+	// boundary.
+	PairsOnly bool
+
+	// ExecutedMetric is the name of the gauge holding the highest executed
+	// checkpoint, which is discovered like KnownMetric when empty.
+	ExecutedMetric string
+
+	// RequireExecuted makes the node count as caught up only once it has
+	// also executed everything up to the tip, not just synced it.
+	RequireExecuted bool
+
+	// Labels, if set, selects the series of the node's metrics that carry
+	// these label values, e.g. {"store": "perpetual"}, for watermarks that
+	// the node exposes once per store or network. Only the labels a metric
+	// carries are matched, so metrics without them are left alone.
+	Labels map[string]string
+
+	// EpochMetric is the name of the gauge holding the node's current epoch,
+	// which is discovered when empty. The epoch is informational only.
+	EpochMetric string
+
+	// DBSizeMetric is the name of the gauge holding the size of the node's
+	// database in bytes, summed over its series, which is discovered when
+	// empty. It is informational only.
+	DBSizeMetric string
+
+	// PrunedMetric is the name of the gauge holding the highest checkpoint
+	// the node has pruned, and PrunedObjectsMetric of the metric counting
+	// the objects it has pruned. Both are discovered when empty and are
+	// informational only.
+	PrunedMetric        string
+	PrunedObjectsMetric string
+
+	// ArchiveMetric is the name of the counter of checkpoints the node has
+	// fetched from the archive fallback instead of its peers, which is
+	// discovered when empty. Syncing from the archive changes the expected
+	// rate considerably.
+	ArchiveMetric string
+
+	// PeersMetric is the name of the gauge holding the number of peers the
+	// node is connected to, which is discovered when empty. A node without
+	// peers cannot sync, so the count helps explain a lack of progress.
+	PeersMetric string
+
+	// Mode selects what is watched: ModeNode (the default) watches the
+	// checkpoint catch-up only, ModeSnapshotRestore also the formal
+	// snapshot restore preceding it, reported as Progress.Snapshot, and
+	// ModeIndexer a sui-indexer, whose latest indexed checkpoint counts as
+	// synced and the tip of its fullnode as known. ModeGraphQL queries the
+	// Sui GraphQL service at Addr for the last checkpoint it serves, which
+	// is compared against TipURL or ReferenceAddr. ModeArchive watches the
+	// archival writer of an archival fullnode, whose last checkpoint
+	// uploaded to the archive counts as synced and the node's executed
+	// checkpoint as known, so that an archive falling behind the node is
+	// told from the node falling behind the network.
+	Mode string
+
+	// Source is where the node's watermarks are read from: SourceMetrics
+	// (the default), its Prometheus metrics, SourceGRPC, the gRPC API of
+	// newer sui-node builds at Addr, whose highest checkpoint counts as
+	// synced and which is compared against TipURL or ReferenceAddr, or
+	// SourceExec, the output of Command.
+	Source string
+
+	// Command is the program and arguments run on every poll with
+	// SourceExec, within ScrapeTimeout. It prints the known and synced
+	// checkpoints, and optionally the executed checkpoint and the epoch,
+	// either as a JSON object such as {"known": 120, "synced": 100} or as
+	// name=value lines. The known checkpoint is taken from TipURL or
+	// ReferenceAddr instead if either is set.
+	Command []string
+
+	// Track selects which watermarks decide whether the node has caught up:
+	// TrackCheckpoints (the default), TrackTransactions or TrackBoth.
+	// Checkpoint counts alone hide how much execution work remains when
+	// checkpoints are large.
+	Track string
+
+	// KnownTxMetric and ExecutedTxMetric are the names of the gauges holding
+	// the highest known and executed transaction sequence numbers, which are
+	// discovered when empty. They are only scraped when tracking
+	// transactions.
+	KnownTxMetric    string
+	ExecutedTxMetric string
+
+	// Consensus makes a validator's consensus lag, between the highest round
+	// it has received and the last round it has committed, be reported as
+	// Progress.Consensus. It is informational only. ReceivedRoundMetric and
+	// CommittedRoundMetric name the gauges holding the rounds, which are
+	// discovered when empty.
+	Consensus            bool
+	ReceivedRoundMetric  string
+	CommittedRoundMetric string
+
+	// TipURL, if set, is a Sui JSON-RPC endpoint, typically a public
+	// fullnode, whose latest checkpoint is used as the network tip instead
+	// of the node's own KnownMetric.
+	TipURL string
+
+	// TipURLs are further endpoints like TipURL. The network tip is then
+	// their TipQuorum: TipMedian (the default), the median of their latest
+	// checkpoints, which one stale or lying endpoint cannot skew, or TipMax,
+	// the highest. Endpoints that fail are left out until all of them do.
+	TipURLs   []string
+	TipQuorum string
+
+	// ReferenceAddr, if set, is the metrics endpoint of a known-healthy node
+	// whose synced checkpoint is used as the network tip. This catches a node
+	// whose own known checkpoint lags because of a bad peer set.
+	ReferenceAddr string
+
+	// HealthURL, if set, is probed on every scrape to tell whether the node
+	// also serves requests: a tcp://host:port address, e.g. of the JSON-RPC
+	// port, is connected to, and an HTTP URL such as a health endpoint must
+	// answer with a 2xx status. RequireHealthy makes the node count as
+	// caught up only while the probe succeeds.
+	HealthURL      string
+	RequireHealthy bool
+
+	// VerifyURL, if set, is the node's own JSON-RPC endpoint. Once the node
+	// has caught up, the digest of its latest checkpoint is compared with
+	// that of the same checkpoint on TrustedURL, which defaults to TipURL,
+	// and the node only counts as caught up when they match.
+	VerifyURL  string
+	TrustedURL string
+
+	// CaughtUpLag is the lag, in checkpoints, at or below which the node is
+	// considered caught up. A node compared against an external tip rarely
+	// reaches a lag of exactly zero.
+	CaughtUpLag float64
+
+	// RateSmoothing is the time constant of the moving average used to
+	// smooth the catch-up rate. Defaults to 30 seconds.
+	RateSmoothing time.Duration
+
+	// RateAlgorithm is how rates are estimated: RateEWMA, a moving average
+	// with RateSmoothing; RateWindowAverage, over the samples taken during
+	// RateWindow, which shows the throughput of bursty state sync more
+	// steadily; RateMedian, the median rate between the last RateSamples
+	// samples, which ignores outliers; or RateRegression, the slope of a
+	// line fitted through the last RateSamples samples. Defaults to
+	// RateWindowAverage if RateWindow is set and RateEWMA otherwise.
+	RateAlgorithm string
+
+	// RateWindow is the window of RateWindowAverage.
+	RateWindow time.Duration
+
+	// RateSamples is the number of samples of RateMedian and
+	// RateRegression. Defaults to DefaultRateSamples.
+	RateSamples int
+
+	// StallTimeout, if positive, makes Wait return a *StallError when the
+	// synced checkpoint does not advance for this long.
+	StallTimeout time.Duration
+
+	// MaxSyncRate, if positive, is the most checkpoints per second the
+	// node is expected to sync. The synced checkpoint advancing faster
+	// between two scrapes is reported as Progress.Jumped.
+	MaxSyncRate float64
+
+	// BehindThreshold is the lag, in checkpoints, beyond which a node that
+	// had caught up is reported as having fallen behind again by Follow.
+	BehindThreshold float64
+
+	// ScrapeTimeout, if positive, bounds each scrape as a whole, including
+	// any requests to the tip or reference endpoints.
+	ScrapeTimeout time.Duration
+
+	// MaxErrors, if positive, makes Wait return a *ScrapeError after this
+	// many consecutive failed scrapes.
+	MaxErrors int
+
+	// MaxBackoff caps the exponential backoff between retries after failed
+	// scrapes. Defaults to 30 seconds, and is raised to Interval if shorter,
+	// which the backoff starts from.
+	MaxBackoff time.Duration
+
+	// Retries is how many times a request to Addr, or to the GraphQL
+	// endpoint with ModeGraphQL, is retried within a scrape when it fails to
+	// connect, times out after AttemptTimeout or returns one of RetryStatus,
+	// rather than failing the scrape until the next tick. Requests to the
+	// tip, reference and Prometheus endpoints, and to the gRPC API of
+	// SourceGRPC, are not retried.
+	Retries int
+
+	// RetryStatus lists the HTTP status codes that are retried. Defaults to
+	// DefaultRetryStatus; other statuses fail the scrape at once.
+	RetryStatus []int
+
+	// AttemptTimeout, if positive, bounds each attempt of a request that is
+	// retried, within ScrapeTimeout.
+	AttemptTimeout time.Duration
+
+	// Transport is used for scrape requests. If nil, DefaultTransport is
+	// used.
+	Transport http.RoundTripper
+
+	// GRPCDialContext and GRPCTLSConfig connect to the gRPC API of
+	// SourceGRPC, which is spoken to over HTTP/2 rather than through
+	// Transport. They default to the DialContext and TLSClientConfig of
+	// Transport if it is an *http.Transport; otherwise New requires
+	// GRPCDialContext, and a nil GRPCTLSConfig uses the default TLS
+	// settings.
+	GRPCDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	GRPCTLSConfig   *tls.Config
+
+	// WrapGRPCTransport, if set, wraps the HTTP/2 transport of each gRPC
+	// endpoint, e.g. to add the credentials and request limit that
+	// Transport applies to requests to the node.
+	WrapGRPCTransport func(http.RoundTripper) http.RoundTripper
+
+	// ParseAll, if set, parses every family of the node's metrics pages.
+	// Otherwise only the families a Watcher may read are picked out of the
+	// text formats, without parsing the others, and reading stops once
+	// those the node exposes have been read, which saves most of the CPU
+	// and memory of scraping a page of megabytes.
+	ParseAll bool
+
+	// TolerantParsing, if set, skips the lines of the text formats that
+	// fail to parse, reported as Progress.ParseWarnings, rather than failing
+	// the scrape, so that one malformed metric does not lose the
+	// watermarks.
+	TolerantParsing bool
+
+	// RedialInterval, if positive, closes the idle connections of Transport
+	// this often, so that connections kept alive between scrapes are dialed
+	// anew now and then, e.g. to follow a load balancer or DNS change.
+	RedialInterval time.Duration
+
+	// Now returns the time at which scrapes are taken, from which rates and
+	// stalls are measured. Defaults to time.Now; a Transport replaying
+	// recorded scrapes can supply the times they were recorded at.
+	Now func() time.Time
+
+	// Inspect, if set, is called with the metric families of every scrape
+	// of Addr or query of PrometheusURL before they are read, e.g. to
+	// troubleshoot metrics that are missing or named unexpectedly.
+	Inspect func(families map[string]*dto.MetricFamily)
+}
+
+// Watcher periodically scrapes a node's metrics until the node has caught up.
+type Watcher struct {
+	opts   Options
+	events chan Progress
+
+	// knownMetric and syncedMetric are the metric names in use, once known.
+	knownMetric      string
+	syncedMetric     string
+	executedMetric   string
+	epochMetric      string
+	knownTxMetric    string
+	executedTxMetric string
+	peersMetric      string
+	dbSizeMetric     string
+	prunedMetric     string
+	prunedObjMetric  string
+	archiveMetric    string
+	receivedMetric   string
+	committedMetric  string
+	protocolMetric   string
+	uptimeMetric     string
+	startTimeMetric  string
+
+	// snapshot holds the names of the snapshot restore metrics, once known.
+	snapshot struct{ partitions, downloaded, objects, bytes string }
+	// process holds the names of the process metrics, once known.
+	process struct{ cpu, rss, fds, maxFDs, queue string }
+
+	lagExpr expr
+	pairs   []*pairWatch
+
+	// watchSet holds the names of the families the Watcher may read, once
+	// needed, and expected those the node's last page read whole had.
+	watchSet map[string]bool
+	expected map[string]bool
+	// pages caches the last page of each address for conditional
+	// requests.
+	pages map[string]*pageCache
+
+	// patterns holds the metric name options that are regular expressions.
+	patterns map[string]*regexp.Regexp
+
+	// endpoint indexes Addr followed by FallbackAddrs.
+	endpoint int
+
+	last         Progress
+	scrape       int
+	errors       int
+	checkpoints  gapTracker
+	transactions gapTracker
+	execution    gapTracker
+	consensus    gapTracker
+	dbGrowth     growthTracker
+	retention    growthTracker
+	partitions   gapTracker
+	throughput   growthTracker
+	tipGrowth    growthTracker
+	knownGrowth  growthTracker
+	syncGrowth   growthTracker
+	txGrowth     growthTracker
+	cpuGrowth    growthTracker
+	rand         *rand.Rand
+
+	// nodeTransport is Options.Transport retrying requests to the node.
+	nodeTransport http.RoundTripper
+	// grpcTransports are the HTTP/2 transports of the gRPC endpoints by
+	// URL, kept to reuse their connections.
+	grpcTransports map[string]http.RoundTripper
+	// dialed is when idle connections were last closed for
+	// Options.RedialInterval.
+	dialed time.Time
+
+	// archived is the archive counter at the last scrape exposing it.
+	archived    float64
+	hasArchived bool
+
+	// advanced is when the synced checkpoint, executed transaction or
+	// snapshot download last moved forward, or the lag expression shrank.
+	advanced time.Time
+	// restarts counts the restarts of the node seen, and hasUptime is set
+	// when the last successful scrape read its uptime, from which the
+	// process is estimated to have started at started.
+	restarts  int
+	hasUptime bool
+	started   time.Time
+	// caughtUp is set once the node has caught up at least once.
+	caughtUp bool
+	// epoch is the node's last known epoch and epochEntered when it was
+	// seen entering it, zero if it was already in it at the start.
+	epoch        float64
+	epochEntered time.Time
+	// endChecked is the last synced checkpoint checked for ending an epoch,
+	// and endsEpoch the result.
+	endChecked float64
+	endsEpoch  bool
+	// verification is the last one done since the node caught up.
+	verification *Verification
+}
+
+// New returns a Watcher for the given options.
+func New(opts Options) (*Watcher, error) {
+	if opts.Addr == "" && opts.PrometheusURL == "" && opts.Source != SourceExec {
+		return nil, errors.New("no metrics address specified")
+	}
+	if opts.TipURL != "" && opts.ReferenceAddr != "" {
+		return nil, errors.New("only one of a tip URL and a reference address may be specified")
+	}
+	if len(opts.TipURLs) > 0 && opts.TipURL == "" {
+		return nil, errors.New("further tip URLs need a tip URL")
+	}
+	switch opts.TipQuorum {
+	case "":
+		opts.TipQuorum = TipMedian
+	case TipMedian, TipMax:
+	default:
+		return nil, fmt.Errorf("unknown tip quorum %q", opts.TipQuorum)
+	}
+	var lagExpr expr
+	if opts.LagExpr != "" {
+		if opts.TipURL != "" || opts.ReferenceAddr != "" {
+			return nil, errors.New("a lag expression cannot be combined with a tip URL or a reference address")
+		}
+		var err error
+		if lagExpr, err = parseExpr(opts.LagExpr); err != nil {
+			return nil, err
+		}
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = ModeNode
+	case ModeNode, ModeSnapshotRestore, ModeIndexer, ModeArchive:
+	case ModeGraphQL:
+		if opts.TipURL == "" && opts.ReferenceAddr == "" {
+			return nil, errors.New("a GraphQL service can only be watched against a tip URL or a reference address")
+		}
+		if opts.PrometheusURL != "" || opts.LagExpr != "" {
+			return nil, errors.New("a GraphQL service can only be watched directly against a tip")
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q", opts.Mode)
+	}
+	switch opts.Source {
+	case "":
+		opts.Source = SourceMetrics
+	case SourceMetrics:
+	case SourceGRPC:
+		if opts.TipURL == "" && opts.ReferenceAddr == "" {
+			return nil, errors.New("the gRPC API of a node can only be watched against a tip URL or a reference address")
+		}
+		if opts.PrometheusURL != "" || opts.LagExpr != "" || opts.Mode != ModeNode {
+			return nil, errors.New("the gRPC API of a node can only be watched directly against a tip")
+		}
+	case SourceExec:
+		if len(opts.Command) == 0 {
+			return nil, errors.New("no command specified")
+		}
+		if opts.PrometheusURL != "" || opts.LagExpr != "" || opts.Mode != ModeNode {
+			return nil, errors.New("a command can only be watched directly")
+		}
+	default:
+		return nil, fmt.Errorf("unknown source %q", opts.Source)
+	}
+	if len(opts.Pairs) > 0 && (opts.Mode == ModeGraphQL || opts.Source != SourceMetrics) {
+		return nil, errors.New("pairs can only be watched on the node's metrics")
+	}
+	if opts.PairsOnly && len(opts.Pairs) == 0 {
+		return nil, errors.New("waiting for pairs only needs pairs")
+	}
+	if opts.RequireHealthy && opts.HealthURL == "" {
+		return nil, errors.New("requiring the node to be healthy needs a health URL")
+	}
+	if opts.VerifyURL != "" {
+		if opts.TrustedURL == "" {
+			opts.TrustedURL = opts.TipURL
+		}
+		if opts.TrustedURL == "" {
+			return nil, errors.New("verifying the node's checkpoints needs a trusted URL or a tip URL")
+		}
+	}
+	switch opts.Track {
+	case "":
+		opts.Track = TrackCheckpoints
+	case TrackCheckpoints, TrackTransactions, TrackBoth:
+	default:
+		return nil, fmt.Errorf("unknown track %q", opts.Track)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff < opts.Interval {
+		opts.MaxBackoff = opts.Interval
+	}
+	if opts.RateSmoothing <= 0 {
+		opts.RateSmoothing = 30 * time.Second
+	}
+	switch opts.RateAlgorithm {
+	case "":
+		opts.RateAlgorithm = RateEWMA
+		if opts.RateWindow > 0 {
+			opts.RateAlgorithm = RateWindowAverage
+		}
+	case RateEWMA, RateMedian, RateRegression:
+	case RateWindowAverage:
+		if opts.RateWindow <= 0 {
+			return nil, errors.New("the window rate algorithm needs a rate window")
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate algorithm %q", opts.RateAlgorithm)
+	}
+	if opts.RateSamples <= 0 {
+		opts.RateSamples = DefaultRateSamples
+	}
+	if opts.RateSamples < 2 {
+		opts.RateSamples = 2
+	}
+	if opts.Transport == nil {
+		opts.Transport = DefaultTransport()
+	}
+	if t, ok := opts.Transport.(*http.Transport); ok {
+		if opts.GRPCDialContext == nil {
+			opts.GRPCDialContext = t.DialContext
+		}
+		if opts.GRPCDialContext == nil {
+			opts.GRPCDialContext = (&net.Dialer{}).DialContext
+		}
+		if opts.GRPCTLSConfig == nil {
+			opts.GRPCTLSConfig = t.TLSClientConfig
+		}
+	}
+	if opts.Source == SourceGRPC && opts.GRPCDialContext == nil {
+		return nil, errors.New("the gRPC API of a node needs a dialer when the transport is not an *http.Transport")
+	}
+	if opts.Retries < 0 {
+		return nil, fmt.Errorf("invalid retries %d", opts.Retries)
+	}
+	if opts.RetryStatus == nil {
+		opts.RetryStatus = DefaultRetryStatus
+	}
+	for _, code := range opts.RetryStatus {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %d to retry", code)
+		}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	pairs, err := newPairWatches(opts)
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := metricPatterns(opts.KnownMetric, opts.SyncedMetric, opts.ExecutedMetric, opts.EpochMetric,
+		opts.DBSizeMetric, opts.PrunedMetric, opts.PrunedObjectsMetric, opts.ArchiveMetric, opts.PeersMetric,
+		opts.KnownTxMetric, opts.ExecutedTxMetric, opts.ReceivedRoundMetric, opts.CommittedRoundMetric)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		patterns:         patterns,
+		opts:             opts,
+		events:           make(chan Progress, 16),
+		knownMetric:      opts.KnownMetric,
+		syncedMetric:     opts.SyncedMetric,
+		executedMetric:   opts.ExecutedMetric,
+		epochMetric:      opts.EpochMetric,
+		knownTxMetric:    opts.KnownTxMetric,
+		executedTxMetric: opts.ExecutedTxMetric,
+		peersMetric:      opts.PeersMetric,
+		dbSizeMetric:     opts.DBSizeMetric,
+		prunedMetric:     opts.PrunedMetric,
+		prunedObjMetric:  opts.PrunedObjectsMetric,
+		archiveMetric:    opts.ArchiveMetric,
+		receivedMetric:   opts.ReceivedRoundMetric,
+		committedMetric:  opts.CommittedRoundMetric,
+		checkpoints:      newGapTracker(opts),
+		transactions:     newGapTracker(opts),
+		execution:        newGapTracker(opts),
+		consensus:        newGapTracker(opts),
+		dbGrowth:         newGrowthTracker(opts.RateSmoothing),
+		retention:        newGrowthTracker(opts.RateSmoothing),
+		partitions:       newGapTracker(opts),
+		throughput:       newGrowthTracker(opts.RateSmoothing),
+		tipGrowth:        newGrowthTracker(opts.RateSmoothing),
+		knownGrowth:      newGrowthTracker(opts.RateSmoothing),
+		syncGrowth:       newGrowthTracker(opts.RateSmoothing),
+		txGrowth:         newGrowthTracker(opts.RateSmoothing),
+		cpuGrowth:        newGrowthTracker(opts.RateSmoothing),
+		lagExpr:          lagExpr,
+		pairs:            pairs,
+		nodeTransport:    newRetryTransport(opts.Transport, opts),
+		grpcTransports:   map[string]http.RoundTripper{},
+		rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Events returns the channel on which progress is reported, one Progress per
+// scrape. The channel is closed when Wait or Follow returns. Events are
+// dropped rather than blocking the watcher if the channel is not drained.
+func (w *Watcher) Events() <-chan Progress {
+	return w.events
+}
+
+// Seed feeds earlier observations of the node, oldest first, to the
+// checkpoint rate, e.g. those recorded before a restart of the program, so
+// that Rate and ETA are meaningful from the first scrape. It must be called
+// before Wait, Follow or Check.
+func (w *Watcher) Seed(history []Observation) {
+	for _, o := range history {
+		w.checkpoints.update(o.Known, o.Synced, o.Time)
+	}
+}
+
+// Wait scrapes the node every interval until it has caught up, returning nil,
+// or until ctx is done, returning ctx.Err(). Only one of Wait and Follow may
+// be called, and only once.
+func (w *Watcher) Wait(ctx context.Context) error {
+	return w.run(ctx, false)
+}
+
+// Follow is like Wait but keeps watching the node after it has caught up,
+// reporting Progress.FellBehind when its lag exceeds Options.BehindThreshold.
+// It returns only when ctx is done or the node stalls.
+func (w *Watcher) Follow(ctx context.Context) error {
+	return w.run(ctx, true)
+}
+
+// Check scrapes the node once and returns the resulting Progress, with the
+// scrape error, if any, also returned as Progress.Err. Rates are only known
+// from the second call on. Check must not be called concurrently with itself,
+// Wait or Follow.
+func (w *Watcher) Check(ctx context.Context) (Progress, error) {
+	p := w.poll(ctx)
+	return p, p.Err
+}
+
+func (w *Watcher) run(ctx context.Context, follow bool) error {
+	defer close(w.events)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for {
+		start := time.Now()
+		p := w.poll(ctx)
+		if ctx.Err() != nil {
+			// The scrape was most likely aborted, so p is meaningless.
+			return ctx.Err()
+		}
+		select {
+		case w.events <- p:
+		default:
+		}
+		if p.CaughtUp && !follow {
+			return nil
+		}
+		if p.Stalled {
+			return &StallError{Synced: p.Synced, Known: p.Known, For: p.StalledFor, AtEpochEnd: p.AtEpochEnd, Epoch: p.Epoch}
+		}
+		if p.Err != nil && w.opts.MaxErrors > 0 && p.Errors >= w.opts.MaxErrors {
+			return &ScrapeError{Errors: p.Errors, Err: p.Err}
+		}
+
+		interval := w.interval(p)
+		delay := interval - time.Since(start)
+		if delay < 0 {
+			// The scrape overran the interval. Skip the ticks it overlapped
+			// rather than scraping back to back.
+			delay += (-delay/interval + 1) * interval
+		}
+		if p.Err != nil {
+			delay = p.RetryAt.Sub(p.Time)
+		}
+		timer.Reset(delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// interval returns how long after p the next scrape is due, see
+// Options.MaxInterval.
+func (w *Watcher) interval(p Progress) time.Duration {
+	if w.opts.MaxInterval <= w.opts.Interval || p.CaughtUp {
+		return w.opts.Interval
+	}
+	d := p.ETA / 100
+	if d < w.opts.Interval {
+		return w.opts.Interval
+	}
+	if d > w.opts.MaxInterval {
+		return w.opts.MaxInterval
+	}
+	return d
+}
+
+// closeIdleConnections closes the connections kept alive by the transports,
+// so that the next requests dial anew.
+func (w *Watcher) closeIdleConnections() {
+	(&http.Client{Transport: w.nodeTransport}).CloseIdleConnections()
+	(&http.Client{Transport: w.opts.Transport}).CloseIdleConnections()
+	for _, t := range w.grpcTransports {
+		(&http.Client{Transport: t}).CloseIdleConnections()
+	}
+}
+
+// backoff returns how long to wait before retrying after the given number of
+// consecutive failed scrapes: the interval doubled for each failure, capped
+// at Options.MaxBackoff, of which a random half is waited ("equal jitter") so
+// that many watchers do not retry a recovering node in lockstep.
+func (w *Watcher) backoff(errors int) time.Duration {
+	d := w.opts.Interval
+	for i := 1; i < errors && d < w.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.opts.MaxBackoff {
+		d = w.opts.MaxBackoff
+	}
+	return d/2 + time.Duration(w.rand.Int63n(int64(d/2)+1))
+}
+
+// poll scrapes the node once and derives the next Progress from the result.
+func (w *Watcher) poll(ctx context.Context) Progress {
+	if w.opts.ScrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.opts.ScrapeTimeout)
+		defer cancel()
+	}
+	if w.opts.RedialInterval > 0 && time.Since(w.dialed) >= w.opts.RedialInterval {
+		w.closeIdleConnections()
+		w.dialed = time.Now()
+	}
+	start := time.Now()
+	s, err := w.fetch(ctx)
+	if err != nil {
+		w.errors++
+		p := w.last
+		p.Time = w.opts.Now()
+		p.ScrapeDuration = time.Since(start)
+		p.Err = err
+		p.Errors = w.errors
+		p.RetryAt = p.Time.Add(w.backoff(w.errors))
+		var throttled *RetryAfterError
+		if errors.As(err, &throttled) && throttled.After > 0 {
+			p.RetryAt = p.Time.Add(throttled.After)
+		}
+		p.Endpoint = redactURL(w.endpointAddr())
+		return p
+	}
+	w.errors = 0
+
+	now := w.opts.Now()
+	// A node restarted from an older snapshot or with its database wiped
+	// goes back, and one restoring a formal snapshot leaps ahead: either
+	// would make a nonsensical rate, so the rates start over instead.
+	var regressed, jumped bool
+	if w.scrape > 0 && s.hasCheckpoints && w.lagExpr == nil && w.last.Synced != 0 {
+		regressed = s.synced < w.last.Synced
+		if dt := now.Sub(w.last.Time).Seconds(); w.opts.MaxSyncRate > 0 && dt > 0 {
+			jumped = (s.synced-w.last.Synced)/dt > w.opts.MaxSyncRate
+		}
+		if regressed || jumped {
+			w.resetRates()
+		}
+	}
+	// Checkpoints missing from a sample, e.g. during a snapshot restore,
+	// must not count as a lag of zero for the rate.
+	var g Gap
+	switch {
+	case w.lagExpr != nil:
+		g = w.checkpoints.update(s.exprLag, 0, now)
+	case s.hasCheckpoints:
+		g = w.checkpoints.update(s.known, s.synced, now)
+	}
+	p := Progress{
+		Time:            now,
+		ScrapeDuration:  time.Since(start),
+		Known:           s.known,
+		Synced:          s.synced,
+		Lag:             g.Lag,
+		Rate:            g.Rate,
+		InstantRate:     g.InstantRate,
+		ETA:             g.ETA,
+		Epoch:           s.epoch,
+		NetworkEpoch:    s.networkEpoch,
+		Protocol:        s.protocol,
+		NetworkProtocol: s.networkProtocol,
+		KnownMetric:     w.knownMetric,
+		SyncedMetric:    w.syncedMetric,
+		LagExpr:         w.opts.LagExpr,
+		Endpoint:        redactURL(w.endpointAddr()),
+		RemoteAddr:      s.remoteAddr,
+		ParseWarnings:   s.parseWarnings,
+		NotModified:     s.notModified,
+		Version:         s.version,
+		Identity:        s.identity,
+	}
+	if regressed || jumped {
+		p.Regressed, p.Jumped, p.PreviousSynced = regressed, jumped, w.last.Synced
+	}
+	if s.hasCheckpoints && w.lagExpr == nil {
+		p.NetworkRate = w.knownGrowth.update(s.known, now)
+		p.SyncRate = w.syncGrowth.update(s.synced, now)
+		p.ClosureRate = p.SyncRate - p.NetworkRate
+	}
+	if s.epoch > w.epoch {
+		if w.epoch != 0 {
+			p.EpochChanged = true
+			if !w.epochEntered.IsZero() {
+				p.EpochDuration = now.Sub(w.epochEntered)
+			}
+			w.epochEntered = now
+		}
+		w.epoch = s.epoch
+	}
+	switch {
+	case s.hasUptime && s.notModified && w.hasUptime:
+		// A page reused unchanged holds the uptime of an earlier scrape,
+		// which says nothing of a restart since.
+		p.Uptime = now.Sub(w.started)
+	case s.hasUptime:
+		p.Uptime = time.Duration(s.uptime * float64(time.Second))
+		// A process that started after the one of the previous scrape
+		// restarted in between, however briefly it was down. The start
+		// is told from the uptime within the second of uptimes in whole
+		// seconds and the time the scrapes took, as the uptime is read
+		// at some point during each.
+		started := now.Add(-p.Uptime)
+		tolerance := time.Second + p.ScrapeDuration + w.last.ScrapeDuration
+		if w.hasUptime && started.Sub(w.started) > tolerance {
+			p.Restarted = true
+			w.restarts++
+		}
+		w.started = started
+	}
+	p.Restarts = w.restarts
+	w.hasUptime = s.hasUptime
+	if s.hasExecuted {
+		p.Executed = s.executed
+		p.ExecutionLag = s.known - s.executed
+		p.ExecutedMetric = w.executedMetric
+		execution := w.execution.update(s.synced, s.executed, now)
+		p.Execution = &execution
+	}
+	if s.hasPeers {
+		p.Peers = s.peers
+		p.PeersMetric = w.peersMetric
+	}
+	if s.hasDBSize {
+		p.DBSize = s.dbSize
+		p.DBGrowth = w.dbGrowth.update(s.dbSize, now)
+		p.DBSizeMetric = w.dbSizeMetric
+	}
+	if s.hasPruned {
+		p.Pruned = s.pruned
+		p.Retained = s.synced - s.pruned
+		p.RetainedGrowth = w.retention.update(p.Retained, now)
+		p.PrunedObjects = s.prunedObjects
+		p.PrunedMetric = w.prunedMetric
+	}
+	if s.hasArchived {
+		p.FromArchive = w.hasArchived && s.archived > w.archived
+		p.ArchiveMetric = w.archiveMetric
+		w.archived, w.hasArchived = s.archived, true
+	}
+	if s.hasSnapshot {
+		p.Snapshot = &Snapshot{
+			Partitions: w.partitions.update(s.partitions, s.downloaded, now),
+			Objects:    s.objects,
+			Bytes:      s.bytes,
+			Throughput: w.throughput.update(s.bytes, now),
+			Done:       s.partitions > 0 && s.downloaded >= s.partitions,
+		}
+	}
+	if s.hasExecutedTx {
+		// The counter restarts from zero with the node.
+		if tps := w.txGrowth.update(s.executedTx, now); tps > 0 {
+			p.TPS = tps
+		}
+		p.ExecutedTxMetric = w.executedTxMetric
+	}
+	if s.hasProcess {
+		process := s.process
+		if s.hasCPU {
+			if cpu := w.cpuGrowth.update(s.cpuSeconds, now); cpu > 0 {
+				process.CPU = cpu
+			}
+		}
+		p.Process = &process
+	}
+	if s.hasTx {
+		tx := w.transactions.update(s.knownTx, s.executedTx, now)
+		p.Transactions = &tx
+	}
+	if s.hasRounds {
+		rounds := w.consensus.update(s.receivedRound, s.committedRound, now)
+		p.Consensus = &rounds
+	}
+	if w.opts.HealthURL != "" {
+		p.Health = w.checkHealth(ctx)
+		p.ScrapeDuration = time.Since(start)
+	}
+
+	checkpointsDone := (s.known != 0 || w.lagExpr != nil) && p.Lag <= w.opts.CaughtUpLag
+	if w.opts.RequireExecuted {
+		checkpointsDone = checkpointsDone && p.ExecutionLag <= w.opts.CaughtUpLag
+	}
+	transactionsDone := s.hasTx && s.knownTx != 0 && p.Transactions.Lag <= 0
+	switch w.opts.Track {
+	case TrackCheckpoints:
+		p.CaughtUp = checkpointsDone
+	case TrackTransactions:
+		p.CaughtUp = transactionsDone
+	case TrackBoth:
+		p.CaughtUp = checkpointsDone && transactionsDone
+	}
+	if w.opts.RequireHealthy {
+		p.CaughtUp = p.CaughtUp && p.Health.Healthy
+	}
+	if w.opts.VerifyURL != "" {
+		switch {
+		case !p.CaughtUp:
+			w.verification = nil
+		case w.verification == nil || !w.verification.Verified:
+			w.verification = w.verify(ctx)
+			p.ScrapeDuration = time.Since(start)
+		}
+		if p.CaughtUp {
+			p.Verification = w.verification
+			p.CaughtUp = w.verification.Verified
+		}
+	}
+	if len(w.pairs) > 0 {
+		p.Pairs = w.updatePairs(s.pairs, now)
+		if w.opts.PairsOnly {
+			p.CaughtUp = pairsReached(p.Pairs)
+		} else {
+			p.CaughtUp = p.CaughtUp && pairsReached(p.Pairs)
+		}
+	}
+	if p.CaughtUp {
+		w.caughtUp = true
+	}
+	// Behind the network is not behind a condition waited for instead.
+	p.FellBehind = w.caughtUp && !w.opts.PairsOnly && p.Lag > w.opts.BehindThreshold
+
+	if w.scrape == 0 || s.synced > w.last.Synced ||
+		(s.hasTx && w.last.Transactions != nil && s.executedTx > w.last.Transactions.Current) ||
+		(s.hasSnapshot && w.last.Snapshot != nil && s.bytes > w.last.Snapshot.Bytes) ||
+		(w.lagExpr != nil && p.Lag < w.last.Lag) {
+		w.advanced = p.Time
+	}
+	p.StalledFor = p.Time.Sub(w.advanced)
+	p.Stalled = !p.CaughtUp && w.opts.StallTimeout > 0 && p.StalledFor >= w.opts.StallTimeout
+	if !p.CaughtUp && p.StalledFor > 0 && s.synced != 0 {
+		p.AtEpochEnd = w.atEpochEnd(ctx, s.synced)
+	}
+
+	w.scrape++
+	w.last = p
+	return p
+}
+
+// resetRates forgets the samples of the rates derived from the synced
+// checkpoint.
+func (w *Watcher) resetRates() {
+	w.checkpoints = newGapTracker(w.opts)
+	w.execution = newGapTracker(w.opts)
+	w.syncGrowth = newGrowthTracker(w.opts.RateSmoothing)
+	w.retention = newGrowthTracker(w.opts.RateSmoothing)
+}
+
+// endpointAddr returns the node's endpoint in use, if it is scraped directly.
+func (w *Watcher) endpointAddr() string {
+	if w.endpoint == 0 {
+		return w.opts.Addr
+	}
+	return w.opts.FallbackAddrs[w.endpoint-1]
+}
+
+// failover calls scrape with the node's endpoint in use and, if that fails,
+// with the other endpoints in order until one succeeds, which becomes the
+// endpoint in use. The error is that of the endpoint that was in use.
+func (w *Watcher) failover(ctx context.Context, scrape func(addr string) error) error {
+	err := scrape(w.endpointAddr())
+	if err == nil || len(w.opts.FallbackAddrs) == 0 {
+		return err
+	}
+	current := w.endpoint
+	for i := 0; i <= len(w.opts.FallbackAddrs) && ctx.Err() == nil; i++ {
+		if i == current {
+			continue
+		}
+		w.endpoint = i
+		if scrape(w.endpointAddr()) == nil {
+			return nil
+		}
+	}
+	w.endpoint = current
+	return err
+}
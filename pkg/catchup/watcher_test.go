@@ -0,0 +1,213 @@
+package catchup
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rpcpool/sui-catchup/pkg/mockmetrics"
+)
+
+// fakeClock is a clock shared by a mock node and a watcher, advanced by
+// tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newMockWatcher returns a watcher of a mock node configured by cfg, both on
+// clock, with opts for the rest.
+func newMockWatcher(t *testing.T, cfg mockmetrics.Config, clock *fakeClock, opts Options) (*Watcher, *mockmetrics.Node) {
+	t.Helper()
+	if clock != nil {
+		cfg.Now, opts.Now = clock.Now, clock.Now
+	}
+	node := mockmetrics.New(cfg)
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+	opts.Addr = srv.URL + "/metrics"
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, node
+}
+
+func TestWatcherCheck(t *testing.T) {
+	clock := newFakeClock()
+	w, _ := newMockWatcher(t, mockmetrics.Config{Known: 1000, Synced: 900, TipRate: 1, SyncRate: 5}, clock, Options{})
+
+	p, err := w.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Known != 1000 || p.Synced != 900 || p.Lag != 100 || p.CaughtUp {
+		t.Errorf("first check: got known %v, synced %v, lag %v, caught up %v", p.Known, p.Synced, p.Lag, p.CaughtUp)
+	}
+	if p.Rate != 0 || p.ETA != 0 {
+		t.Errorf("first check: got rate %v and ETA %v before a second scrape", p.Rate, p.ETA)
+	}
+
+	clock.advance(10 * time.Second)
+	p, err = w.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The node synced 50 checkpoints while the network produced 10.
+	if p.Known != 1010 || p.Synced != 950 || p.Lag != 60 {
+		t.Errorf("second check: got known %v, synced %v, lag %v", p.Known, p.Synced, p.Lag)
+	}
+	if math.Abs(p.Rate-4) > 1e-9 || math.Abs(p.SyncRate-5) > 1e-9 {
+		t.Errorf("second check: got rate %v and sync rate %v, want 4 and 5", p.Rate, p.SyncRate)
+	}
+	if p.ETA != 15*time.Second {
+		t.Errorf("second check: got ETA %v, want 15s", p.ETA)
+	}
+}
+
+func TestWatcherCaughtUpLag(t *testing.T) {
+	tests := []struct {
+		lag         int
+		caughtUpLag float64
+		want        bool
+	}{
+		{0, 0, true},
+		{5, 0, false},
+		{5, 5, true},
+		{6, 5, false},
+	}
+	for _, tt := range tests {
+		w, _ := newMockWatcher(t, mockmetrics.Config{Known: 1000, Synced: 1000 - float64(tt.lag)}, newFakeClock(), Options{CaughtUpLag: tt.caughtUpLag})
+		p, err := w.Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.CaughtUp != tt.want {
+			t.Errorf("lag %d, caught up lag %v: got caught up %v, want %v", tt.lag, tt.caughtUpLag, p.CaughtUp, tt.want)
+		}
+	}
+}
+
+func TestWatcherRegressed(t *testing.T) {
+	clock := newFakeClock()
+	w, node := newMockWatcher(t, mockmetrics.Config{Known: 1000, Synced: 900}, clock, Options{})
+	if _, err := w.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// As a node restored from an older snapshot.
+	node.Set(1000, 500)
+	clock.advance(time.Second)
+	p, err := w.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Regressed || p.PreviousSynced != 900 {
+		t.Errorf("got regressed %v from %v, want from 900", p.Regressed, p.PreviousSynced)
+	}
+}
+
+func TestWatcherWait(t *testing.T) {
+	w, _ := newMockWatcher(t, mockmetrics.Config{Known: 100, Synced: 0, SyncRate: 2000}, nil, Options{Interval: 10 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan Progress)
+	go func() {
+		var last Progress
+		for p := range w.Events() {
+			last = p
+		}
+		done <- last
+	}()
+	if err := w.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	last := <-done
+	if !last.CaughtUp || last.Synced != 100 {
+		t.Errorf("last progress: got caught up %v at %v, want at 100", last.CaughtUp, last.Synced)
+	}
+}
+
+func TestWatcherWaitCanceled(t *testing.T) {
+	w, _ := newMockWatcher(t, mockmetrics.Config{Known: 100, Synced: 0}, nil, Options{Interval: 10 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWatcherRestarted(t *testing.T) {
+	clock := newFakeClock()
+	w, node := newMockWatcher(t, mockmetrics.Config{Known: 1000, Synced: 900}, clock, Options{})
+	check := func() Progress {
+		t.Helper()
+		p, err := w.Check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	check()
+	clock.advance(10 * time.Second)
+	if p := check(); p.Restarted || p.Restarts != 0 {
+		t.Errorf("got restarted %v (%d restarts) while up", p.Restarted, p.Restarts)
+	}
+	node.Restart()
+	clock.advance(5 * time.Second)
+	if p := check(); !p.Restarted || p.Restarts != 1 || p.Uptime != 5*time.Second {
+		t.Errorf("got restarted %v (%d restarts) up %v, want restarted up 5s", p.Restarted, p.Restarts, p.Uptime)
+	}
+}
+
+func TestWatcherNotModifiedNotRestarted(t *testing.T) {
+	clock := newFakeClock()
+	node := mockmetrics.New(mockmetrics.Config{Known: 1000, Synced: 900, Now: clock.Now})
+	// As a caching proxy answering that the page has not changed.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		node.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	w, err := New(Options{Addr: srv.URL + "/metrics", Now: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	p, err := w.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Restarted || p.Restarts != 0 {
+		t.Errorf("got restarted %v (%d restarts) from a page reused unchanged", p.Restarted, p.Restarts)
+	}
+	if p.Uptime != time.Minute {
+		t.Errorf("got uptime %v, want 1m0s", p.Uptime)
+	}
+}
@@ -0,0 +1,172 @@
+// Package mockmetrics serves the Prometheus metrics of a synthetic Sui node,
+// whose watermarks advance at set rates under the names sui-node uses, so
+// that programs embedding pkg/catchup can be tested end to end without a
+// real node. A Node is an http.Handler, which makes for a test server with
+// net/http/httptest:
+//
+//	node := mockmetrics.New(mockmetrics.Config{Known: 100000, Synced: 90000, TipRate: 4, SyncRate: 50})
+//	srv := httptest.NewServer(node)
+//	defer srv.Close()
+//	w, err := catchup.New(catchup.Options{Addr: srv.URL + "/metrics"})
+package mockmetrics
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultEpochLength is the number of checkpoints per epoch unless
+// Config.EpochLength says otherwise.
+const DefaultEpochLength = 10000
+
+// Config describes a synthetic node. Fields left zero are reported as zero,
+// except those documented to have a default.
+type Config struct {
+	// Known is the highest known checkpoint the node starts at and Synced
+	// its highest synced one.
+	Known  float64
+	Synced float64
+
+	// TipRate is how many checkpoints per second the known checkpoint
+	// advances by, as the network produces them, and SyncRate how many the
+	// synced one advances by, up to the known one.
+	TipRate  float64
+	SyncRate float64
+
+	// Peers is the number of peers the node reports.
+	Peers int
+
+	// Protocol is the highest protocol version the node supports and the
+	// network's current one.
+	Protocol int
+
+	// Version is the version the node labels its uptime with, and Validator
+	// the validator name, if not empty.
+	Version   string
+	Validator string
+
+	// EpochLength is the number of checkpoints per epoch. Defaults to
+	// DefaultEpochLength.
+	EpochLength float64
+
+	// Now returns the current time, so that tests can advance the node on a
+	// fake clock. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Node is a synthetic node deriving its watermarks from the time since it
+// started. It is safe for concurrent use.
+type Node struct {
+	cfg     Config
+	handler http.Handler
+
+	mu sync.Mutex
+	// known and synced are the watermarks at since, and started is when
+	// the node's process started, for its uptime.
+	known, synced float64
+	since         time.Time
+	started       time.Time
+}
+
+// New returns a node starting now as configured by cfg.
+func New(cfg Config) *Node {
+	if cfg.EpochLength <= 0 {
+		cfg.EpochLength = DefaultEpochLength
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	now := cfg.Now()
+	n := &Node{cfg: cfg, known: cfg.Known, synced: cfg.Synced, since: now, started: now}
+	n.handler = promhttp.HandlerFor(n.Registry(), promhttp.HandlerOpts{})
+	return n
+}
+
+// ServeHTTP serves the node's metrics in the text exposition format, on any
+// path.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.handler.ServeHTTP(w, r)
+}
+
+// Known returns the node's highest known checkpoint.
+func (n *Node) Known() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.knownAt(n.cfg.Now())
+}
+
+// Synced returns the node's highest synced checkpoint.
+func (n *Node) Synced() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.syncedAt(n.cfg.Now())
+}
+
+func (n *Node) knownAt(now time.Time) float64 {
+	return math.Floor(n.known + n.cfg.TipRate*now.Sub(n.since).Seconds())
+}
+
+func (n *Node) syncedAt(now time.Time) float64 {
+	synced := math.Floor(n.synced + n.cfg.SyncRate*now.Sub(n.since).Seconds())
+	return math.Min(synced, n.knownAt(now))
+}
+
+// Set moves the watermarks to known and synced, from where they advance
+// on, e.g. back to tell how a node restored from an older snapshot is
+// reported or ahead for one that restored a formal snapshot.
+func (n *Node) Set(known, synced float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.known, n.synced, n.since = known, synced, n.cfg.Now()
+}
+
+// Restart restarts the node's process, resetting its uptime, while its
+// watermarks advance as before.
+func (n *Node) Restart() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.started = n.cfg.Now()
+}
+
+func (n *Node) uptime() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return math.Floor(n.cfg.Now().Sub(n.started).Seconds())
+}
+
+// Registry returns a registry of the node's metrics under the names
+// sui-node uses, e.g. to serve them along with others.
+func (n *Node) Registry() *prometheus.Registry {
+	gauges := []struct {
+		name, help string
+		value      func() float64
+	}{
+		{"highest_known_checkpoint", "Highest known checkpoint.", n.Known},
+		{"highest_synced_checkpoint", "Highest synced checkpoint.", n.Synced},
+		{"highest_executed_checkpoint", "Highest executed checkpoint.", n.Synced},
+		{"current_epoch", "Current epoch.", func() float64 { return math.Floor(n.Synced() / n.cfg.EpochLength) }},
+		{"network_peers", "Number of connected peers.", func() float64 { return float64(n.cfg.Peers) }},
+		{"current_protocol_version", "Current protocol version.", func() float64 { return float64(n.cfg.Protocol) }},
+		{"max_supported_protocol_version", "Highest supported protocol version.", func() float64 { return float64(n.cfg.Protocol) }},
+	}
+	registry := prometheus.NewRegistry()
+	for _, g := range gauges {
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: g.name, Help: g.help}, g.value))
+	}
+	// Like sui-node, the version is a label of the uptime.
+	labels := prometheus.Labels{"version": n.cfg.Version}
+	if n.cfg.Validator != "" {
+		labels["validator"] = n.cfg.Validator
+	}
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "uptime",
+		Help:        "Uptime of the node in seconds.",
+		ConstLabels: labels,
+	}, n.uptime))
+	return registry
+}